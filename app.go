@@ -6,7 +6,10 @@ import (
 	"lanvochat/database"
 	"lanvochat/network"
 	"log"
-	"math/rand"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/wailsapp/wails/v2/pkg/runtime"
@@ -15,21 +18,33 @@ import (
 // App struct
 type App struct {
 	ctx            context.Context
+	profileName    string
+	profile        *profile
 	db             *database.Database
-	networkManager *network.NetworkManager
+	store          *messageStore
+	networkManager atomic.Pointer[network.NetworkManager]
 	localPeerID    string
-	localName      string
-}
+	localIP        string
+	workspace      *network.Workspace
+	access         *network.AccessPolicy
+	lock           appLock
+
+	// netMu serializes starting, stopping and swapping networkManager and
+	// changing the workspace; readers load networkManager without it
+	netMu sync.Mutex
 
-// NewApp creates a new App application struct
-func NewApp() *App {
-	// Generate unique peer ID
-	rand.Seed(time.Now().UnixNano())
-	peerID := fmt.Sprintf("peer_%d", rand.Int63())
+	// localName is announced to peers: the display name setting, or the
+	// identity's name (identityName) while that is empty
+	nameMu       sync.RWMutex
+	localName    string
+	identityName string
+}
 
+// NewApp creates a new App application struct for the named profile
+func NewApp(profileName string) *App {
 	return &App{
-		localPeerID: peerID,
-		localName:   "LanvoChat User", // Default name, can be changed later
+		profileName: profileName,
+		access:      network.NewAccessPolicy(),
 	}
 }
 
@@ -38,12 +53,62 @@ func NewApp() *App {
 func (a *App) startup(ctx context.Context) {
 	a.ctx = ctx
 
+	// Locate the profile's files and load the identity peers know us by
+	p, err := openProfile(a.profileName)
+	if err != nil {
+		log.Fatal("Failed to open profile:", err)
+	}
+	a.profile = p
+	id, err := p.loadIdentity()
+	if err != nil {
+		log.Fatal("Failed to load identity:", err)
+	}
+	a.localPeerID = id.PeerID
+	a.identityName = id.Name
+	if a.workspace, err = p.loadWorkspace(); err != nil {
+		log.Printf("Warning: Failed to load workspace, using open discovery: %v", err)
+	}
+	if err := p.migrateStrayDatabase(); err != nil {
+		log.Printf("Warning: Failed to move old database into profile: %v", err)
+	}
+
 	// Initialize database
-	db, err := database.NewDatabase("lanvochat.db")
+	db, err := database.NewDatabase(p.databasePath())
 	if err != nil {
 		log.Fatal("Failed to initialize database:", err)
 	}
 	a.db = db
+	a.localName = a.identityName
+	if settings, err := db.GetSettings(); err != nil {
+		log.Printf("Warning: Failed to load display name: %v", err)
+	} else if settings.DisplayName != "" {
+		a.localName = settings.DisplayName
+	}
+	if err := db.MarkOwnMessagesRead(a.localPeerID); err != nil {
+		log.Printf("Warning: Failed to correct unread counts: %v", err)
+	}
+	a.store = newMessageStore(db, a.localPeerID)
+	a.store.onIncoming = a.emitUnreadCounts
+	if db.IsLocked() {
+		log.Println("Database is encrypted; waiting for UnlockDatabase")
+	}
+
+	// Start locked if a PIN is set, before any content can be requested
+	a.initAppLock(ctx)
+
+	// Apply retention policies and write scheduled backups in the background
+	a.startPruner(ctx)
+	a.startBackupScheduler(ctx)
+
+	// Nobody is online until they announce themselves again
+	if err := db.MarkAllPeersOffline(); err != nil {
+		log.Printf("Warning: Failed to reset peer status: %v", err)
+	}
+
+	// Load block/allow rules before any peer is accepted
+	if err := a.reloadAccessPolicy(); err != nil {
+		log.Printf("Warning: Failed to load access rules: %v", err)
+	}
 
 	// Get local IP address
 	localIP, err := a.getLocalIP()
@@ -51,26 +116,75 @@ func (a *App) startup(ctx context.Context) {
 		log.Printf("Warning: Could not determine local IP: %v", err)
 		localIP = "127.0.0.1"
 	}
-
-	// Initialize network manager
-	a.networkManager = network.NewNetworkManager(a.localPeerID, a.localName, localIP)
-	a.networkManager.SetContext(ctx)
-	a.networkManager.SetDatabase(a.db)
+	a.localIP = localIP
 
 	// Start network operations
-	if err := a.networkManager.Start(); err != nil {
+	a.netMu.Lock()
+	if err := a.startNetwork(); err != nil {
 		log.Printf("Warning: Failed to start network manager: %v", err)
 	}
+	a.netMu.Unlock()
 
 	fmt.Println("Database initialized successfully")
-	fmt.Printf("Network manager started for peer: %s (%s)\n", a.localName, a.localPeerID)
+	fmt.Printf("Network manager started for peer: %s (%s)\n", a.currentName(), a.localPeerID)
+}
+
+// startNetwork creates and starts a network manager for the current
+// workspace. Callers hold netMu.
+func (a *App) startNetwork() error {
+	nm := network.NewNetworkManager(a.localPeerID, a.currentName(), a.localIP)
+	nm.SetConfig(a.loadNetworkConfig())
+	nm.SetContext(a.ctx)
+	nm.SetStore(a.store)
+	nm.SetPeerStore(a.store)
+	nm.SetWorkspace(a.workspace)
+	nm.SetAccessPolicy(a.access)
+
+	// Published before the lock state is read, so a concurrent LockApp
+	// either sees this manager or is seen by it
+	a.networkManager.Store(nm)
+	nm.SetEventsHeld(a.IsAppLocked())
+
+	return nm.Start()
+}
+
+// stopNetwork stops the running network manager, if any. Callers hold netMu.
+func (a *App) stopNetwork() {
+	if nm := a.networkManager.Swap(nil); nm != nil {
+		nm.Stop()
+	}
+}
+
+// restartNetwork stops the running network manager and starts a fresh one
+func (a *App) restartNetwork() error {
+	a.netMu.Lock()
+	defer a.netMu.Unlock()
+
+	a.stopNetwork()
+	return a.startNetwork()
+}
+
+// currentNetwork returns the running network manager, or nil while
+// networking is stopped or restarting
+func (a *App) currentNetwork() *network.NetworkManager {
+	return a.networkManager.Load()
+}
+
+// requireNetwork returns the running network manager, or an error while
+// there is none
+func (a *App) requireNetwork() (*network.NetworkManager, error) {
+	nm := a.currentNetwork()
+	if nm == nil {
+		return nil, fmt.Errorf("network manager not initialized")
+	}
+	return nm, nil
 }
 
 // shutdown is called at application termination
 func (a *App) shutdown(ctx context.Context) {
-	if a.networkManager != nil {
-		a.networkManager.Stop()
-	}
+	a.netMu.Lock()
+	a.stopNetwork()
+	a.netMu.Unlock()
 	if a.db != nil {
 		a.db.Close()
 	}
@@ -83,22 +197,544 @@ func (a *App) Greet(name string) string {
 
 // SaveMessage saves a message to the database
 func (a *App) SaveMessage(peerID, senderID, content string) error {
+	if err := a.requireUnlocked(); err != nil {
+		return err
+	}
 	return a.db.SaveMessage(peerID, senderID, content)
 }
 
 // GetMessageHistory retrieves message history for a peer
 func (a *App) GetMessageHistory(peerID string, limit int) ([]database.Message, error) {
+	if err := a.requireUnlocked(); err != nil {
+		return nil, err
+	}
 	return a.db.GetMessageHistory(peerID, limit)
 }
 
+// GetMessagesPage returns a page of a conversation's history before or
+// after a message ID or timestamp, with flags telling whether more exist
+func (a *App) GetMessagesPage(conversationID string, cursor database.HistoryCursor) (database.HistoryPage, error) {
+	if err := a.requireUnlocked(); err != nil {
+		return database.HistoryPage{}, err
+	}
+	return a.db.GetMessagesPage(conversationID, cursor)
+}
+
+// GetMessageContext returns a message with the surrounding history, for
+// jumping to a search result
+func (a *App) GetMessageContext(messageID int64, radius int) (database.HistoryPage, error) {
+	if err := a.requireUnlocked(); err != nil {
+		return database.HistoryPage{}, err
+	}
+	return a.db.GetMessageContext(messageID, radius)
+}
+
+// SearchMessages searches message history. Text supports plain terms,
+// "quoted phrases" and prefix* terms, optionally filtered by peer, sender
+// and date range; results carry highlighted snippets.
+func (a *App) SearchMessages(query database.SearchQuery) ([]database.SearchResult, error) {
+	if err := a.requireUnlocked(); err != nil {
+		return nil, err
+	}
+	return a.db.SearchMessages(query)
+}
+
+// RebuildSearchIndex re-indexes all message history for full-text search
+func (a *App) RebuildSearchIndex() error {
+	if err := a.requireUnlocked(); err != nil {
+		return err
+	}
+	return a.db.RebuildSearchIndex()
+}
+
+// IsDatabaseEncrypted reports whether message history is encrypted at rest
+func (a *App) IsDatabaseEncrypted() bool {
+	return a.db.IsEncrypted()
+}
+
+// IsDatabaseLocked reports whether the passphrase is still needed to read history
+func (a *App) IsDatabaseLocked() bool {
+	return a.db.IsLocked()
+}
+
+// UnlockDatabase unlocks an encrypted message store with its passphrase
+func (a *App) UnlockDatabase(passphrase string) error {
+	return a.db.Unlock(passphrase)
+}
+
+// EnableDatabaseEncryption encrypts existing and future messages with a passphrase
+func (a *App) EnableDatabaseEncryption(passphrase string) error {
+	if err := a.requireUnlocked(); err != nil {
+		return err
+	}
+	return a.db.EnableEncryption(passphrase)
+}
+
+// ChangeDatabasePassphrase re-keys the message store under a new passphrase
+func (a *App) ChangeDatabasePassphrase(oldPassphrase, newPassphrase string) error {
+	if err := a.requireUnlocked(); err != nil {
+		return err
+	}
+	return a.db.ChangePassphrase(oldPassphrase, newPassphrase)
+}
+
 // SavePeer saves a peer to the database
 func (a *App) SavePeer(peerID, name, ipAddress string) error {
 	return a.db.SavePeer(peerID, name, ipAddress)
 }
 
-// GetPeers retrieves all peers from the database
+// GetPeers returns every known peer, including offline ones, with status
+// and address taken from live discovery. Online peers come first.
 func (a *App) GetPeers() ([]database.Peer, error) {
-	return a.db.GetPeers()
+	if err := a.requireUnlocked(); err != nil {
+		return nil, err
+	}
+	peers, err := a.db.GetPeers()
+	if err != nil {
+		return nil, err
+	}
+
+	live := make(map[string]*network.PeerInfo)
+	if nm := a.currentNetwork(); nm != nil {
+		live = nm.GetActivePeers()
+	}
+
+	for i := range peers {
+		peer := &peers[i]
+		info, ok := live[peer.PeerID]
+		peer.IsOnline = ok && info.IsOnline
+		if !ok {
+			continue
+		}
+		peer.Name = info.Name
+		peer.IPAddress = info.IP
+		peer.LastSeen = info.LastSeen
+		delete(live, peer.PeerID)
+	}
+
+	// Peers discovered but not yet saved
+	for _, info := range live {
+		peers = append(peers, database.Peer{
+			ContactDetails: database.ContactDetails{Tags: []string{}},
+			PeerID:         info.PeerID,
+			Name:           info.Name,
+			IPAddress:      info.IP,
+			LastSeen:       info.LastSeen,
+			IsOnline:       info.IsOnline,
+		})
+	}
+
+	sort.SliceStable(peers, func(i, j int) bool {
+		return peers[i].IsOnline && !peers[j].IsOnline
+	})
+	return peers, nil
+}
+
+// GetPeerPresence returns when a peer recently came online or went
+// offline, newest first
+func (a *App) GetPeerPresence(peerID string, limit int) ([]database.PresenceChange, error) {
+	if err := a.requireUnlocked(); err != nil {
+		return nil, err
+	}
+	return a.db.GetPeerPresence(peerID, limit)
+}
+
+// GetStatistics returns an activity report over a period: messages per
+// day, week or month, the busiest conversations, response times and how
+// long peers were online
+func (a *App) GetStatistics(query database.StatsQuery) (database.Statistics, error) {
+	if err := a.requireUnlocked(); err != nil {
+		return database.Statistics{}, err
+	}
+	return a.db.GetStatistics(query, a.localPeerID)
+}
+
+// GetConversations returns direct chats, group chats and the broadcast
+// channel, most recently active first
+func (a *App) GetConversations() ([]database.Conversation, error) {
+	if err := a.requireUnlocked(); err != nil {
+		return nil, err
+	}
+	return a.db.GetConversations()
+}
+
+// GetConversation returns a single conversation with its members
+func (a *App) GetConversation(conversationID string) (database.Conversation, error) {
+	if err := a.requireUnlocked(); err != nil {
+		return database.Conversation{}, err
+	}
+	return a.db.GetConversation(conversationID)
+}
+
+// CreateGroup starts a group chat with the given peers
+func (a *App) CreateGroup(title string, members []string) (database.Conversation, error) {
+	if err := a.requireUnlocked(); err != nil {
+		return database.Conversation{}, err
+	}
+	return a.db.CreateGroupConversation(title, members)
+}
+
+// AddGroupMembers adds peers to a group chat and sends the new member
+// list to every member
+func (a *App) AddGroupMembers(conversationID string, members []string) error {
+	if err := a.requireUnlocked(); err != nil {
+		return err
+	}
+	conversation, err := a.db.GetConversation(conversationID)
+	if err != nil {
+		return err
+	}
+	if conversation, err = a.db.AddConversationMembers(conversationID, conversation.Title, members); err != nil {
+		return err
+	}
+	return a.sendGroupMembers(conversation, nil)
+}
+
+// RemoveGroupMember removes a peer from a group chat and sends the new
+// member list to the remaining members and the removed peer
+func (a *App) RemoveGroupMember(conversationID, peerID string) error {
+	if err := a.requireUnlocked(); err != nil {
+		return err
+	}
+	conversation, err := a.db.RemoveConversationMember(conversationID, peerID)
+	if err != nil {
+		return err
+	}
+	return a.sendGroupMembers(conversation, []string{peerID})
+}
+
+// sendGroupMembers sends a group's member list to its members and any
+// extra recipients. Peers offline now learn it from the next group
+// message, which carries the list too.
+func (a *App) sendGroupMembers(conversation database.Conversation, extra []string) error {
+	nm := a.currentNetwork()
+	if nm == nil {
+		return nil
+	}
+	recipients := append(append([]string{}, conversation.Members...), extra...)
+	return nm.SendGroupMembers(conversation.ID, conversation.Title, conversation.Members, conversation.MembersChangedAt, recipients)
+}
+
+// UpdateConversationSettings changes a conversation's title, mute and archive flags
+func (a *App) UpdateConversationSettings(conversationID string, settings database.ConversationSettings) error {
+	if err := a.requireUnlocked(); err != nil {
+		return err
+	}
+	if err := a.db.UpdateConversationSettings(conversationID, settings); err != nil {
+		return err
+	}
+
+	// Muting changes the badge total
+	a.emitUnreadCounts()
+	return nil
+}
+
+// GetConversationSummaries returns every conversation with its latest
+// message and unread count for the conversation list
+func (a *App) GetConversationSummaries() ([]database.ConversationSummary, error) {
+	if err := a.requireUnlocked(); err != nil {
+		return nil, err
+	}
+	return a.db.GetConversationSummaries()
+}
+
+// GetUnreadCounts returns unread counts per conversation and the badge total
+func (a *App) GetUnreadCounts() (database.UnreadCounts, error) {
+	if err := a.requireUnlocked(); err != nil {
+		return database.UnreadCounts{}, err
+	}
+	return a.db.GetUnreadCounts()
+}
+
+// MarkMessageAsRead marks a single message as read
+func (a *App) MarkMessageAsRead(messageID int64) error {
+	if err := a.requireUnlocked(); err != nil {
+		return err
+	}
+	if err := a.db.MarkMessageAsRead(messageID); err != nil {
+		return err
+	}
+	a.emitUnreadCounts()
+	return nil
+}
+
+// MarkConversationRead marks a conversation read up to and including a
+// message, or entirely when messageID is 0
+func (a *App) MarkConversationRead(conversationID string, messageID int64) error {
+	if err := a.requireUnlocked(); err != nil {
+		return err
+	}
+	if err := a.db.MarkConversationRead(conversationID, messageID); err != nil {
+		return err
+	}
+	a.emitUnreadCounts()
+	return nil
+}
+
+// emitUnreadCounts notifies the frontend that unread counts changed. It
+// carries no content, so it is sent even while the app is locked.
+func (a *App) emitUnreadCounts() {
+	counts, err := a.db.GetUnreadCounts()
+	if err != nil {
+		log.Printf("Error loading unread counts: %v", err)
+		return
+	}
+	runtime.EventsEmit(a.ctx, "unreadCountsChanged", counts)
+}
+
+// SendToConversation sends a message to a direct chat, group chat or the
+// broadcast channel
+func (a *App) SendToConversation(conversationID, content string) error {
+	if err := a.requireUnlocked(); err != nil {
+		return err
+	}
+	nm, err := a.requireNetwork()
+	if err != nil {
+		return err
+	}
+
+	// A direct chat may not have any history yet
+	if peerID, ok := strings.CutPrefix(conversationID, database.ConversationDirect+":"); ok {
+		return nm.SendMessageToPeer(peerID, content)
+	}
+
+	conversation, err := a.db.GetConversation(conversationID)
+	if err != nil {
+		return err
+	}
+
+	switch conversation.Kind {
+	case database.ConversationBroadcast:
+		return nm.BroadcastMessage(content)
+	case database.ConversationGroup:
+		return nm.SendGroupMessage(conversation.ID, conversation.Title, conversation.Members, conversation.MembersChangedAt, content)
+	default:
+		return fmt.Errorf("cannot send to conversation %s", conversationID)
+	}
+}
+
+// EditMessage replaces the content of one of our messages for everyone
+// in its conversation, keeping the previous version in its history
+func (a *App) EditMessage(messageID int64, content string) error {
+	nm, msg, recipients, err := a.ownMessageRecipients(messageID)
+	if err != nil {
+		return err
+	}
+	return nm.SendEdit(wireConversationID(msg.ConversationID), recipients, msg.MessageID, content)
+}
+
+// DeleteMessage deletes one of our messages for everyone in its conversation
+func (a *App) DeleteMessage(messageID int64) error {
+	nm, msg, recipients, err := a.ownMessageRecipients(messageID)
+	if err != nil {
+		return err
+	}
+	return nm.SendDelete(wireConversationID(msg.ConversationID), recipients, msg.MessageID)
+}
+
+// AddReaction reacts to a message with an emoji for everyone in its
+// conversation, returning the message's reactions
+func (a *App) AddReaction(messageID int64, emoji string) ([]database.ReactionCount, error) {
+	return a.sendReaction(messageID, emoji, false)
+}
+
+// RemoveReaction withdraws our emoji reaction to a message, returning the
+// message's reactions
+func (a *App) RemoveReaction(messageID int64, emoji string) ([]database.ReactionCount, error) {
+	return a.sendReaction(messageID, emoji, true)
+}
+
+// sendReaction applies and sends a reaction change
+func (a *App) sendReaction(messageID int64, emoji string, removed bool) ([]database.ReactionCount, error) {
+	nm, msg, recipients, err := a.messageRecipients(messageID)
+	if err != nil {
+		return nil, err
+	}
+	if msg.DeletedAt != nil {
+		return nil, database.ErrMessageDeleted
+	}
+
+	err = nm.SendReaction(wireConversationID(msg.ConversationID), recipients, msg.MessageID, emoji, removed)
+	if err != nil {
+		return nil, err
+	}
+	return a.db.GetReactions(msg.MessageID)
+}
+
+// PinMessage pins a message for everyone in its conversation, returning
+// the conversation's pinned messages
+func (a *App) PinMessage(messageID int64) ([]database.PinnedMessage, error) {
+	return a.sendPin(messageID, false)
+}
+
+// UnpinMessage unpins a message for everyone in its conversation,
+// returning the conversation's pinned messages
+func (a *App) UnpinMessage(messageID int64) ([]database.PinnedMessage, error) {
+	return a.sendPin(messageID, true)
+}
+
+// sendPin applies and sends a pin change
+func (a *App) sendPin(messageID int64, unpinned bool) ([]database.PinnedMessage, error) {
+	nm, msg, recipients, err := a.messageRecipients(messageID)
+	if err != nil {
+		return nil, err
+	}
+	if msg.DeletedAt != nil {
+		return nil, database.ErrMessageDeleted
+	}
+
+	err = nm.SendPin(wireConversationID(msg.ConversationID), recipients, msg.MessageID, unpinned)
+	if err != nil {
+		return nil, err
+	}
+	return a.db.GetPinnedMessages(msg.ConversationID)
+}
+
+// GetPinnedMessages returns the messages pinned in a conversation
+func (a *App) GetPinnedMessages(conversationID string) ([]database.PinnedMessage, error) {
+	if err := a.requireUnlocked(); err != nil {
+		return nil, err
+	}
+	return a.db.GetPinnedMessages(conversationID)
+}
+
+// StarMessage privately stars a message
+func (a *App) StarMessage(messageID int64) error {
+	if err := a.requireUnlocked(); err != nil {
+		return err
+	}
+	return a.db.StarMessage(messageID, true)
+}
+
+// UnstarMessage removes our star from a message
+func (a *App) UnstarMessage(messageID int64) error {
+	if err := a.requireUnlocked(); err != nil {
+		return err
+	}
+	return a.db.StarMessage(messageID, false)
+}
+
+// GetStarredMessages returns our starred messages from every conversation
+func (a *App) GetStarredMessages() ([]database.StarredMessage, error) {
+	if err := a.requireUnlocked(); err != nil {
+		return nil, err
+	}
+	return a.db.GetStarredMessages()
+}
+
+// ReplyToMessage sends a reply to a message into the message's conversation
+func (a *App) ReplyToMessage(messageID int64, content string) error {
+	nm, msg, recipients, err := a.messageRecipients(messageID)
+	if err != nil {
+		return err
+	}
+	if msg.DeletedAt != nil {
+		return database.ErrMessageDeleted
+	}
+
+	// Group replies carry the title and member list like any group message
+	var title string
+	var membersAt time.Time
+	if strings.HasPrefix(msg.ConversationID, database.ConversationGroup+":") {
+		conversation, err := a.db.GetConversation(msg.ConversationID)
+		if err != nil {
+			return err
+		}
+		title, membersAt = conversation.Title, conversation.MembersChangedAt
+	}
+	return nm.SendReply(wireConversationID(msg.ConversationID), title, recipients, membersAt, msg.MessageID, content)
+}
+
+// GetThread returns the thread a message belongs to: the message that was
+// replied to and every reply below it
+func (a *App) GetThread(messageID int64) (database.Thread, error) {
+	if err := a.requireUnlocked(); err != nil {
+		return database.Thread{}, err
+	}
+	return a.db.GetThread(messageID)
+}
+
+// GetMessageRevisions returns the previous versions of an edited message
+func (a *App) GetMessageRevisions(messageID int64) ([]database.MessageRevision, error) {
+	if err := a.requireUnlocked(); err != nil {
+		return nil, err
+	}
+	return a.db.GetMessageRevisions(messageID)
+}
+
+// SetEditWindow sets how many minutes after sending peers may edit their
+// messages; zero accepts edits at any time
+func (a *App) SetEditWindow(minutes int) error {
+	if err := a.requireUnlocked(); err != nil {
+		return err
+	}
+	return a.db.SetEditWindow(time.Duration(minutes) * time.Minute)
+}
+
+// GetEditWindow returns the edit window in minutes
+func (a *App) GetEditWindow() (int, error) {
+	if err := a.requireUnlocked(); err != nil {
+		return 0, err
+	}
+	window, err := a.db.EditWindow()
+	if err != nil {
+		return 0, err
+	}
+	return int(window / time.Minute), nil
+}
+
+// ownMessageRecipients loads one of our messages and the peers that
+// received it, with the network manager to reach them through
+func (a *App) ownMessageRecipients(messageID int64) (*network.NetworkManager, database.Message, []string, error) {
+	nm, msg, recipients, err := a.messageRecipients(messageID)
+	if err != nil {
+		return nil, database.Message{}, nil, err
+	}
+	if msg.SenderID != a.localPeerID {
+		return nil, database.Message{}, nil, database.ErrNotMessageAuthor
+	}
+	return nm, msg, recipients, nil
+}
+
+// messageRecipients loads a message and the peers in its conversation,
+// with the network manager to reach them through
+func (a *App) messageRecipients(messageID int64) (*network.NetworkManager, database.Message, []string, error) {
+	if err := a.requireUnlocked(); err != nil {
+		return nil, database.Message{}, nil, err
+	}
+	nm, err := a.requireNetwork()
+	if err != nil {
+		return nil, database.Message{}, nil, err
+	}
+
+	msg, err := a.db.GetMessage(messageID)
+	if err != nil {
+		return nil, database.Message{}, nil, err
+	}
+
+	// Broadcasts went to whoever was online; reach whoever is online now
+	if msg.ConversationID == database.BroadcastConversationID {
+		var recipients []string
+		for peerID := range nm.GetActivePeers() {
+			recipients = append(recipients, peerID)
+		}
+		return nm, msg, recipients, nil
+	}
+
+	conversation, err := a.db.GetConversation(msg.ConversationID)
+	if err != nil {
+		return nil, database.Message{}, nil, err
+	}
+	return nm, msg, conversation.Members, nil
+}
+
+// wireConversationID converts a stored conversation ID to the one sent on
+// the network, where direct chats have none
+func wireConversationID(conversationID string) string {
+	if strings.HasPrefix(conversationID, database.ConversationDirect+":") {
+		return ""
+	}
+	return conversationID
 }
 
 // ShowNotification displays a system notification
@@ -111,27 +747,36 @@ func (a *App) ShowNotification(title, message string) {
 
 // SendMessage sends a message to a specific peer
 func (a *App) SendMessage(peerID, content string) error {
-	if a.networkManager == nil {
-		return fmt.Errorf("network manager not initialized")
+	if err := a.requireUnlocked(); err != nil {
+		return err
+	}
+	nm, err := a.requireNetwork()
+	if err != nil {
+		return err
 	}
-	return a.networkManager.SendMessageToPeer(peerID, content)
+	return nm.SendMessageToPeer(peerID, content)
 }
 
 // BroadcastMessage sends a message to all peers
 func (a *App) BroadcastMessage(content string) error {
-	if a.networkManager == nil {
-		return fmt.Errorf("network manager not initialized")
+	if err := a.requireUnlocked(); err != nil {
+		return err
+	}
+	nm, err := a.requireNetwork()
+	if err != nil {
+		return err
 	}
-	return a.networkManager.BroadcastMessage(content)
+	return nm.BroadcastMessage(content)
 }
 
 // GetActivePeers returns currently active peers
 func (a *App) GetActivePeers() map[string]interface{} {
-	if a.networkManager == nil {
+	nm := a.currentNetwork()
+	if nm == nil {
 		return make(map[string]interface{})
 	}
 
-	peers := a.networkManager.GetActivePeers()
+	peers := nm.GetActivePeers()
 	result := make(map[string]interface{})
 
 	for k, v := range peers {
@@ -148,13 +793,207 @@ func (a *App) GetActivePeers() map[string]interface{} {
 	return result
 }
 
-// SetLocalName sets the local peer name
-func (a *App) SetLocalName(name string) {
-	a.localName = name
-	if a.networkManager != nil {
-		// Note: In a real implementation, you might want to restart
-		// the network manager or send an update message
-		log.Printf("Local name updated to: %s", name)
+// JoinWorkspace restricts discovery and messaging to members sharing
+// the given workspace name and passphrase. The workspace is kept in the
+// profile and rejoined at startup.
+func (a *App) JoinWorkspace(name, passphrase string) error {
+	if err := a.requireUnlocked(); err != nil {
+		return err
+	}
+	ws, err := network.NewWorkspace(name, passphrase)
+	if err != nil {
+		return err
+	}
+	return a.switchWorkspace(ws)
+}
+
+// LeaveWorkspace returns to the open discovery group shared by everyone on the LAN
+func (a *App) LeaveWorkspace() error {
+	if err := a.requireUnlocked(); err != nil {
+		return err
+	}
+	return a.switchWorkspace(nil)
+}
+
+// switchWorkspace saves the workspace to use, nil for none, and restarts
+// networking in it
+func (a *App) switchWorkspace(ws *network.Workspace) error {
+	a.netMu.Lock()
+	defer a.netMu.Unlock()
+
+	if ws == nil && a.workspace == nil {
+		return nil
+	}
+	if a.profile != nil {
+		if err := a.profile.saveWorkspace(ws); err != nil {
+			return err
+		}
+	}
+
+	a.workspace = ws
+	if a.ctx == nil {
+		return nil
+	}
+	a.stopNetwork()
+	return a.startNetwork()
+}
+
+// GetWorkspace returns the name of the joined workspace, or an empty string
+func (a *App) GetWorkspace() string {
+	a.netMu.Lock()
+	defer a.netMu.Unlock()
+
+	if a.workspace == nil {
+		return ""
+	}
+	return a.workspace.Name
+}
+
+// BlockPeer stops discovering and accepting messages from a peer
+func (a *App) BlockPeer(peerID string) error {
+	if err := a.requireUnlocked(); err != nil {
+		return err
+	}
+	if _, err := a.db.AddAccessRule(database.AccessBlock, peerID, ""); err != nil {
+		return err
+	}
+	return a.reloadAccessPolicy()
+}
+
+// UnblockPeer removes every block rule for a peer
+func (a *App) UnblockPeer(peerID string) error {
+	if err := a.requireUnlocked(); err != nil {
+		return err
+	}
+	if err := a.db.RemovePeerAccessRules(database.AccessBlock, peerID); err != nil {
+		return err
+	}
+	return a.reloadAccessPolicy()
+}
+
+// AllowPeer adds a peer to the allow list
+func (a *App) AllowPeer(peerID string) error {
+	if err := a.requireUnlocked(); err != nil {
+		return err
+	}
+	if _, err := a.db.AddAccessRule(database.AccessAllow, peerID, ""); err != nil {
+		return err
+	}
+	return a.reloadAccessPolicy()
+}
+
+// AddAddressRule blocks or allows an IP address or subnet such as 10.0.5.0/24
+func (a *App) AddAddressRule(kind, cidr string) error {
+	if err := a.requireUnlocked(); err != nil {
+		return err
+	}
+	if _, err := a.db.AddAccessRule(kind, "", cidr); err != nil {
+		return err
+	}
+	return a.reloadAccessPolicy()
+}
+
+// RemoveAccessRule deletes a block or allow rule by ID
+func (a *App) RemoveAccessRule(ruleID int64) error {
+	if err := a.requireUnlocked(); err != nil {
+		return err
+	}
+	if err := a.db.RemoveAccessRule(ruleID); err != nil {
+		return err
+	}
+	return a.reloadAccessPolicy()
+}
+
+// GetAccessRules returns all block and allow rules
+func (a *App) GetAccessRules() ([]database.AccessRule, error) {
+	if err := a.requireUnlocked(); err != nil {
+		return nil, err
+	}
+	return a.db.GetAccessRules()
+}
+
+// SetAllowListOnly restricts discovery and messaging to allow-listed peers
+func (a *App) SetAllowListOnly(enabled bool) error {
+	if err := a.requireUnlocked(); err != nil {
+		return err
+	}
+	if err := a.db.SetAllowListOnly(enabled); err != nil {
+		return err
+	}
+	return a.reloadAccessPolicy()
+}
+
+// IsAllowListOnly reports whether only allow-listed peers are accepted
+func (a *App) IsAllowListOnly() (bool, error) {
+	if err := a.requireUnlocked(); err != nil {
+		return false, err
+	}
+	return a.db.IsAllowListOnly()
+}
+
+// reloadAccessPolicy rebuilds the network access policy from the database
+func (a *App) reloadAccessPolicy() error {
+	rules, err := a.db.GetAccessRules()
+	if err != nil {
+		return err
+	}
+	allowListOnly, err := a.db.IsAllowListOnly()
+	if err != nil {
+		return err
+	}
+
+	policyRules := make([]network.AccessRule, 0, len(rules))
+	for _, rule := range rules {
+		policyRules = append(policyRules, network.AccessRule{
+			Allow:  rule.Kind == database.AccessAllow,
+			PeerID: rule.PeerID,
+			CIDR:   rule.CIDR,
+		})
+	}
+	a.access.Update(policyRules, allowListOnly)
+
+	if nm := a.currentNetwork(); nm != nil {
+		nm.EnforceAccessPolicy()
+	}
+	return nil
+}
+
+// SetLocalName changes the display name announced to peers. It is the
+// display_name setting, saved and applied like the others.
+func (a *App) SetLocalName(name string) error {
+	if err := a.requireUnlocked(); err != nil {
+		return err
+	}
+	settings, err := a.db.GetSettings()
+	if err != nil {
+		return err
+	}
+	settings.DisplayName = name
+	return a.UpdateSettings(settings)
+}
+
+// currentName returns the name announced to peers
+func (a *App) currentName() string {
+	a.nameMu.RLock()
+	defer a.nameMu.RUnlock()
+	return a.localName
+}
+
+// applyDisplayName announces the display name setting, falling back to
+// the identity's name when it is empty
+func (a *App) applyDisplayName(displayName string) {
+	if displayName == "" {
+		displayName = a.identityName
+	}
+
+	a.nameMu.Lock()
+	changed := displayName != a.localName
+	a.localName = displayName
+	a.nameMu.Unlock()
+
+	if nm := a.currentNetwork(); changed && nm != nil {
+		nm.SetLocalName(displayName)
+		log.Printf("Local name updated to: %s", displayName)
 	}
 }
 
@@ -162,7 +1001,8 @@ func (a *App) SetLocalName(name string) {
 func (a *App) GetLocalPeerInfo() map[string]string {
 	return map[string]string{
 		"peer_id": a.localPeerID,
-		"name":    a.localName,
+		"name":    a.currentName(),
+		"profile": a.profile.name,
 	}
 }
 
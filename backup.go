@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"lanvochat/database"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/wailsapp/wails/v2/pkg/runtime"
+)
+
+const (
+	// backupCheckInterval is how often the backup schedule is evaluated
+	backupCheckInterval = 10 * time.Minute
+	// backupPattern matches files written by scheduled backups
+	backupPattern = "lanvochat-*.lvbackup"
+)
+
+// startBackupScheduler writes scheduled backups until ctx is done
+func (a *App) startBackupScheduler(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(backupCheckInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := a.backupIfDue(); err != nil {
+					log.Printf("Error writing scheduled backup: %v", err)
+				}
+			}
+		}
+	}()
+}
+
+// backupIfDue writes a scheduled backup when the interval has passed
+func (a *App) backupIfDue() error {
+	schedule, err := a.db.GetBackupSchedule()
+	if err != nil || schedule.IntervalHours == 0 {
+		return err
+	}
+	if a.db.IsLocked() {
+		return nil // the backup key opens once the app is unlocked
+	}
+
+	last, err := a.db.LastBackupAt()
+	if err != nil {
+		return err
+	}
+	if time.Since(last) < time.Duration(schedule.IntervalHours)*time.Hour {
+		return nil
+	}
+
+	_, err = a.writeScheduledBackup(schedule)
+	return err
+}
+
+// writeScheduledBackup writes a backup into the schedule's directory and
+// removes the oldest beyond the number to keep
+func (a *App) writeScheduledBackup(schedule database.BackupSchedule) (string, error) {
+	if err := os.MkdirAll(schedule.Dir, 0o700); err != nil {
+		return "", fmt.Errorf("failed to create backup directory: %w", err)
+	}
+
+	opts, err := a.db.ScheduledBackupOptions(schedule)
+	if err != nil {
+		return "", err
+	}
+
+	path := filepath.Join(schedule.Dir, fmt.Sprintf("lanvochat-%s.lvbackup", time.Now().Format("20060102-150405")))
+	if err := a.db.BackupToFile(path, opts); err != nil {
+		return "", err
+	}
+	if err := a.db.SetLastBackupAt(time.Now()); err != nil {
+		return "", err
+	}
+	log.Printf("Wrote scheduled backup %s", path)
+
+	return path, rotateBackups(schedule.Dir, schedule.Keep)
+}
+
+// rotateBackups deletes all but the newest keep scheduled backups in dir
+func rotateBackups(dir string, keep int) error {
+	paths, err := filepath.Glob(filepath.Join(dir, backupPattern))
+	if err != nil {
+		return err
+	}
+	if len(paths) <= keep {
+		return nil
+	}
+
+	// Names embed the timestamp, so lexical order is chronological
+	sort.Strings(paths)
+	for _, path := range paths[:len(paths)-keep] {
+		if err := os.Remove(path); err != nil {
+			return fmt.Errorf("failed to remove old backup: %w", err)
+		}
+	}
+	return nil
+}
+
+// BackupDatabase writes a consistent snapshot of the database to a file the
+// user picks, optionally compressed and encrypted with a passphrase.
+// Returns the saved path, or "" if the user cancelled.
+func (a *App) BackupDatabase(opts database.BackupOptions) (string, error) {
+	if err := a.requireUnlocked(); err != nil {
+		return "", err
+	}
+
+	path, err := runtime.SaveFileDialog(a.ctx, runtime.SaveDialogOptions{
+		Title:           "Back up database",
+		DefaultFilename: fmt.Sprintf("lanvochat-%s.lvbackup", time.Now().Format("20060102-150405")),
+		Filters:         []runtime.FileFilter{{DisplayName: "LanvoChat backup (*.lvbackup)", Pattern: "*.lvbackup"}},
+	})
+	if err != nil || path == "" {
+		return "", err
+	}
+
+	if err := a.db.BackupToFile(path, opts); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// BackupNow writes a scheduled backup immediately, returning its path
+func (a *App) BackupNow() (string, error) {
+	if err := a.requireUnlocked(); err != nil {
+		return "", err
+	}
+	schedule, err := a.db.GetBackupSchedule()
+	if err != nil {
+		return "", err
+	}
+	if schedule.Dir == "" {
+		return "", fmt.Errorf("no backup directory configured")
+	}
+	return a.writeScheduledBackup(schedule)
+}
+
+// GetBackupSchedule returns the automatic backup configuration
+func (a *App) GetBackupSchedule() (database.BackupSchedule, error) {
+	if err := a.requireUnlocked(); err != nil {
+		return database.BackupSchedule{}, err
+	}
+	return a.db.GetBackupSchedule()
+}
+
+// SetBackupSchedule configures automatic backups. Setting a passphrase
+// encrypts them; an encrypted profile's backups must be encrypted.
+func (a *App) SetBackupSchedule(schedule database.BackupSchedule) error {
+	if err := a.requireUnlocked(); err != nil {
+		return err
+	}
+	return a.db.SetBackupSchedule(schedule)
+}
+
+// RestoreDatabase replaces the database with a backup the user picks.
+// The backup is validated before anything is replaced, and networking
+// is paused while the database is swapped.
+func (a *App) RestoreDatabase(passphrase string) error {
+	if err := a.requireUnlocked(); err != nil {
+		return err
+	}
+
+	path, err := runtime.OpenFileDialog(a.ctx, runtime.OpenDialogOptions{
+		Title:   "Restore database",
+		Filters: []runtime.FileFilter{{DisplayName: "LanvoChat backup (*.lvbackup)", Pattern: "*.lvbackup"}},
+	})
+	if err != nil || path == "" {
+		return err
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open backup: %w", err)
+	}
+	defer file.Close()
+
+	// Nothing may start networking on the database while it is swapped
+	a.netMu.Lock()
+	defer a.netMu.Unlock()
+	a.stopNetwork()
+
+	restoreErr := a.db.Restore(file, passphrase)
+	if restoreErr == nil {
+		// The snapshot may still show peers as online
+		if err := a.db.MarkAllPeersOffline(); err != nil {
+			log.Printf("Warning: Failed to reset peer status: %v", err)
+		}
+		if err := a.db.MarkOwnMessagesRead(a.localPeerID); err != nil {
+			log.Printf("Warning: Failed to correct unread counts: %v", err)
+		}
+	}
+
+	// Whatever happened, come back online with the rules of the database now in place
+	if err := a.reloadAccessPolicy(); err != nil {
+		log.Printf("Warning: Failed to load access rules: %v", err)
+	}
+	if err := a.startNetwork(); err != nil {
+		log.Printf("Warning: Failed to restart network manager: %v", err)
+	}
+	if restoreErr != nil {
+		return restoreErr
+	}
+
+	runtime.EventsEmit(a.ctx, "databaseRestored", a.db.IsLocked())
+	a.emitUnreadCounts()
+	return nil
+}
@@ -5,7 +5,7 @@ export CGO_ENABLED=1
 # Auto-detect WebKit version
 if pkg-config --exists webkit2gtk-4.1; then
     WEBKIT_VERSION="4.1"
-    BUILD_TAGS="-tags webkit2gtk_4_1"
+    BUILD_TAGS="-tags webkit2gtk_4_1,sqlite_fts5"
     echo "Detected: WebKit2GTK 4.1"
     
     # Create wrapper for systems with 4.1 only
@@ -24,7 +24,7 @@ WRAPPER
     export PATH="$PWD/.build:$PATH"
 elif pkg-config --exists webkit2gtk-4.0; then
     WEBKIT_VERSION="4.0"
-    BUILD_TAGS=""
+    BUILD_TAGS="-tags sqlite_fts5"
     echo "Detected: WebKit2GTK 4.0"
 else
     echo "ERROR: WebKit2GTK not found!"
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"lanvochat/database"
+	"sort"
+	"strings"
+
+	"github.com/wailsapp/wails/v2/pkg/runtime"
+)
+
+// GetContacts returns the contact list: known peers with live status that
+// pass the filter, favourites first, then online peers, then by name
+func (a *App) GetContacts(filter database.ContactFilter) ([]database.Peer, error) {
+	if err := a.requireUnlocked(); err != nil {
+		return nil, err
+	}
+	peers, err := a.GetPeers()
+	if err != nil {
+		return nil, err
+	}
+
+	contacts := []database.Peer{}
+	for _, peer := range peers {
+		if filter.Matches(peer) {
+			contacts = append(contacts, peer)
+		}
+	}
+
+	sort.SliceStable(contacts, func(i, j int) bool {
+		x, y := contacts[i], contacts[j]
+		if x.Favorite != y.Favorite {
+			return x.Favorite
+		}
+		if x.IsOnline != y.IsOnline {
+			return x.IsOnline
+		}
+		return strings.ToLower(x.DisplayName()) < strings.ToLower(y.DisplayName())
+	})
+	return contacts, nil
+}
+
+// UpdateContact saves the nickname, notes, tags, favourite and hidden
+// flags kept locally about a peer
+func (a *App) UpdateContact(peerID string, details database.ContactDetails) error {
+	if err := a.requireUnlocked(); err != nil {
+		return err
+	}
+	if err := a.db.UpdateContact(peerID, details); err != nil {
+		return err
+	}
+
+	runtime.EventsEmit(a.ctx, "contactUpdated", peerID)
+	return nil
+}
+
+// GetContactTags returns every tag in use for grouping contacts
+func (a *App) GetContactTags() ([]string, error) {
+	if err := a.requireUnlocked(); err != nil {
+		return nil, err
+	}
+	return a.db.GetContactTags()
+}
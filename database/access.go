@@ -0,0 +1,158 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+const (
+	// AccessBlock rules reject a peer or address range outright
+	AccessBlock = "block"
+	// AccessAllow rules admit a peer or address range in allow-list-only mode
+	AccessAllow = "allow"
+)
+
+// AccessRule blocks or allows a peer identity or an IP/subnet
+type AccessRule struct {
+	ID        int64     `json:"id"`
+	Kind      string    `json:"kind"`
+	PeerID    string    `json:"peer_id,omitempty"`
+	CIDR      string    `json:"cidr,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// AddAccessRule stores a block or allow rule. Exactly one of peerID or
+// cidr must be set; a bare IP address is stored as a single-host subnet.
+func (d *Database) AddAccessRule(kind, peerID, cidr string) (AccessRule, error) {
+	if kind != AccessBlock && kind != AccessAllow {
+		return AccessRule{}, fmt.Errorf("unknown access rule kind %q", kind)
+	}
+
+	peerID = strings.TrimSpace(peerID)
+	cidr = strings.TrimSpace(cidr)
+	if (peerID == "") == (cidr == "") {
+		return AccessRule{}, fmt.Errorf("access rule needs either a peer ID or an IP/subnet")
+	}
+
+	if cidr != "" {
+		normalized, err := normalizeCIDR(cidr)
+		if err != nil {
+			return AccessRule{}, err
+		}
+		cidr = normalized
+	}
+
+	query := `
+		INSERT INTO access_rules (kind, peer_id, cidr, created_at)
+		VALUES (?, ?, ?, ?)
+		ON CONFLICT DO NOTHING
+	`
+
+	now := time.Now()
+	if _, err := d.exec(query, kind, peerID, cidr, now); err != nil {
+		return AccessRule{}, fmt.Errorf("failed to save access rule: %w", err)
+	}
+
+	rule := AccessRule{Kind: kind, PeerID: peerID, CIDR: cidr}
+	err := d.conn().db.QueryRow(`
+		SELECT id, created_at FROM access_rules
+		WHERE kind = ? AND peer_id = ? AND cidr = ?
+	`, kind, peerID, cidr).Scan(&rule.ID, &rule.CreatedAt)
+	if err != nil {
+		return AccessRule{}, fmt.Errorf("failed to load access rule: %w", err)
+	}
+
+	return rule, nil
+}
+
+// RemoveAccessRule deletes a rule by ID
+func (d *Database) RemoveAccessRule(ruleID int64) error {
+	_, err := d.exec(`DELETE FROM access_rules WHERE id = ?`, ruleID)
+	if err != nil {
+		return fmt.Errorf("failed to remove access rule: %w", err)
+	}
+
+	return nil
+}
+
+// RemovePeerAccessRules deletes every rule of a kind targeting a peer
+func (d *Database) RemovePeerAccessRules(kind, peerID string) error {
+	_, err := d.exec(`DELETE FROM access_rules WHERE kind = ? AND peer_id = ?`, kind, peerID)
+	if err != nil {
+		return fmt.Errorf("failed to remove access rules: %w", err)
+	}
+
+	return nil
+}
+
+// GetAccessRules retrieves all block and allow rules
+func (d *Database) GetAccessRules() ([]AccessRule, error) {
+	query := `
+		SELECT id, kind, peer_id, cidr, created_at
+		FROM access_rules
+		ORDER BY kind, created_at
+	`
+
+	rows, err := d.conn().db.Query(query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query access rules: %w", err)
+	}
+	defer rows.Close()
+
+	var rules []AccessRule
+	for rows.Next() {
+		var rule AccessRule
+		if err := rows.Scan(&rule.ID, &rule.Kind, &rule.PeerID, &rule.CIDR, &rule.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan access rule: %w", err)
+		}
+		rules = append(rules, rule)
+	}
+
+	return rules, rows.Err()
+}
+
+// SetAllowListOnly toggles whether only allow-listed peers are accepted
+func (d *Database) SetAllowListOnly(enabled bool) error {
+	value := "0"
+	if enabled {
+		value = "1"
+	}
+	return setMeta(execFunc(d.exec), "access.allowlist_only", value)
+}
+
+// IsAllowListOnly reports whether only allow-listed peers are accepted
+func (d *Database) IsAllowListOnly() (bool, error) {
+	value, err := getMeta(d.conn().db, "access.allowlist_only")
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to load allow list mode: %w", err)
+	}
+
+	return value == "1", nil
+}
+
+// normalizeCIDR validates an IP or subnet and returns it in CIDR form
+func normalizeCIDR(value string) (string, error) {
+	if !strings.Contains(value, "/") {
+		ip := net.ParseIP(value)
+		if ip == nil {
+			return "", fmt.Errorf("invalid IP address %q", value)
+		}
+		if ip.To4() != nil {
+			return ip.String() + "/32", nil
+		}
+		return ip.String() + "/128", nil
+	}
+
+	_, ipNet, err := net.ParseCIDR(value)
+	if err != nil {
+		return "", fmt.Errorf("invalid subnet %q: %w", value, err)
+	}
+	return ipNet.String(), nil
+}
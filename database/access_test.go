@@ -0,0 +1,111 @@
+package database
+
+import "testing"
+
+func TestAddAccessRule(t *testing.T) {
+	tests := []struct {
+		name     string
+		kind     string
+		peerID   string
+		cidr     string
+		wantCIDR string
+		wantErr  bool
+	}{
+		{"peer", AccessBlock, " peer ", "", "", false},
+		{"IPv4 address", AccessBlock, "", "192.0.2.7", "192.0.2.7/32", false},
+		{"IPv6 address", AccessAllow, "", "2001:db8::1", "2001:db8::1/128", false},
+		{"subnet with host bits", AccessAllow, "", "198.51.100.7/24", "198.51.100.0/24", false},
+		{"unknown kind", "mute", "peer", "", "", true},
+		{"neither peer nor subnet", AccessBlock, "", " ", "", true},
+		{"both peer and subnet", AccessBlock, "peer", "192.0.2.7", "", true},
+		{"invalid address", AccessBlock, "", "192.0.2.300", "", true},
+		{"invalid subnet", AccessBlock, "", "192.0.2.0/33", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := newTestDatabase(t)
+			rule, err := d.AddAccessRule(tt.kind, tt.peerID, tt.cidr)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("added rule %+v", rule)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("failed to add rule: %v", err)
+			}
+			if rule.ID == 0 || rule.CIDR != tt.wantCIDR || (tt.peerID != "" && rule.PeerID != "peer") {
+				t.Fatalf("got %+v, want subnet %q", rule, tt.wantCIDR)
+			}
+
+			// Adding the same rule again returns the stored one
+			again, err := d.AddAccessRule(tt.kind, tt.peerID, tt.cidr)
+			if err != nil {
+				t.Fatalf("failed to add rule again: %v", err)
+			}
+			if again.ID != rule.ID {
+				t.Fatalf("got rule %d, want the existing %d", again.ID, rule.ID)
+			}
+		})
+	}
+}
+
+func TestRemoveAccessRules(t *testing.T) {
+	d := newTestDatabase(t)
+
+	for _, rule := range []struct{ kind, peerID, cidr string }{
+		{AccessBlock, "peer", ""},
+		{AccessAllow, "peer", ""},
+		{AccessBlock, "", "192.0.2.0/24"},
+	} {
+		if _, err := d.AddAccessRule(rule.kind, rule.peerID, rule.cidr); err != nil {
+			t.Fatalf("failed to add rule: %v", err)
+		}
+	}
+
+	if err := d.RemovePeerAccessRules(AccessBlock, "peer"); err != nil {
+		t.Fatalf("failed to remove peer rules: %v", err)
+	}
+	rules, err := d.GetAccessRules()
+	if err != nil {
+		t.Fatalf("failed to load rules: %v", err)
+	}
+	if len(rules) != 2 || rules[0].Kind != AccessAllow || rules[1].CIDR != "192.0.2.0/24" {
+		t.Fatalf("got %+v, want the allow rule and the subnet block", rules)
+	}
+
+	if err := d.RemoveAccessRule(rules[1].ID); err != nil {
+		t.Fatalf("failed to remove rule: %v", err)
+	}
+	rules, err = d.GetAccessRules()
+	if err != nil {
+		t.Fatalf("failed to load rules: %v", err)
+	}
+	if len(rules) != 1 || rules[0].Kind != AccessAllow {
+		t.Fatalf("got %+v, want only the allow rule", rules)
+	}
+}
+
+func TestAllowListOnly(t *testing.T) {
+	d := newTestDatabase(t)
+
+	assertMode := func(want bool) {
+		t.Helper()
+		got, err := d.IsAllowListOnly()
+		if err != nil {
+			t.Fatalf("failed to load allow list mode: %v", err)
+		}
+		if got != want {
+			t.Fatalf("got allow list only %v, want %v", got, want)
+		}
+	}
+
+	assertMode(false)
+	for _, enabled := range []bool{true, false} {
+		if err := d.SetAllowListOnly(enabled); err != nil {
+			t.Fatalf("failed to set allow list mode: %v", err)
+		}
+		assertMode(enabled)
+	}
+}
@@ -0,0 +1,129 @@
+package database
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// defaultLockIdleTimeout applies until the user picks their own timeout
+const defaultLockIdleTimeout = 5 * time.Minute
+
+// HasLockPassphrase reports whether an application lock PIN/passphrase is set
+func (d *Database) HasLockPassphrase() (bool, error) {
+	_, err := getMeta(d.conn().db, "applock.hash")
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to load app lock: %w", err)
+	}
+
+	return true, nil
+}
+
+// SetLockPassphrase stores a salted hash of the application lock PIN/passphrase
+func (d *Database) SetLockPassphrase(passphrase string) error {
+	if len(passphrase) < 4 {
+		return fmt.Errorf("PIN must be at least 4 characters")
+	}
+
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return fmt.Errorf("failed to generate salt: %w", err)
+	}
+	hash, err := deriveKey(passphrase, salt, kdfIterations)
+	if err != nil {
+		return err
+	}
+
+	params := map[string]string{
+		"applock.salt":       hex.EncodeToString(salt),
+		"applock.iterations": strconv.Itoa(kdfIterations),
+		"applock.hash":       hex.EncodeToString(hash),
+	}
+	return d.withTx(func(tx *sql.Tx) error {
+		for key, value := range params {
+			if err := setMeta(tx, key, value); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// VerifyLockPassphrase checks a PIN/passphrase against the stored hash
+func (d *Database) VerifyLockPassphrase(passphrase string) (bool, error) {
+	saltHex, err := getMeta(d.conn().db, "applock.salt")
+	if err != nil {
+		return false, fmt.Errorf("failed to load app lock salt: %w", err)
+	}
+	iterValue, err := getMeta(d.conn().db, "applock.iterations")
+	if err != nil {
+		return false, fmt.Errorf("failed to load app lock iterations: %w", err)
+	}
+	hashHex, err := getMeta(d.conn().db, "applock.hash")
+	if err != nil {
+		return false, fmt.Errorf("failed to load app lock hash: %w", err)
+	}
+
+	salt, err := hex.DecodeString(saltHex)
+	if err != nil {
+		return false, fmt.Errorf("invalid app lock salt: %w", err)
+	}
+	iterations, err := strconv.Atoi(iterValue)
+	if err != nil {
+		return false, fmt.Errorf("invalid app lock iterations: %w", err)
+	}
+	expected, err := hex.DecodeString(hashHex)
+	if err != nil {
+		return false, fmt.Errorf("invalid app lock hash: %w", err)
+	}
+
+	hash, err := deriveKey(passphrase, salt, iterations)
+	if err != nil {
+		return false, err
+	}
+	return subtle.ConstantTimeCompare(hash, expected) == 1, nil
+}
+
+// ClearLockPassphrase removes the application lock
+func (d *Database) ClearLockPassphrase() error {
+	_, err := d.exec(`DELETE FROM meta WHERE key IN ('applock.salt', 'applock.iterations', 'applock.hash')`)
+	if err != nil {
+		return fmt.Errorf("failed to clear app lock: %w", err)
+	}
+
+	return nil
+}
+
+// SetLockIdleTimeout sets how long the app may sit idle before locking;
+// zero disables automatic locking
+func (d *Database) SetLockIdleTimeout(timeout time.Duration) error {
+	if timeout < 0 {
+		return fmt.Errorf("idle timeout cannot be negative")
+	}
+	return setMeta(execFunc(d.exec), "applock.idle_seconds", strconv.Itoa(int(timeout/time.Second)))
+}
+
+// LockIdleTimeout returns the configured idle timeout
+func (d *Database) LockIdleTimeout() (time.Duration, error) {
+	value, err := getMeta(d.conn().db, "applock.idle_seconds")
+	if errors.Is(err, sql.ErrNoRows) {
+		return defaultLockIdleTimeout, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to load idle timeout: %w", err)
+	}
+
+	seconds, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid idle timeout: %w", err)
+	}
+	return time.Duration(seconds) * time.Second, nil
+}
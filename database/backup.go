@@ -0,0 +1,740 @@
+package database
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"database/sql"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+const (
+	// backupMagic starts every backup file
+	backupMagic = "LVCBAK1\n"
+	// backupChunkSize is how much plaintext each encrypted chunk holds
+	backupChunkSize = 64 * 1024
+	// backupCheckCounter is the nonce counter reserved for the key check
+	backupCheckCounter = ^uint64(0)
+	// maxBackupIterations bounds the KDF work factor a backup file may ask
+	// for, so a crafted file can't stall a restore deriving its key
+	maxBackupIterations = 10 * kdfIterations
+	// safetyCopyKeep is how many copies set aside by restores, and
+	// separately by migrations, are kept beside the database
+	safetyCopyKeep = 3
+
+	backupFlagCompressed = 1 << 0
+	backupFlagEncrypted  = 1 << 1
+)
+
+var (
+	// ErrNotBackup is returned when restoring a file that isn't a backup
+	ErrNotBackup = errors.New("not a LanvoChat backup")
+	// ErrBackupPassphraseRequired is returned when restoring an encrypted
+	// backup without a passphrase
+	ErrBackupPassphraseRequired = errors.New("backup is encrypted; a passphrase is required")
+)
+
+// BackupSchedule configures automatic backups into Dir every
+// IntervalHours, keeping the newest Keep files. Zero IntervalHours
+// disables them. Encrypted backups use a key derived from Passphrase
+// when the schedule is saved; the passphrase itself is never stored or
+// returned, and saving with Encrypted set but no Passphrase keeps the
+// current key. Schedules of an encrypted database must be encrypted.
+type BackupSchedule struct {
+	Dir           string `json:"dir"`
+	IntervalHours int    `json:"interval_hours"`
+	Keep          int    `json:"keep"`
+	Compress      bool   `json:"compress"`
+	Encrypted     bool   `json:"encrypted"`
+	Passphrase    string `json:"passphrase,omitempty"`
+}
+
+// BackupOptions controls how a snapshot is written. A non-empty
+// Passphrase encrypts the whole file, independently of whether message
+// content is already encrypted at rest.
+type BackupOptions struct {
+	Compress   bool   `json:"compress"`
+	Passphrase string `json:"passphrase,omitempty"`
+
+	// key is a backup key derived in advance, used by scheduled backups
+	key *backupKey
+}
+
+// backupKey is a key derived from a backup passphrase with its KDF
+// parameters, which are written to each file so the passphrase opens it
+type backupKey struct {
+	Salt       []byte `json:"salt"`
+	Iterations int    `json:"iterations"`
+	Key        []byte `json:"key"`
+}
+
+// Backup writes a consistent snapshot of the live database to w using
+// SQLite's online backup, so it is safe while messages are being written.
+//
+// The file is backupMagic, a flags byte and, when encrypted, the KDF salt,
+// iteration count, nonce prefix and a sealed key check value, followed by
+// the snapshot: gzipped if requested, then split into AES-GCM sealed
+// chunks if encrypted.
+func (d *Database) Backup(w io.Writer, opts BackupOptions) error {
+	snapshot, err := d.snapshot()
+	if err != nil {
+		return err
+	}
+	defer os.Remove(snapshot)
+
+	file, err := os.Open(snapshot)
+	if err != nil {
+		return fmt.Errorf("failed to read snapshot: %w", err)
+	}
+	defer file.Close()
+
+	return encodeBackup(w, file, opts)
+}
+
+// encodeBackup writes a raw database read from r to w as a backup file
+func encodeBackup(w io.Writer, r io.Reader, opts BackupOptions) error {
+	var err error
+	key := opts.key
+	if opts.Passphrase != "" {
+		if key, err = newBackupKey(opts.Passphrase); err != nil {
+			return err
+		}
+	}
+
+	var flags byte
+	if opts.Compress {
+		flags |= backupFlagCompressed
+	}
+	if key != nil {
+		flags |= backupFlagEncrypted
+	}
+	if _, err := w.Write(append([]byte(backupMagic), flags)); err != nil {
+		return fmt.Errorf("failed to write backup: %w", err)
+	}
+
+	out := io.WriteCloser(nopWriteCloser{w})
+	if key != nil {
+		if out, err = newSealWriter(w, key); err != nil {
+			return err
+		}
+	}
+	var zw *gzip.Writer
+	if opts.Compress {
+		zw = gzip.NewWriter(out)
+	}
+
+	var dst io.Writer = out
+	if zw != nil {
+		dst = zw
+	}
+	if _, err := io.Copy(dst, r); err != nil {
+		return fmt.Errorf("failed to write backup: %w", err)
+	}
+	if zw != nil {
+		if err := zw.Close(); err != nil {
+			return fmt.Errorf("failed to write backup: %w", err)
+		}
+	}
+	if err := out.Close(); err != nil {
+		return fmt.Errorf("failed to write backup: %w", err)
+	}
+	return nil
+}
+
+// BackupToFile writes a backup to path, replacing it only once complete
+func (d *Database) BackupToFile(path string, opts BackupOptions) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create backup file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := d.Backup(tmp, opts); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write backup: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to save backup: %w", err)
+	}
+	return nil
+}
+
+// SetBackupSchedule saves the automatic backup configuration
+func (d *Database) SetBackupSchedule(schedule BackupSchedule) error {
+	if schedule.IntervalHours < 0 || schedule.Keep < 0 {
+		return fmt.Errorf("backup interval and retention cannot be negative")
+	}
+	if schedule.IntervalHours > 0 && schedule.Dir == "" {
+		return fmt.Errorf("backup directory is required")
+	}
+	if schedule.Keep == 0 {
+		schedule.Keep = 7
+	}
+	if schedule.Passphrase != "" {
+		schedule.Encrypted = true
+	}
+	if schedule.IntervalHours > 0 && !schedule.Encrypted && d.IsEncrypted() {
+		return fmt.Errorf("scheduled backups of an encrypted database must be encrypted")
+	}
+
+	// Derive the key before the write; it takes a while
+	var sealedKey string
+	if schedule.Passphrase != "" {
+		if len(schedule.Passphrase) < 8 {
+			return fmt.Errorf("backup passphrase must be at least 8 characters")
+		}
+		key, err := newBackupKey(schedule.Passphrase)
+		if err != nil {
+			return err
+		}
+		encoded, err := json.Marshal(key)
+		if err != nil {
+			return fmt.Errorf("failed to encode backup key: %w", err)
+		}
+		sealedKey = string(encoded)
+	}
+	schedule.Passphrase = ""
+
+	value, err := json.Marshal(schedule)
+	if err != nil {
+		return fmt.Errorf("failed to encode backup schedule: %w", err)
+	}
+
+	return d.withTx(func(tx *sql.Tx) error {
+		switch {
+		case sealedKey != "":
+			// Sealed in the write so a concurrent re-key can't leave it under a retired key
+			sealed, err := d.sealText(sealedKey)
+			if err != nil {
+				return fmt.Errorf("failed to encrypt backup key: %w", err)
+			}
+			if err := setMeta(tx, "backup.key", sealed); err != nil {
+				return err
+			}
+		case !schedule.Encrypted:
+			if _, err := tx.Exec(`DELETE FROM meta WHERE key = 'backup.key'`); err != nil {
+				return fmt.Errorf("failed to remove backup key: %w", err)
+			}
+		default:
+			var keys int
+			if err := tx.QueryRow(`SELECT COUNT(*) FROM meta WHERE key = 'backup.key'`).Scan(&keys); err != nil {
+				return fmt.Errorf("failed to load backup key: %w", err)
+			}
+			if keys == 0 {
+				return fmt.Errorf("a passphrase is required to encrypt scheduled backups")
+			}
+		}
+		return setMeta(tx, "backup.schedule", string(value))
+	})
+}
+
+// GetBackupSchedule returns the automatic backup configuration
+func (d *Database) GetBackupSchedule() (BackupSchedule, error) {
+	value, err := getMeta(d.conn().db, "backup.schedule")
+	if errors.Is(err, sql.ErrNoRows) {
+		return BackupSchedule{Keep: 7}, nil
+	}
+	if err != nil {
+		return BackupSchedule{}, fmt.Errorf("failed to load backup schedule: %w", err)
+	}
+
+	var schedule BackupSchedule
+	if err := json.Unmarshal([]byte(value), &schedule); err != nil {
+		return BackupSchedule{}, fmt.Errorf("invalid backup schedule: %w", err)
+	}
+	return schedule, nil
+}
+
+// ScheduledBackupOptions returns the options scheduled backups are
+// written with, including the stored key of an encrypted schedule. It
+// fails with ErrLocked while that key can't be decrypted.
+func (d *Database) ScheduledBackupOptions(schedule BackupSchedule) (BackupOptions, error) {
+	opts := BackupOptions{Compress: schedule.Compress}
+	if !schedule.Encrypted {
+		if d.IsEncrypted() {
+			return BackupOptions{}, fmt.Errorf("scheduled backups of an encrypted database must be encrypted")
+		}
+		return opts, nil
+	}
+
+	value, err := getMeta(d.conn().db, "backup.key")
+	if err != nil {
+		return BackupOptions{}, fmt.Errorf("failed to load backup key: %w", err)
+	}
+	if value, err = d.openText(value); err != nil {
+		return BackupOptions{}, err
+	}
+
+	var key backupKey
+	if err := json.Unmarshal([]byte(value), &key); err != nil {
+		return BackupOptions{}, fmt.Errorf("invalid backup key: %w", err)
+	}
+	opts.key = &key
+	return opts, nil
+}
+
+// LastBackupAt returns when the last automatic backup was written, or the
+// zero time if there was none
+func (d *Database) LastBackupAt() (time.Time, error) {
+	value, err := getMeta(d.conn().db, "backup.last_at")
+	if errors.Is(err, sql.ErrNoRows) {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to load last backup time: %w", err)
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+// SetLastBackupAt records when an automatic backup was written
+func (d *Database) SetLastBackupAt(at time.Time) error {
+	return setMeta(execFunc(d.exec), "backup.last_at", at.UTC().Format(time.RFC3339))
+}
+
+// snapshot copies the live database to a temporary file with the online
+// backup API and returns its path
+func (d *Database) snapshot() (string, error) {
+	tmp, err := os.CreateTemp(filepath.Dir(d.path), filepath.Base(d.path)+".snapshot-*.tmp")
+	if err != nil {
+		return "", fmt.Errorf("failed to create snapshot: %w", err)
+	}
+	path := tmp.Name()
+	tmp.Close()
+
+	if err := d.copyTo(path); err != nil {
+		os.Remove(path)
+		return "", fmt.Errorf("failed to snapshot database: %w", err)
+	}
+	return path, nil
+}
+
+// copyTo runs an online backup of the main database into path
+func (d *Database) copyTo(path string) error {
+	destDriverConn, err := (&sqlite3.SQLiteDriver{}).Open(path)
+	if err != nil {
+		return err
+	}
+	destConn := destDriverConn.(*sqlite3.SQLiteConn)
+	defer destConn.Close()
+
+	conn, err := d.conn().db.Conn(context.Background())
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	return conn.Raw(func(driverConn interface{}) error {
+		srcConn, ok := driverConn.(*sqlite3.SQLiteConn)
+		if !ok {
+			return fmt.Errorf("unexpected driver connection %T", driverConn)
+		}
+
+		backup, err := destConn.Backup("main", srcConn, "main")
+		if err != nil {
+			return err
+		}
+
+		// Copy every page in one step so the snapshot is a single point in time
+		if _, err := backup.Step(-1); err != nil {
+			backup.Finish()
+			return err
+		}
+		return backup.Finish()
+	})
+}
+
+// Restore replaces the database with a backup. The snapshot is decoded to
+// a temporary file and checked with integrity_check and its schema version
+// before it is swapped in; the replaced file is kept alongside as
+// "<path>.pre-restore-<timestamp>.bak". Passphrase is only needed for
+// encrypted backups. Afterwards the database is reopened, and starts
+// locked if the restored store is encrypted at rest. If the restored file
+// can't be opened, the previous database is put back. Other users of the
+// database get errors while it is swapped.
+func (d *Database) Restore(r io.Reader, passphrase string) error {
+	tmp, err := os.CreateTemp(filepath.Dir(d.path), filepath.Base(d.path)+".restore-*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create restore file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := decodeBackup(tmp, r, passphrase); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write restore file: %w", err)
+	}
+	if err := validateSnapshot(tmp.Name()); err != nil {
+		return err
+	}
+
+	if err := d.close(); err != nil {
+		return errors.Join(fmt.Errorf("failed to close database: %w", err), d.reopen())
+	}
+
+	aside := fmt.Sprintf("%s.pre-restore-%s.bak", d.path, time.Now().Format("20060102-150405"))
+	if err := os.Rename(d.path, aside); err != nil {
+		return errors.Join(fmt.Errorf("failed to set aside current database: %w", err), d.reopen())
+	}
+	if err := d.swapIn(tmp.Name()); err != nil {
+		return errors.Join(fmt.Errorf("failed to swap in backup: %w", err), d.putBack(aside))
+	}
+
+	if err := d.reopen(); err != nil {
+		return errors.Join(fmt.Errorf("restored backup could not be opened: %w", err), d.putBack(aside))
+	}
+
+	log.Printf("Restored database from backup; previous database kept at %s", aside)
+	d.pruneSafetyCopies()
+	return nil
+}
+
+// swapIn moves path into place as the database file. A journal or WAL
+// left by the replaced file must not be applied to the new one.
+func (d *Database) swapIn(path string) error {
+	for _, suffix := range []string{"-journal", "-wal", "-shm"} {
+		os.Remove(d.path + suffix)
+	}
+	return os.Rename(path, d.path)
+}
+
+// putBack reinstates the database set aside by a failed restore and
+// reopens it
+func (d *Database) putBack(aside string) error {
+	os.Remove(d.path)
+	if err := d.swapIn(aside); err != nil {
+		return fmt.Errorf("failed to put back previous database (kept at %s): %w", aside, err)
+	}
+	return d.reopen()
+}
+
+// reopen opens the database file again after close. The store starts
+// locked if it is encrypted.
+func (d *Database) reopen() error {
+	if err := d.open(); err != nil {
+		return fmt.Errorf("failed to reopen database: %w", err)
+	}
+	return nil
+}
+
+// pruneSafetyCopies removes all but the newest safetyCopyKeep copies of
+// the database set aside by restores, and likewise by migrations
+func (d *Database) pruneSafetyCopies() {
+	entries, err := os.ReadDir(filepath.Dir(d.path))
+	if err != nil {
+		log.Printf("Warning: failed to list safety copies: %v", err)
+		return
+	}
+
+	base := filepath.Base(d.path)
+	copies := make(map[string][]string)
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasPrefix(name, base+".pre-") || !strings.HasSuffix(name, ".bak") {
+			continue
+		}
+		if _, ok := safetyCopyTime(name); !ok {
+			continue
+		}
+		kind := "migration"
+		if strings.HasPrefix(name, base+".pre-restore-") {
+			kind = "restore"
+		}
+		copies[kind] = append(copies[kind], name)
+	}
+
+	for _, names := range copies {
+		// Newest first by the timestamp in the name; the version in
+		// migration copies doesn't sort lexically
+		sort.Slice(names, func(i, j int) bool {
+			ti, _ := safetyCopyTime(names[i])
+			tj, _ := safetyCopyTime(names[j])
+			return ti.After(tj)
+		})
+		if len(names) <= safetyCopyKeep {
+			continue
+		}
+		for _, name := range names[safetyCopyKeep:] {
+			if err := os.Remove(filepath.Join(filepath.Dir(d.path), name)); err != nil {
+				log.Printf("Warning: failed to remove old safety copy %s: %v", name, err)
+			}
+		}
+	}
+}
+
+// safetyCopyTime parses the timestamp at the end of a safety copy's name
+func safetyCopyTime(name string) (time.Time, bool) {
+	const layout = "20060102-150405"
+	stem := strings.TrimSuffix(name, ".bak")
+	if len(stem) < len(layout) {
+		return time.Time{}, false
+	}
+	t, err := time.ParseInLocation(layout, stem[len(stem)-len(layout):], time.Local)
+	return t, err == nil
+}
+
+// validateSnapshot checks that a decoded backup is an intact LanvoChat
+// database this version can open
+func validateSnapshot(path string) error {
+	db, err := sql.Open("sqlite3", "file:"+path+"?mode=ro")
+	if err != nil {
+		return fmt.Errorf("failed to open backup: %w", err)
+	}
+	defer func() {
+		db.Close()
+		// Snapshots of a WAL database get WAL side files when opened
+		os.Remove(path + "-wal")
+		os.Remove(path + "-shm")
+	}()
+
+	var result string
+	if err := db.QueryRow(`PRAGMA integrity_check`).Scan(&result); err != nil {
+		return fmt.Errorf("backup is not a valid database: %w", err)
+	}
+	if result != "ok" {
+		return fmt.Errorf("backup failed integrity check: %s", result)
+	}
+
+	var version int
+	if err := db.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&version); err != nil {
+		return fmt.Errorf("backup is not a LanvoChat database: %w", err)
+	}
+	if version > latestSchemaVersion() {
+		return fmt.Errorf("%w (backup schema version %d, supported up to %d)", ErrSchemaTooNew, version, latestSchemaVersion())
+	}
+
+	return nil
+}
+
+// decodeBackup reads a backup file, writing the raw database to w
+func decodeBackup(w io.Writer, r io.Reader, passphrase string) error {
+	br := bufio.NewReader(r)
+
+	header := make([]byte, len(backupMagic)+1)
+	if _, err := io.ReadFull(br, header); err != nil || string(header[:len(backupMagic)]) != backupMagic {
+		return ErrNotBackup
+	}
+	flags := header[len(backupMagic)]
+
+	var in io.Reader = br
+	if flags&backupFlagEncrypted != 0 {
+		if passphrase == "" {
+			return ErrBackupPassphraseRequired
+		}
+		opened, err := newOpenReader(br, passphrase)
+		if err != nil {
+			return err
+		}
+		in = opened
+	}
+	if flags&backupFlagCompressed != 0 {
+		zr, err := gzip.NewReader(in)
+		if err != nil {
+			return fmt.Errorf("failed to decompress backup: %w", err)
+		}
+		defer zr.Close()
+		in = zr
+	}
+
+	if _, err := io.Copy(w, in); err != nil {
+		return fmt.Errorf("failed to read backup: %w", err)
+	}
+	return nil
+}
+
+// nopWriteCloser adds a no-op Close to a writer
+type nopWriteCloser struct{ io.Writer }
+
+func (nopWriteCloser) Close() error { return nil }
+
+// sealWriter encrypts a stream as a sequence of AES-GCM chunks. Each chunk
+// is a final-flag byte, a 4-byte length and the ciphertext; the nonce is
+// a random prefix plus the chunk counter, and the flag is authenticated
+// so truncation at a chunk boundary is detected.
+type sealWriter struct {
+	w       io.Writer
+	aead    cipher.AEAD
+	prefix  []byte
+	counter uint64
+	buf     []byte
+}
+
+// newBackupKey derives a backup key from passphrase with a fresh salt
+func newBackupKey(passphrase string) (*backupKey, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, fmt.Errorf("failed to generate salt: %w", err)
+	}
+
+	key, err := deriveKey(passphrase, salt, kdfIterations)
+	if err != nil {
+		return nil, err
+	}
+	return &backupKey{Salt: salt, Iterations: kdfIterations, Key: key}, nil
+}
+
+// newSealWriter writes the key's KDF parameters, a nonce prefix and the
+// key check to w
+func newSealWriter(w io.Writer, key *backupKey) (*sealWriter, error) {
+	prefix := make([]byte, 4)
+	if _, err := rand.Read(prefix); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+
+	block, err := aes.NewCipher(key.Key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+
+	header := append(append([]byte{}, key.Salt...), binary.BigEndian.AppendUint32(nil, uint32(key.Iterations))...)
+	header = append(header, prefix...)
+	header = aead.Seal(header, backupNonce(prefix, backupCheckCounter), []byte(keyCheckValue), nil)
+	if _, err := w.Write(header); err != nil {
+		return nil, fmt.Errorf("failed to write backup: %w", err)
+	}
+
+	return &sealWriter{w: w, aead: aead, prefix: prefix}, nil
+}
+
+// Write buffers plaintext, sealing every full chunk except the last
+func (s *sealWriter) Write(p []byte) (int, error) {
+	s.buf = append(s.buf, p...)
+	for len(s.buf) > backupChunkSize {
+		if err := s.seal(s.buf[:backupChunkSize], false); err != nil {
+			return 0, err
+		}
+		s.buf = s.buf[backupChunkSize:]
+	}
+	return len(p), nil
+}
+
+// Close seals the remaining plaintext as the final chunk
+func (s *sealWriter) Close() error {
+	return s.seal(s.buf, true)
+}
+
+// seal encrypts and writes one chunk
+func (s *sealWriter) seal(chunk []byte, final bool) error {
+	flag := []byte{0}
+	if final {
+		flag[0] = 1
+	}
+
+	sealed := s.aead.Seal(nil, backupNonce(s.prefix, s.counter), chunk, flag)
+	s.counter++
+
+	frame := append(flag, binary.BigEndian.AppendUint32(nil, uint32(len(sealed)))...)
+	if _, err := s.w.Write(append(frame, sealed...)); err != nil {
+		return err
+	}
+	return nil
+}
+
+// openReader decrypts a stream written by sealWriter
+type openReader struct {
+	r       io.Reader
+	aead    cipher.AEAD
+	prefix  []byte
+	counter uint64
+	buf     []byte
+	done    bool
+}
+
+// newOpenReader reads the KDF parameters, derives the backup key and
+// verifies it against the key check
+func newOpenReader(r io.Reader, passphrase string) (*openReader, error) {
+	header := make([]byte, 16+4+4)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, ErrNotBackup
+	}
+	salt, iterations, prefix := header[:16], binary.BigEndian.Uint32(header[16:20]), header[20:]
+	if iterations == 0 || iterations > maxBackupIterations {
+		return nil, ErrNotBackup
+	}
+
+	aead, err := deriveAEAD(passphrase, salt, int(iterations))
+	if err != nil {
+		return nil, err
+	}
+
+	check := make([]byte, len(keyCheckValue)+aead.Overhead())
+	if _, err := io.ReadFull(r, check); err != nil {
+		return nil, ErrNotBackup
+	}
+	if _, err := aead.Open(nil, backupNonce(prefix, backupCheckCounter), check, nil); err != nil {
+		return nil, ErrWrongPassphrase
+	}
+
+	return &openReader{r: r, aead: aead, prefix: prefix}, nil
+}
+
+// Read returns decrypted plaintext, opening chunks as needed
+func (o *openReader) Read(p []byte) (int, error) {
+	for len(o.buf) == 0 {
+		if o.done {
+			return 0, io.EOF
+		}
+		if err := o.next(); err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, o.buf)
+	o.buf = o.buf[n:]
+	return n, nil
+}
+
+// next reads and opens the next chunk
+func (o *openReader) next() error {
+	frame := make([]byte, 5)
+	if _, err := io.ReadFull(o.r, frame); err != nil {
+		return fmt.Errorf("backup is truncated")
+	}
+	size := binary.BigEndian.Uint32(frame[1:])
+	if size > backupChunkSize+uint32(o.aead.Overhead()) {
+		return fmt.Errorf("backup is corrupt")
+	}
+
+	sealed := make([]byte, size)
+	if _, err := io.ReadFull(o.r, sealed); err != nil {
+		return fmt.Errorf("backup is truncated")
+	}
+
+	plain, err := o.aead.Open(nil, backupNonce(o.prefix, o.counter), sealed, frame[:1])
+	if err != nil {
+		return fmt.Errorf("backup is corrupt")
+	}
+	o.counter++
+
+	o.buf = plain
+	o.done = frame[0] == 1
+	return nil
+}
+
+// backupNonce builds a chunk nonce from the file's prefix and a counter
+func backupNonce(prefix []byte, counter uint64) []byte {
+	return binary.BigEndian.AppendUint64(append([]byte{}, prefix...), counter)
+}
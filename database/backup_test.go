@@ -0,0 +1,159 @@
+package database
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const testBackupPassphrase = "backup passphrase"
+
+func TestBackupEncodeDecode(t *testing.T) {
+	// Derived once; every encrypted case still derives it again to decode
+	key, err := newBackupKey(testBackupPassphrase)
+	if err != nil {
+		t.Fatalf("failed to derive key: %v", err)
+	}
+
+	sizes := []int{0, 1, backupChunkSize - 1, backupChunkSize, backupChunkSize + 1, 3*backupChunkSize + 17}
+	for _, size := range sizes {
+		payload := make([]byte, size)
+		rand.Read(payload)
+
+		for _, opts := range []BackupOptions{
+			{},
+			{Compress: true},
+			{key: key},
+			{Compress: true, key: key},
+		} {
+			name := fmt.Sprintf("%d bytes compressed=%v encrypted=%v", size, opts.Compress, opts.key != nil)
+			t.Run(name, func(t *testing.T) {
+				var encoded bytes.Buffer
+				if err := encodeBackup(&encoded, bytes.NewReader(payload), opts); err != nil {
+					t.Fatalf("failed to encode: %v", err)
+				}
+				if opts.key != nil && size >= 16 && bytes.Contains(encoded.Bytes(), payload) {
+					t.Fatal("encrypted backup contains the plaintext")
+				}
+
+				passphrase := ""
+				if opts.key != nil {
+					passphrase = testBackupPassphrase
+				}
+				var decoded bytes.Buffer
+				if err := decodeBackup(&decoded, bytes.NewReader(encoded.Bytes()), passphrase); err != nil {
+					t.Fatalf("failed to decode: %v", err)
+				}
+				if !bytes.Equal(decoded.Bytes(), payload) {
+					t.Fatalf("decoded %d bytes, want the original %d", decoded.Len(), size)
+				}
+			})
+		}
+	}
+}
+
+func TestBackupDecodeErrors(t *testing.T) {
+	key, err := newBackupKey(testBackupPassphrase)
+	if err != nil {
+		t.Fatalf("failed to derive key: %v", err)
+	}
+	payload := make([]byte, 2*backupChunkSize+100)
+	rand.Read(payload)
+
+	var buf bytes.Buffer
+	if err := encodeBackup(&buf, bytes.NewReader(payload), BackupOptions{key: key}); err != nil {
+		t.Fatalf("failed to encode: %v", err)
+	}
+	encrypted := buf.Bytes()
+
+	// The header, KDF parameters, nonce prefix and sealed key check
+	// precede the chunks, each a flag, a length and the ciphertext
+	header := len(backupMagic) + 1 + 16 + 4 + 4 + len(keyCheckValue) + 16
+	fullChunk := 5 + backupChunkSize + 16
+
+	tampered := bytes.Clone(encrypted)
+	tampered[header+fullChunk+10] ^= 1
+
+	// Rejected before any key is derived, however long that would take
+	iterations := len(backupMagic) + 1 + 16
+	slowKDF := bytes.Clone(encrypted)
+	binary.BigEndian.PutUint32(slowKDF[iterations:], ^uint32(0))
+	noKDF := bytes.Clone(encrypted)
+	binary.BigEndian.PutUint32(noKDF[iterations:], 0)
+
+	tests := []struct {
+		name       string
+		data       []byte
+		passphrase string
+		want       error
+	}{
+		{"not a backup", []byte("SQLite format 3\x00"), "", ErrNotBackup},
+		{"empty", nil, "", ErrNotBackup},
+		{"missing passphrase", encrypted, "", ErrBackupPassphraseRequired},
+		{"wrong passphrase", encrypted, "not the passphrase", ErrWrongPassphrase},
+		{"cut in the key check", encrypted[:header-1], testBackupPassphrase, ErrNotBackup},
+		{"cut inside a chunk", encrypted[:len(encrypted)-1], testBackupPassphrase, nil},
+		{"final chunk dropped", encrypted[:header+2*fullChunk], testBackupPassphrase, nil},
+		{"tampered chunk", tampered, testBackupPassphrase, nil},
+		{"excessive KDF iterations", slowKDF, testBackupPassphrase, ErrNotBackup},
+		{"no KDF iterations", noKDF, testBackupPassphrase, ErrNotBackup},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := decodeBackup(&bytes.Buffer{}, bytes.NewReader(tt.data), tt.passphrase)
+			if err == nil {
+				t.Fatal("decoded a bad backup")
+			}
+			if tt.want != nil && !errors.Is(err, tt.want) {
+				t.Fatalf("got %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestBackupRestore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "live.db")
+	d, err := NewDatabase(path)
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	defer d.Close()
+
+	start := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	ids := insertMessages(t, d, "peer", start, 3)
+
+	var backup bytes.Buffer
+	if err := d.Backup(&backup, BackupOptions{Compress: true, Passphrase: testBackupPassphrase}); err != nil {
+		t.Fatalf("failed to back up: %v", err)
+	}
+
+	// Written after the backup, so the restore takes it away again
+	insertMessages(t, d, "peer", start.Add(time.Hour), 2)
+
+	if err := d.Restore(bytes.NewReader(backup.Bytes()), "not the passphrase"); !errors.Is(err, ErrWrongPassphrase) {
+		t.Fatalf("restore with the wrong passphrase: got %v, want %v", err, ErrWrongPassphrase)
+	}
+	if err := d.Restore(bytes.NewReader(backup.Bytes()), testBackupPassphrase); err != nil {
+		t.Fatalf("failed to restore: %v", err)
+	}
+
+	page, err := d.GetMessagesPage(DirectConversationID("peer"), HistoryCursor{})
+	if err != nil {
+		t.Fatalf("failed to read restored history: %v", err)
+	}
+	assertPage(t, page, ids, false, false)
+
+	aside, err := filepath.Glob(path + ".pre-restore-*.bak")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(aside) != 1 {
+		t.Fatalf("got %v, want the replaced database kept aside", aside)
+	}
+}
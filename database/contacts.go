@@ -0,0 +1,185 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// Limits on contact details, which are free text entered by the user
+const (
+	maxNicknameLength = 64
+	maxNotesLength    = 4000
+	maxTagLength      = 32
+	maxContactTags    = 20
+)
+
+// ContactDetails are what the user keeps about a peer locally; none of it
+// is sent to the peer. Tags group contacts, e.g. by team or department.
+type ContactDetails struct {
+	Nickname string   `json:"nickname"`
+	Notes    string   `json:"notes"`
+	Tags     []string `json:"tags"`
+	Favorite bool     `json:"favorite"`
+	Hidden   bool     `json:"hidden"`
+}
+
+// ContactFilter selects contacts for the contact list. The zero value
+// lists every contact that isn't hidden.
+type ContactFilter struct {
+	Tag           string `json:"tag"`
+	FavoritesOnly bool   `json:"favorites_only"`
+	IncludeHidden bool   `json:"include_hidden"`
+}
+
+// Matches reports whether a peer passes the filter
+func (f ContactFilter) Matches(peer Peer) bool {
+	if peer.Hidden && !f.IncludeHidden {
+		return false
+	}
+	if f.FavoritesOnly && !peer.Favorite {
+		return false
+	}
+	if f.Tag == "" {
+		return true
+	}
+	for _, tag := range peer.Tags {
+		if strings.EqualFold(tag, f.Tag) {
+			return true
+		}
+	}
+	return false
+}
+
+// DisplayName is the nickname if one is set, otherwise the name the peer
+// announced
+func (p Peer) DisplayName() string {
+	if p.Nickname != "" {
+		return p.Nickname
+	}
+	return p.Name
+}
+
+// UpdateContact replaces the local details kept about a known peer. The
+// nickname and notes are encrypted at rest like message content.
+func (d *Database) UpdateContact(peerID string, details ContactDetails) error {
+	details.Nickname = strings.TrimSpace(details.Nickname)
+	details.Notes = strings.TrimSpace(details.Notes)
+	if utf8.RuneCountInString(details.Nickname) > maxNicknameLength {
+		return fmt.Errorf("nickname is longer than %d characters", maxNicknameLength)
+	}
+	if utf8.RuneCountInString(details.Notes) > maxNotesLength {
+		return fmt.Errorf("notes are longer than %d characters", maxNotesLength)
+	}
+	tags, err := normalizeTags(details.Tags)
+	if err != nil {
+		return err
+	}
+
+	return d.withTx(func(tx *sql.Tx) error {
+		// Sealed in the write so a concurrent re-key can't leave them under a retired key
+		nickname, err := d.sealText(details.Nickname)
+		if err != nil {
+			return fmt.Errorf("failed to encrypt nickname: %w", err)
+		}
+		notes, err := d.sealText(details.Notes)
+		if err != nil {
+			return fmt.Errorf("failed to encrypt notes: %w", err)
+		}
+
+		result, err := tx.Exec(`UPDATE peers SET nickname = ?, notes = ?, favorite = ?, hidden = ? WHERE peer_id = ?`,
+			nickname, notes, details.Favorite, details.Hidden, peerID)
+		if err != nil {
+			return fmt.Errorf("failed to update contact: %w", err)
+		}
+		if affected, _ := result.RowsAffected(); affected == 0 {
+			return fmt.Errorf("peer %s not found", peerID)
+		}
+
+		if _, err := tx.Exec(`DELETE FROM contact_tags WHERE peer_id = ?`, peerID); err != nil {
+			return fmt.Errorf("failed to update contact tags: %w", err)
+		}
+		for _, tag := range tags {
+			if _, err := tx.Exec(`INSERT INTO contact_tags (peer_id, tag) VALUES (?, ?)`, peerID, tag); err != nil {
+				return fmt.Errorf("failed to update contact tags: %w", err)
+			}
+		}
+		return nil
+	})
+}
+
+// normalizeTags trims tags and drops empty ones and duplicates, which
+// differ only in case, keeping the first spelling
+func normalizeTags(tags []string) ([]string, error) {
+	seen := make(map[string]bool, len(tags))
+	normalized := []string{}
+	for _, tag := range tags {
+		tag = strings.TrimSpace(tag)
+		key := strings.ToLower(tag)
+		if tag == "" || seen[key] {
+			continue
+		}
+		if utf8.RuneCountInString(tag) > maxTagLength {
+			return nil, fmt.Errorf("tag %q is longer than %d characters", tag, maxTagLength)
+		}
+		seen[key] = true
+		normalized = append(normalized, tag)
+	}
+
+	if len(normalized) > maxContactTags {
+		return nil, fmt.Errorf("a contact can have at most %d tags", maxContactTags)
+	}
+	return normalized, nil
+}
+
+// GetContactTags returns every tag in use, alphabetically
+func (d *Database) GetContactTags() ([]string, error) {
+	rows, err := d.conn().db.Query(`SELECT DISTINCT tag FROM contact_tags ORDER BY tag`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query contact tags: %w", err)
+	}
+	defer rows.Close()
+
+	tags := []string{}
+	for rows.Next() {
+		var tag string
+		if err := rows.Scan(&tag); err != nil {
+			return nil, fmt.Errorf("failed to scan contact tag: %w", err)
+		}
+		tags = append(tags, tag)
+	}
+
+	return tags, rows.Err()
+}
+
+// loadContactTags fills in the tags of each peer
+func (d *Database) loadContactTags(peers []Peer) error {
+	rows, err := d.conn().db.Query(`SELECT peer_id, tag FROM contact_tags`)
+	if err != nil {
+		return fmt.Errorf("failed to query contact tags: %w", err)
+	}
+	defer rows.Close()
+
+	tags := make(map[string][]string)
+	for rows.Next() {
+		var peerID, tag string
+		if err := rows.Scan(&peerID, &tag); err != nil {
+			return fmt.Errorf("failed to scan contact tag: %w", err)
+		}
+		tags[peerID] = append(tags[peerID], tag)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for i := range peers {
+		peers[i].Tags = tags[peers[i].PeerID]
+		if peers[i].Tags == nil {
+			peers[i].Tags = []string{}
+		}
+		sort.Strings(peers[i].Tags)
+	}
+	return nil
+}
@@ -0,0 +1,423 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	// ConversationDirect is a one-to-one chat with a single peer
+	ConversationDirect = "direct"
+	// ConversationGroup is a chat with a fixed set of members
+	ConversationGroup = "group"
+	// ConversationBroadcast is the shared channel reaching every active peer
+	ConversationBroadcast = "broadcast"
+
+	// BroadcastConversationID is the ID of the single broadcast conversation
+	BroadcastConversationID = "broadcast"
+)
+
+// Conversation is a direct chat, group chat or the broadcast channel.
+// Direct conversation IDs are "direct:<peer ID>", group IDs are
+// "group:<random ID>" shared by all members.
+type Conversation struct {
+	ID           string          `json:"id"`
+	Kind         string          `json:"kind"`
+	Title        string          `json:"title"`
+	Members      []string        `json:"members"`
+	CreatedAt    time.Time       `json:"created_at"`
+	LastActivity time.Time       `json:"last_activity"`
+	Muted        bool            `json:"muted"`
+	Archived     bool            `json:"archived"`
+	UnreadCount  int             `json:"unread_count"`
+	Retention    RetentionPolicy `json:"retention"`
+	LegalHold    bool            `json:"legal_hold"`
+
+	// MembersChangedAt versions a group's member list, which peers send
+	// along so the newest list wins; zero for groups from older versions
+	MembersChangedAt time.Time `json:"members_changed_at"`
+}
+
+// ConversationSettings are the per-conversation preferences a user can change
+type ConversationSettings struct {
+	Title    string `json:"title"`
+	Muted    bool   `json:"muted"`
+	Archived bool   `json:"archived"`
+}
+
+// DirectConversationID returns the conversation ID for chatting with a peer
+func DirectConversationID(peerID string) string {
+	return ConversationDirect + ":" + peerID
+}
+
+// NewGroupConversationID returns a fresh random group conversation ID
+func NewGroupConversationID() string {
+	return ConversationGroup + ":" + newMessageID()
+}
+
+// conversationKind derives a conversation's kind from its ID
+func conversationKind(conversationID string) (string, error) {
+	switch {
+	case conversationID == BroadcastConversationID:
+		return ConversationBroadcast, nil
+	case strings.HasPrefix(conversationID, ConversationDirect+":") && len(conversationID) > len(ConversationDirect)+1:
+		return ConversationDirect, nil
+	case strings.HasPrefix(conversationID, ConversationGroup+":") && len(conversationID) > len(ConversationGroup)+1:
+		return ConversationGroup, nil
+	default:
+		return "", fmt.Errorf("invalid conversation ID %q", conversationID)
+	}
+}
+
+// ensureConversation creates a conversation row if needed and records
+// activity at the given time
+func (d *Database) ensureConversation(tx *sql.Tx, conversationID string, activity time.Time) error {
+	kind, err := conversationKind(conversationID)
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.Stmt(d.conn().stmts.upsertConversation).Exec(conversationID, kind, activity, activity); err != nil {
+		return fmt.Errorf("failed to save conversation: %w", err)
+	}
+
+	// The other side of a direct chat is its only member
+	if kind == ConversationDirect {
+		peerID := strings.TrimPrefix(conversationID, ConversationDirect+":")
+		if err := d.addMembers(tx, conversationID, []string{peerID}, activity); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addMembers adds peers to a conversation, ignoring existing members
+func (d *Database) addMembers(tx *sql.Tx, conversationID string, peerIDs []string, joinedAt time.Time) error {
+	insert := tx.Stmt(d.conn().stmts.addMember)
+	for _, peerID := range peerIDs {
+		if peerID == "" {
+			continue
+		}
+		if _, err := insert.Exec(conversationID, peerID, joinedAt); err != nil {
+			return fmt.Errorf("failed to add conversation member: %w", err)
+		}
+	}
+	return nil
+}
+
+// isMember reports whether a peer belongs to a conversation. Everyone
+// belongs to the broadcast channel.
+func isMember(tx *sql.Tx, conversationID, peerID string) (bool, error) {
+	if conversationID == BroadcastConversationID {
+		return true, nil
+	}
+
+	var member bool
+	err := tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM conversation_members WHERE conversation_id = ? AND peer_id = ?)`,
+		conversationID, peerID).Scan(&member)
+	if err != nil {
+		return false, fmt.Errorf("failed to check conversation membership: %w", err)
+	}
+	return member, nil
+}
+
+// CreateGroupConversation starts a new group chat with the given members
+func (d *Database) CreateGroupConversation(title string, members []string) (Conversation, error) {
+	title = strings.TrimSpace(title)
+	if title == "" {
+		return Conversation{}, fmt.Errorf("group title is required")
+	}
+	if len(members) == 0 {
+		return Conversation{}, fmt.Errorf("group needs at least one member")
+	}
+
+	conversationID := NewGroupConversationID()
+	return d.AddConversationMembers(conversationID, title, members)
+}
+
+// SaveGroupConversation creates a group chat a peer that doesn't version
+// the member list told us about. Such a list can't be ordered against
+// ours, so the members of a group we already know are left alone.
+func (d *Database) SaveGroupConversation(conversationID, title string, members []string) error {
+	if kind, err := conversationKind(conversationID); err != nil || kind != ConversationGroup {
+		return fmt.Errorf("invalid group conversation ID %q", conversationID)
+	}
+
+	return d.withTx(func(tx *sql.Tx) error {
+		var exists bool
+		err := tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM conversations WHERE id = ?)`, conversationID).Scan(&exists)
+		if err != nil {
+			return fmt.Errorf("failed to load conversation: %w", err)
+		}
+		if exists {
+			return nil
+		}
+
+		now := time.Now().UTC()
+		if err := d.saveGroup(tx, conversationID, title, now); err != nil {
+			return err
+		}
+		return d.addMembers(tx, conversationID, members, now)
+	})
+}
+
+// AddConversationMembers creates a group chat or adds peers to one,
+// returning the group with its new member list to send to its members
+func (d *Database) AddConversationMembers(conversationID, title string, members []string) (Conversation, error) {
+	if kind, err := conversationKind(conversationID); err != nil || kind != ConversationGroup {
+		return Conversation{}, fmt.Errorf("invalid group conversation ID %q", conversationID)
+	}
+
+	err := d.withTx(func(tx *sql.Tx) error {
+		now := time.Now().UTC()
+		if err := d.saveGroup(tx, conversationID, title, now); err != nil {
+			return err
+		}
+		if err := d.addMembers(tx, conversationID, members, now); err != nil {
+			return err
+		}
+		return touchMembers(tx, conversationID, now)
+	})
+	if err != nil {
+		return Conversation{}, err
+	}
+	return d.GetConversation(conversationID)
+}
+
+// RemoveConversationMember removes a peer from a group chat, returning
+// the group with its new member list to send to its members
+func (d *Database) RemoveConversationMember(conversationID, peerID string) (Conversation, error) {
+	err := d.withTx(func(tx *sql.Tx) error {
+		result, err := tx.Exec(`DELETE FROM conversation_members WHERE conversation_id = ? AND peer_id = ?`,
+			conversationID, peerID)
+		if err != nil {
+			return fmt.Errorf("failed to remove conversation member: %w", err)
+		}
+		if affected, _ := result.RowsAffected(); affected == 0 {
+			return fmt.Errorf("peer %s is not a member of %s", peerID, conversationID)
+		}
+		return touchMembers(tx, conversationID, time.Now().UTC())
+	})
+	if err != nil {
+		return Conversation{}, err
+	}
+	return d.GetConversation(conversationID)
+}
+
+// ApplyGroupMembers replaces a group chat's member list with one a peer
+// sent, creating the group if it is new. The list is only taken if it
+// changed after the stored one and the sender is a member, so removals
+// reach every member and a stale list can't undo them. It reports
+// whether the list was applied.
+func (d *Database) ApplyGroupMembers(conversationID, title, senderID string, members []string, changedAt time.Time) (bool, error) {
+	if kind, err := conversationKind(conversationID); err != nil || kind != ConversationGroup {
+		return false, fmt.Errorf("invalid group conversation ID %q", conversationID)
+	}
+
+	applied := false
+	err := d.withTx(func(tx *sql.Tx) error {
+		now := time.Now().UTC()
+		if err := d.saveGroup(tx, conversationID, title, now); err != nil {
+			return err
+		}
+
+		var storedAt int64
+		var count int
+		err := tx.QueryRow(`
+			SELECT members_changed_at, (SELECT COUNT(*) FROM conversation_members WHERE conversation_id = ?)
+			FROM conversations WHERE id = ?
+		`, conversationID, conversationID).Scan(&storedAt, &count)
+		if err != nil {
+			return fmt.Errorf("failed to load conversation members: %w", err)
+		}
+		if changedAt.UnixNano() <= storedAt {
+			return nil
+		}
+		if count > 0 {
+			member, err := isMember(tx, conversationID, senderID)
+			if err != nil || !member {
+				return err
+			}
+		}
+
+		keep := make(map[string]bool, len(members))
+		for _, peerID := range members {
+			keep[peerID] = true
+		}
+		current, err := conversationMembers(tx, conversationID)
+		if err != nil {
+			return err
+		}
+		for _, peerID := range current {
+			if keep[peerID] {
+				continue
+			}
+			_, err := tx.Exec(`DELETE FROM conversation_members WHERE conversation_id = ? AND peer_id = ?`, conversationID, peerID)
+			if err != nil {
+				return fmt.Errorf("failed to remove conversation member: %w", err)
+			}
+		}
+		if err := d.addMembers(tx, conversationID, members, now); err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(`UPDATE conversations SET members_changed_at = ? WHERE id = ?`, changedAt.UnixNano(), conversationID)
+		if err != nil {
+			return fmt.Errorf("failed to save member list version: %w", err)
+		}
+		applied = true
+		return nil
+	})
+	return applied, err
+}
+
+// saveGroup creates a group chat if needed, adopting the announced title
+// unless it was renamed locally
+func (d *Database) saveGroup(tx *sql.Tx, conversationID, title string, now time.Time) error {
+	if err := d.ensureConversation(tx, conversationID, now); err != nil {
+		return err
+	}
+
+	_, err := tx.Exec(`UPDATE conversations SET title = ? WHERE id = ? AND title = ''`, title, conversationID)
+	if err != nil {
+		return fmt.Errorf("failed to save conversation title: %w", err)
+	}
+	return nil
+}
+
+// conversationMembers returns the members of a conversation
+func conversationMembers(tx *sql.Tx, conversationID string) ([]string, error) {
+	rows, err := tx.Query(`SELECT peer_id FROM conversation_members WHERE conversation_id = ?`, conversationID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query conversation members: %w", err)
+	}
+	defer rows.Close()
+
+	var members []string
+	for rows.Next() {
+		var peerID string
+		if err := rows.Scan(&peerID); err != nil {
+			return nil, fmt.Errorf("failed to scan conversation member: %w", err)
+		}
+		members = append(members, peerID)
+	}
+	return members, rows.Err()
+}
+
+// touchMembers records a local change to a group's member list. The
+// version only moves forward, even if the clock went back.
+func touchMembers(tx *sql.Tx, conversationID string, now time.Time) error {
+	_, err := tx.Exec(`UPDATE conversations SET members_changed_at = MAX(?, members_changed_at + 1) WHERE id = ?`,
+		now.UnixNano(), conversationID)
+	if err != nil {
+		return fmt.Errorf("failed to save member list version: %w", err)
+	}
+	return nil
+}
+
+// UpdateConversationSettings changes a conversation's local preferences
+func (d *Database) UpdateConversationSettings(conversationID string, settings ConversationSettings) error {
+	query := `
+		UPDATE conversations
+		SET title = ?, muted = ?, archived = ?
+		WHERE id = ?
+	`
+
+	result, err := d.exec(query, strings.TrimSpace(settings.Title), settings.Muted, settings.Archived, conversationID)
+	if err != nil {
+		return fmt.Errorf("failed to update conversation: %w", err)
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return fmt.Errorf("conversation %s not found", conversationID)
+	}
+
+	return nil
+}
+
+// GetConversation retrieves a single conversation with its members
+func (d *Database) GetConversation(conversationID string) (Conversation, error) {
+	conversations, err := d.queryConversations(`WHERE c.id = ?`, conversationID)
+	if err != nil {
+		return Conversation{}, err
+	}
+	if len(conversations) == 0 {
+		return Conversation{}, fmt.Errorf("conversation %s not found", conversationID)
+	}
+
+	return conversations[0], nil
+}
+
+// GetConversations retrieves all conversations, most recently active first
+func (d *Database) GetConversations() ([]Conversation, error) {
+	return d.queryConversations("")
+}
+
+// queryConversations loads conversations matching a WHERE clause
+func (d *Database) queryConversations(where string, args ...interface{}) ([]Conversation, error) {
+	query := `
+		SELECT c.id, c.kind, c.title, c.created_at, c.last_activity, c.muted, c.archived, c.unread_count,
+			c.retention_days, c.retention_messages, c.legal_hold, c.members_changed_at
+		FROM conversations c
+		` + where + `
+		ORDER BY c.last_activity DESC
+	`
+
+	rows, err := d.conn().db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query conversations: %w", err)
+	}
+	defer rows.Close()
+
+	var conversations []Conversation
+	index := make(map[string]int)
+	for rows.Next() {
+		var c Conversation
+		var membersChangedAt int64
+		err := rows.Scan(&c.ID, &c.Kind, &c.Title, &c.CreatedAt, &c.LastActivity, &c.Muted, &c.Archived, &c.UnreadCount,
+			&c.Retention.Days, &c.Retention.Messages, &c.LegalHold, &membersChangedAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan conversation: %w", err)
+		}
+		if membersChangedAt > 0 {
+			c.MembersChangedAt = time.Unix(0, membersChangedAt).UTC()
+		}
+		c.Members = []string{}
+		index[c.ID] = len(conversations)
+		conversations = append(conversations, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	if len(conversations) == 0 {
+		return conversations, nil
+	}
+
+	memberQuery := `SELECT conversation_id, peer_id FROM conversation_members ORDER BY joined_at`
+	var memberArgs []interface{}
+	if len(conversations) == 1 {
+		memberQuery = `SELECT conversation_id, peer_id FROM conversation_members WHERE conversation_id = ? ORDER BY joined_at`
+		memberArgs = append(memberArgs, conversations[0].ID)
+	}
+
+	memberRows, err := d.conn().db.Query(memberQuery, memberArgs...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query conversation members: %w", err)
+	}
+	defer memberRows.Close()
+
+	for memberRows.Next() {
+		var conversationID, peerID string
+		if err := memberRows.Scan(&conversationID, &peerID); err != nil {
+			return nil, fmt.Errorf("failed to scan conversation member: %w", err)
+		}
+		if i, ok := index[conversationID]; ok {
+			conversations[i].Members = append(conversations[i].Members, peerID)
+		}
+	}
+
+	return conversations, memberRows.Err()
+}
@@ -0,0 +1,195 @@
+package database
+
+import (
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+// groupMembers returns a group's members in order
+func groupMembers(t *testing.T, d *Database, conversationID string) []string {
+	t.Helper()
+
+	c, err := d.GetConversation(conversationID)
+	if err != nil {
+		t.Fatalf("failed to load group: %v", err)
+	}
+	members := slices.Clone(c.Members)
+	slices.Sort(members)
+	return members
+}
+
+func TestApplyGroupMembers(t *testing.T) {
+	base := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	type update struct {
+		sender  string
+		members []string
+		at      time.Time
+		applied bool
+	}
+	tests := []struct {
+		name    string
+		updates []update
+		want    []string
+	}{
+		{
+			name:    "new group",
+			updates: []update{{"a", []string{"a", "b"}, base, true}},
+			want:    []string{"a", "b"},
+		},
+		{
+			name: "newer list from a member removes",
+			updates: []update{
+				{"a", []string{"a", "b", "c"}, base, true},
+				{"b", []string{"a", "b"}, base.Add(time.Minute), true},
+			},
+			want: []string{"a", "b"},
+		},
+		{
+			name: "older list can't undo a removal",
+			updates: []update{
+				{"a", []string{"a", "b"}, base.Add(time.Minute), true},
+				{"a", []string{"a", "b", "c"}, base, false},
+			},
+			want: []string{"a", "b"},
+		},
+		{
+			name: "same version is ignored",
+			updates: []update{
+				{"a", []string{"a", "b"}, base, true},
+				{"a", []string{"a", "b", "c"}, base, false},
+			},
+			want: []string{"a", "b"},
+		},
+		{
+			name: "outsider can't add itself",
+			updates: []update{
+				{"a", []string{"a", "b"}, base, true},
+				{"x", []string{"a", "b", "x"}, base.Add(time.Minute), false},
+			},
+			want: []string{"a", "b"},
+		},
+		{
+			name: "removed member can't rejoin",
+			updates: []update{
+				{"a", []string{"a", "b", "c"}, base, true},
+				{"a", []string{"a", "b"}, base.Add(time.Minute), true},
+				{"c", []string{"a", "b", "c"}, base.Add(time.Hour), false},
+			},
+			want: []string{"a", "b"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := newTestDatabase(t)
+			conversationID := NewGroupConversationID()
+			for i, u := range tt.updates {
+				applied, err := d.ApplyGroupMembers(conversationID, "team", u.sender, u.members, u.at)
+				if err != nil {
+					t.Fatalf("update %d: %v", i, err)
+				}
+				if applied != u.applied {
+					t.Fatalf("update %d: got applied %v, want %v", i, applied, u.applied)
+				}
+			}
+			if got := groupMembers(t, d, conversationID); !slices.Equal(got, tt.want) {
+				t.Fatalf("got members %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLocalMemberChangesMoveVersionForward(t *testing.T) {
+	d := newTestDatabase(t)
+	group, err := d.CreateGroupConversation("team", []string{"a", "b"})
+	if err != nil {
+		t.Fatalf("failed to create group: %v", err)
+	}
+
+	previous := group.MembersChangedAt
+	for _, change := range []func() (Conversation, error){
+		func() (Conversation, error) { return d.AddConversationMembers(group.ID, "team", []string{"c"}) },
+		func() (Conversation, error) { return d.RemoveConversationMember(group.ID, "a") },
+	} {
+		c, err := change()
+		if err != nil {
+			t.Fatalf("failed to change members: %v", err)
+		}
+		if !c.MembersChangedAt.After(previous) {
+			t.Fatalf("version %v didn't move past %v", c.MembersChangedAt, previous)
+		}
+		previous = c.MembersChangedAt
+	}
+
+	// A peer's list from before our change is stale
+	applied, err := d.ApplyGroupMembers(group.ID, "team", "b", []string{"a", "b"}, previous.Add(-time.Nanosecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if applied {
+		t.Fatal("stale list replaced a local change")
+	}
+}
+
+func TestUnversionedGroupOnlyIntroducesGroups(t *testing.T) {
+	d := newTestDatabase(t)
+	conversationID := NewGroupConversationID()
+
+	if err := d.SaveGroupConversation(conversationID, "team", []string{"a", "b"}); err != nil {
+		t.Fatalf("failed to save group: %v", err)
+	}
+	if got := groupMembers(t, d, conversationID); !slices.Equal(got, []string{"a", "b"}) {
+		t.Fatalf("got members %v, want the announced list", got)
+	}
+
+	// Any peer could send this, so a known group's list is kept
+	if err := d.SaveGroupConversation(conversationID, "team", []string{"a", "b", "x"}); err != nil {
+		t.Fatalf("failed to save group: %v", err)
+	}
+	if got := groupMembers(t, d, conversationID); !slices.Equal(got, []string{"a", "b"}) {
+		t.Fatalf("got members %v, want the list unchanged", got)
+	}
+}
+
+func TestInsertMemberMessage(t *testing.T) {
+	d := newTestDatabase(t)
+	group, err := d.CreateGroupConversation("team", []string{"a", "b"})
+	if err != nil {
+		t.Fatalf("failed to create group: %v", err)
+	}
+	if _, err := d.RemoveConversationMember(group.ID, "b"); err != nil {
+		t.Fatalf("failed to remove member: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		conv   string
+		sender string
+		want   error
+	}{
+		{"member", group.ID, "a", nil},
+		{"outsider", group.ID, "x", ErrNotMember},
+		{"removed member", group.ID, "b", ErrNotMember},
+		{"broadcast", BroadcastConversationID, "x", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := d.InsertMemberMessage(Message{ConversationID: tt.conv, PeerID: tt.sender, SenderID: tt.sender, Content: "hi"})
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("got %v, want %v", err, tt.want)
+			}
+		})
+	}
+
+	page, err := d.GetMessagesPage(group.ID, HistoryCursor{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Messages) != 1 || page.Messages[0].SenderID != "a" {
+		t.Fatalf("got %+v, want only the member's message", page.Messages)
+	}
+}
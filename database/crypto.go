@@ -0,0 +1,403 @@
+package database
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+)
+
+const (
+	// kdfIterations is the PBKDF2 work factor for new or re-keyed stores
+	kdfIterations = 600000
+	// sealedPrefix marks a column value as ciphertext
+	sealedPrefix = "enc1:"
+	// keyCheckValue is sealed into meta so a passphrase can be verified
+	keyCheckValue = "lanvochat-key-check"
+)
+
+// sealedColumns lists the columns encrypted at rest by table. Each table
+// must have an integer id column.
+var sealedColumns = map[string][]string{
+	"messages":          {"content"},
+	"message_revisions": {"content"},
+	"drafts":            {"content"},
+	"peers":             {"nickname", "notes"},
+}
+
+var (
+	// ErrLocked is returned when encrypted content is accessed before Unlock
+	ErrLocked = errors.New("database is locked")
+	// ErrWrongPassphrase is returned when a passphrase doesn't open the store
+	ErrWrongPassphrase = errors.New("incorrect passphrase")
+)
+
+// IsEncrypted reports whether message content is encrypted at rest
+func (d *Database) IsEncrypted() bool {
+	d.keyMu.RLock()
+	defer d.keyMu.RUnlock()
+	return d.encrypted
+}
+
+// IsLocked reports whether the store is encrypted and not yet unlocked
+func (d *Database) IsLocked() bool {
+	d.keyMu.RLock()
+	defer d.keyMu.RUnlock()
+	return d.encrypted && d.aead == nil
+}
+
+// Unlock derives the content key from the passphrase and verifies it
+func (d *Database) Unlock(passphrase string) error {
+	d.keyMu.Lock()
+	defer d.keyMu.Unlock()
+
+	if !d.encrypted {
+		return nil
+	}
+
+	salt, iterations, check, err := d.loadKeyParams(d.conn().db)
+	if err != nil {
+		return err
+	}
+
+	aead, err := deriveAEAD(passphrase, salt, iterations)
+	if err != nil {
+		return err
+	}
+	if plain, err := openWith(aead, check); err != nil || string(plain) != keyCheckValue {
+		return ErrWrongPassphrase
+	}
+
+	d.aead = aead
+	return nil
+}
+
+// EnableEncryption encrypts the store with a passphrase, migrating any
+// existing plaintext messages in place
+func (d *Database) EnableEncryption(passphrase string) error {
+	if d.IsEncrypted() {
+		return fmt.Errorf("database is already encrypted")
+	}
+
+	if err := d.rekey(nil, passphrase); err != nil {
+		return err
+	}
+
+	d.purgeFreePages()
+	return nil
+}
+
+// ChangePassphrase re-encrypts every message under a key derived from
+// the new passphrase
+func (d *Database) ChangePassphrase(oldPassphrase, newPassphrase string) error {
+	if !d.IsEncrypted() {
+		return fmt.Errorf("database is not encrypted")
+	}
+
+	salt, iterations, check, err := d.loadKeyParams(d.conn().db)
+	if err != nil {
+		return err
+	}
+	oldAEAD, err := deriveAEAD(oldPassphrase, salt, iterations)
+	if err != nil {
+		return err
+	}
+	if plain, err := openWith(oldAEAD, check); err != nil || string(plain) != keyCheckValue {
+		return ErrWrongPassphrase
+	}
+
+	if err := d.rekey(oldAEAD, newPassphrase); err != nil {
+		return err
+	}
+
+	d.purgeFreePages()
+	return nil
+}
+
+// rekey re-encrypts all content under a fresh salt and passphrase in one
+// transaction. oldAEAD is nil when migrating a plaintext store. The write
+// ends its batch and the new key is installed before any later write runs,
+// so nothing is sealed under a retired key.
+func (d *Database) rekey(oldAEAD cipher.AEAD, passphrase string) error {
+	if len(passphrase) < 8 {
+		return fmt.Errorf("passphrase must be at least 8 characters")
+	}
+
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return fmt.Errorf("failed to generate salt: %w", err)
+	}
+	newAEAD, err := deriveAEAD(passphrase, salt, kdfIterations)
+	if err != nil {
+		return err
+	}
+
+	check, err := sealWith(newAEAD, []byte(keyCheckValue))
+	if err != nil {
+		return fmt.Errorf("failed to seal key check: %w", err)
+	}
+	params := map[string]string{
+		"crypto.salt":       hex.EncodeToString(salt),
+		"crypto.iterations": strconv.Itoa(kdfIterations),
+		"crypto.check":      check,
+	}
+
+	err = d.conn().writer.submit(writeJob{
+		fn: func(tx *sql.Tx) error {
+			// Another re-key may have committed while this one was queued
+			if d.IsEncrypted() != (oldAEAD != nil) {
+				return fmt.Errorf("encryption state changed during re-key")
+			}
+
+			// The search index holds plaintext, so encrypted stores search by
+			// scanning; it goes in the same commit as the re-key
+			if _, err := tx.Exec(`DROP TABLE IF EXISTS messages_fts`); err != nil {
+				return fmt.Errorf("failed to drop search index: %w", err)
+			}
+
+			// Edit history and drafts hold message content, so they are
+			// re-keyed too, as are the notes kept about contacts
+			for table, columns := range sealedColumns {
+				if err := rekeyTable(tx, table, columns, oldAEAD, newAEAD); err != nil {
+					return err
+				}
+			}
+			// The scheduled backup key is sealed like content
+			if err := rekeyMeta(tx, "backup.key", oldAEAD, newAEAD); err != nil {
+				return err
+			}
+			for key, value := range params {
+				if err := setMeta(tx, key, value); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		onCommit: func() {
+			d.keyMu.Lock()
+			d.aead = newAEAD
+			d.encrypted = true
+			d.keyMu.Unlock()
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to re-key: %w", err)
+	}
+	return nil
+}
+
+// rekeyTable re-encrypts the given columns of every row in a table
+func rekeyTable(tx *sql.Tx, table string, columns []string, oldAEAD, newAEAD cipher.AEAD) error {
+	rows, err := tx.Query(fmt.Sprintf(`SELECT id, %s FROM %s`, strings.Join(columns, ", "), table))
+	if err != nil {
+		return fmt.Errorf("failed to query %s: %w", table, err)
+	}
+
+	values := make(map[int64][]string)
+	for rows.Next() {
+		var id int64
+		row := make([]string, len(columns))
+		dest := []interface{}{&id}
+		for i := range row {
+			dest = append(dest, &row[i])
+		}
+		if err := rows.Scan(dest...); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan %s row: %w", table, err)
+		}
+		values[id] = row
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to read %s: %w", table, err)
+	}
+
+	update := fmt.Sprintf(`UPDATE %s SET %s = ? WHERE id = ?`, table, strings.Join(columns, " = ?, "))
+	for id, row := range values {
+		args := make([]interface{}, 0, len(row)+1)
+		for _, value := range row {
+			sealed, err := reseal(value, oldAEAD, newAEAD)
+			if err != nil {
+				return fmt.Errorf("failed to re-key %s row %d: %w", table, id, err)
+			}
+			args = append(args, sealed)
+		}
+		if _, err := tx.Exec(update, append(args, id)...); err != nil {
+			return fmt.Errorf("failed to update %s row %d: %w", table, id, err)
+		}
+	}
+
+	return nil
+}
+
+// rekeyMeta re-encrypts a meta value, if it is set
+func rekeyMeta(tx *sql.Tx, key string, oldAEAD, newAEAD cipher.AEAD) error {
+	value, err := getMeta(tx, key)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to load %s: %w", key, err)
+	}
+
+	sealed, err := reseal(value, oldAEAD, newAEAD)
+	if err != nil {
+		return fmt.Errorf("failed to re-key %s: %w", key, err)
+	}
+	return setMeta(tx, key, sealed)
+}
+
+// reseal decrypts a value with oldAEAD, if it is sealed, and seals it
+// with newAEAD. Without oldAEAD the store is plaintext, so the value is
+// sealed as is even if it happens to look sealed.
+func reseal(value string, oldAEAD, newAEAD cipher.AEAD) (string, error) {
+	plain := []byte(value)
+	if oldAEAD != nil && strings.HasPrefix(value, sealedPrefix) {
+		var err error
+		if plain, err = openWith(oldAEAD, value); err != nil {
+			return "", fmt.Errorf("failed to decrypt: %w", err)
+		}
+	}
+	return sealWith(newAEAD, plain)
+}
+
+// purgeFreePages rewrites the file so stale plaintext pages don't linger
+func (d *Database) purgeFreePages() {
+	if _, err := d.conn().writeDB.Exec(`VACUUM`); err != nil {
+		// Not fatal: secure_delete already zeroes freed pages
+		log.Printf("Warning: failed to vacuum after re-key: %v", err)
+	}
+}
+
+// loadEncryptionState detects whether the store was encrypted previously
+func (d *Database) loadEncryptionState() error {
+	_, err := getMeta(d.conn().db, "crypto.check")
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	d.encrypted = true
+	return nil
+}
+
+// loadKeyParams reads the KDF parameters and key check value from meta
+func (d *Database) loadKeyParams(q queryer) ([]byte, int, string, error) {
+	saltHex, err := getMeta(q, "crypto.salt")
+	if err != nil {
+		return nil, 0, "", fmt.Errorf("failed to load key salt: %w", err)
+	}
+	salt, err := hex.DecodeString(saltHex)
+	if err != nil {
+		return nil, 0, "", fmt.Errorf("invalid key salt: %w", err)
+	}
+
+	iterValue, err := getMeta(q, "crypto.iterations")
+	if err != nil {
+		return nil, 0, "", fmt.Errorf("failed to load key iterations: %w", err)
+	}
+	iterations, err := strconv.Atoi(iterValue)
+	if err != nil {
+		return nil, 0, "", fmt.Errorf("invalid key iterations: %w", err)
+	}
+
+	check, err := getMeta(q, "crypto.check")
+	if err != nil {
+		return nil, 0, "", fmt.Errorf("failed to load key check: %w", err)
+	}
+
+	return salt, iterations, check, nil
+}
+
+// sealText encrypts a column value when the store is encrypted
+func (d *Database) sealText(value string) (string, error) {
+	d.keyMu.RLock()
+	defer d.keyMu.RUnlock()
+
+	if !d.encrypted {
+		return value, nil
+	}
+	if d.aead == nil {
+		return "", ErrLocked
+	}
+	return sealWith(d.aead, []byte(value))
+}
+
+// openText decrypts a column value; plaintext values pass through. Only
+// an encrypted store holds ciphertext, so in a plaintext one a value such
+// as a peer's message that merely starts with sealedPrefix is left alone.
+func (d *Database) openText(value string) (string, error) {
+	d.keyMu.RLock()
+	defer d.keyMu.RUnlock()
+
+	if !d.encrypted || !strings.HasPrefix(value, sealedPrefix) {
+		return value, nil
+	}
+	if d.aead == nil {
+		return "", ErrLocked
+	}
+	plain, err := openWith(d.aead, value)
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt content: %w", err)
+	}
+	return string(plain), nil
+}
+
+// deriveKey stretches a passphrase into a 256-bit key
+func deriveKey(passphrase string, salt []byte, iterations int) ([]byte, error) {
+	key, err := pbkdf2.Key(sha256.New, passphrase, salt, iterations, 32)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive key: %w", err)
+	}
+	return key, nil
+}
+
+// deriveAEAD stretches a passphrase into an AES-256-GCM cipher
+func deriveAEAD(passphrase string, salt []byte, iterations int) (cipher.AEAD, error) {
+	key, err := deriveKey(passphrase, salt, iterations)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+// sealWith encrypts plain into the prefixed, base64 encoded column format
+func sealWith(aead cipher.AEAD, plain []byte) (string, error) {
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	sealed := aead.Seal(nonce, nonce, plain, nil)
+	return sealedPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// openWith decrypts a value produced by sealWith
+func openWith(aead cipher.AEAD, value string) ([]byte, error) {
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(value, sealedPrefix))
+	if err != nil {
+		return nil, err
+	}
+	if len(raw) < aead.NonceSize() {
+		return nil, fmt.Errorf("ciphertext too short")
+	}
+
+	nonce, ciphertext := raw[:aead.NonceSize()], raw[aead.NonceSize():]
+	return aead.Open(nil, nonce, ciphertext, nil)
+}
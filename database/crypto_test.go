@@ -0,0 +1,218 @@
+package database
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// seedSealedContent writes a value to every sealed column, returning the
+// row ID of the edited message
+func seedSealedContent(t *testing.T, d *Database) int64 {
+	t.Helper()
+
+	ids := insertMessages(t, d, "peer", time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC), 2)
+	msg, err := d.GetMessage(ids[0])
+	if err != nil {
+		t.Fatalf("failed to load message: %v", err)
+	}
+	if err := d.EditMessage(msg.MessageID, "peer", "edited", msg.Timestamp.Add(time.Minute), 0); err != nil {
+		t.Fatalf("failed to edit message: %v", err)
+	}
+	if err := d.SaveDraft(DirectConversationID("peer"), "unsent draft"); err != nil {
+		t.Fatalf("failed to save draft: %v", err)
+	}
+	if err := d.SavePeer("peer", "Peer", "192.0.2.1"); err != nil {
+		t.Fatalf("failed to save peer: %v", err)
+	}
+	if err := d.UpdateContact("peer", ContactDetails{Nickname: "Pal", Notes: "met at the office"}); err != nil {
+		t.Fatalf("failed to update contact: %v", err)
+	}
+	return ids[0]
+}
+
+// sealedValues returns the raw value of every non-empty sealed column
+func sealedValues(t *testing.T, d *Database) []string {
+	t.Helper()
+
+	var values []string
+	for table, columns := range sealedColumns {
+		for _, column := range columns {
+			rows, err := d.conn().db.Query(`SELECT ` + column + ` FROM ` + table + ` WHERE COALESCE(` + column + `, '') != ''`)
+			if err != nil {
+				t.Fatalf("failed to read %s.%s: %v", table, column, err)
+			}
+			found := false
+			for rows.Next() {
+				var value string
+				if err := rows.Scan(&value); err != nil {
+					t.Fatal(err)
+				}
+				values = append(values, value)
+				found = true
+			}
+			rows.Close()
+			if !found {
+				t.Fatalf("no %s.%s values seeded", table, column)
+			}
+		}
+	}
+	return values
+}
+
+// assertReadable checks that sealed content reads back as plaintext
+func assertReadable(t *testing.T, d *Database, editedID int64) {
+	t.Helper()
+
+	msg, err := d.GetMessage(editedID)
+	if err != nil {
+		t.Fatalf("failed to load message: %v", err)
+	}
+	if msg.Content != "edited" {
+		t.Errorf("got message %q, want %q", msg.Content, "edited")
+	}
+
+	revisions, err := d.GetMessageRevisions(editedID)
+	if err != nil {
+		t.Fatalf("failed to load revisions: %v", err)
+	}
+	if len(revisions) != 1 || revisions[0].Content != "message a" {
+		t.Errorf("got revisions %+v, want the original content", revisions)
+	}
+
+	draft, err := d.GetDraft(DirectConversationID("peer"))
+	if err != nil {
+		t.Fatalf("failed to load draft: %v", err)
+	}
+	if draft.Content != "unsent draft" {
+		t.Errorf("got draft %q, want %q", draft.Content, "unsent draft")
+	}
+
+	peers, err := d.GetPeers()
+	if err != nil {
+		t.Fatalf("failed to load peers: %v", err)
+	}
+	if len(peers) != 1 || peers[0].Nickname != "Pal" || peers[0].Notes != "met at the office" {
+		t.Errorf("got peers %+v, want the contact details", peers)
+	}
+}
+
+func TestEncryptionRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "crypto.db")
+	d, err := NewDatabase(path)
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	editedID := seedSealedContent(t, d)
+
+	var previous []string
+	steps := []struct {
+		name string
+		fn   func() error
+	}{
+		{"enable", func() error { return d.EnableEncryption("first passphrase") }},
+		{"re-key", func() error { return d.ChangePassphrase("first passphrase", "second passphrase") }},
+	}
+	for _, step := range steps {
+		if err := step.fn(); err != nil {
+			t.Fatalf("failed to %s: %v", step.name, err)
+		}
+
+		values := sealedValues(t, d)
+		for _, value := range values {
+			if !strings.HasPrefix(value, sealedPrefix) {
+				t.Fatalf("after %s, found plaintext %q", step.name, value)
+			}
+			for _, old := range previous {
+				if value == old {
+					t.Fatalf("after %s, %q is still sealed under the old key", step.name, value)
+				}
+			}
+		}
+		previous = values
+		assertReadable(t, d, editedID)
+	}
+
+	if err := d.ChangePassphrase("first passphrase", "third passphrase"); !errors.Is(err, ErrWrongPassphrase) {
+		t.Fatalf("re-key with a retired passphrase: got %v, want %v", err, ErrWrongPassphrase)
+	}
+	if err := d.Close(); err != nil {
+		t.Fatalf("failed to close database: %v", err)
+	}
+
+	// A reopened store stays locked until the current passphrase is given
+	d, err = NewDatabase(path)
+	if err != nil {
+		t.Fatalf("failed to reopen database: %v", err)
+	}
+	defer d.Close()
+	if !d.IsLocked() {
+		t.Fatal("reopened database is not locked")
+	}
+	if _, err := d.GetMessagesPage(DirectConversationID("peer"), HistoryCursor{}); !errors.Is(err, ErrLocked) {
+		t.Fatalf("read while locked: got %v, want %v", err, ErrLocked)
+	}
+
+	for _, tt := range []struct {
+		passphrase string
+		want       error
+	}{
+		{"first passphrase", ErrWrongPassphrase},
+		{"", ErrWrongPassphrase},
+		{"second passphrase", nil},
+	} {
+		if err := d.Unlock(tt.passphrase); !errors.Is(err, tt.want) {
+			t.Fatalf("unlock with %q: got %v, want %v", tt.passphrase, err, tt.want)
+		}
+	}
+	assertReadable(t, d, editedID)
+}
+
+func TestPlaintextLookingSealed(t *testing.T) {
+	// Peers choose message content and names, so a plaintext store may
+	// hold values that merely start with the sealed prefix
+	const content = sealedPrefix + "x"
+
+	d := newTestDatabase(t)
+	id, err := d.InsertMessage(Message{PeerID: "peer", SenderID: "peer", Content: content})
+	if err != nil {
+		t.Fatalf("failed to insert message: %v", err)
+	}
+	if err := d.SavePeer("peer", "Peer", "192.0.2.1"); err != nil {
+		t.Fatalf("failed to save peer: %v", err)
+	}
+	if err := d.UpdateContact("peer", ContactDetails{Nickname: content, Notes: content}); err != nil {
+		t.Fatalf("failed to update contact: %v", err)
+	}
+
+	assertContent := func(when string) {
+		t.Helper()
+
+		messages, err := d.GetMessageHistory("peer", 10)
+		if err != nil {
+			t.Fatalf("%s: failed to read history: %v", when, err)
+		}
+		if len(messages) != 1 || messages[0].ID != id || messages[0].Content != content {
+			t.Fatalf("%s: got %+v, want the message as sent", when, messages)
+		}
+		peers, err := d.GetPeers()
+		if err != nil {
+			t.Fatalf("%s: failed to load peers: %v", when, err)
+		}
+		if len(peers) != 1 || peers[0].Nickname != content || peers[0].Notes != content {
+			t.Fatalf("%s: got %+v, want the contact details as saved", when, peers)
+		}
+	}
+
+	assertContent("plaintext")
+	if err := d.EnableEncryption("first passphrase"); err != nil {
+		t.Fatalf("failed to enable encryption: %v", err)
+	}
+	assertContent("encrypted")
+	if err := d.ChangePassphrase("first passphrase", "second passphrase"); err != nil {
+		t.Fatalf("failed to re-key: %v", err)
+	}
+	assertContent("re-keyed")
+}
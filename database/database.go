@@ -1,225 +1,464 @@
 package database
 
 import (
+	"crypto/cipher"
+	"crypto/rand"
 	"database/sql"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	_ "github.com/mattn/go-sqlite3"
 )
 
-// Database represents the SQLite database connection
+// messageColumns is the column list scanned by scanMessage
+const messageColumns = `id, COALESCE(message_id, ''), conversation_id, peer_id, sender_id, content, timestamp, is_read, edited_at, deleted_at, COALESCE(parent_id, '')`
+
+// aliasedMessageColumns is messageColumns for queries joining messages as m
+const aliasedMessageColumns = `m.id, COALESCE(m.message_id, ''), m.conversation_id, m.peer_id, m.sender_id, m.content, m.timestamp, m.is_read, m.edited_at, m.deleted_at, COALESCE(m.parent_id, '')`
+
+// readPoolSize is how many connections may read concurrently
+const readPoolSize = 4
+
+// ErrDuplicateMessage is returned when a message ID has already been stored
+var ErrDuplicateMessage = errors.New("message already stored")
+
+// Database represents the SQLite database. Reads go through a pool of
+// read-only connections while every write is funnelled through a single
+// writer connection, so concurrent writers queue instead of failing with
+// "database is locked".
 type Database struct {
-	db *sql.DB
+	handles atomic.Pointer[handles]
+	path    string
+
+	// Content encryption state; aead is nil until Unlock succeeds
+	keyMu     sync.RWMutex
+	encrypted bool
+	aead      cipher.AEAD
+
+	// ftsAvailable is set when SQLite was built with FTS5
+	ftsAvailable bool
 }
 
-// Message represents a chat message
-type Message struct {
-	ID        int64     `json:"id"`
-	PeerID    string    `json:"peer_id"`
-	SenderID  string    `json:"sender_id"`
-	Content   string    `json:"content"`
-	Timestamp time.Time `json:"timestamp"`
-	IsRead    bool      `json:"is_read"`
+// handles are the connections and prepared statements of an open
+// database file. Restore swaps them as a whole for those of the restored
+// file; users still holding the old handles get ErrClosed or a closed pool
+// error rather than a half-replaced set.
+type handles struct {
+	db      *sql.DB
+	writeDB *sql.DB
+	writer  *writer
+	stmts   *statements
+}
+
+// conn returns the handles currently in use
+func (d *Database) conn() *handles {
+	return d.handles.Load()
 }
 
-// Peer represents a chat peer/contact
-type Peer struct {
-	ID        int64     `json:"id"`
-	PeerID    string    `json:"peer_id"`
-	Name      string    `json:"name"`
-	IPAddress string    `json:"ip_address"`
-	LastSeen  time.Time `json:"last_seen"`
-	IsOnline  bool      `json:"is_online"`
-	CreatedAt time.Time `json:"created_at"`
+// queryer is satisfied by both *sql.DB and *sql.Tx
+type queryer interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// execer is satisfied by *sql.Tx and by execFunc(d.exec)
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// Message represents a chat message. PeerID is the other side of a
+// direct chat and empty for group and broadcast messages. Deleted messages
+// remain as tombstones with DeletedAt set and no content. ParentID is the
+// message ID of the message a reply answers.
+type Message struct {
+	ID             int64      `json:"id"`
+	MessageID      string     `json:"message_id"`
+	ConversationID string     `json:"conversation_id"`
+	PeerID         string     `json:"peer_id"`
+	SenderID       string     `json:"sender_id"`
+	Content        string     `json:"content"`
+	Timestamp      time.Time  `json:"timestamp"`
+	IsRead         bool       `json:"is_read"`
+	EditedAt       *time.Time `json:"edited_at,omitempty"`
+	DeletedAt      *time.Time `json:"deleted_at,omitempty"`
+	ParentID       string     `json:"parent_id,omitempty"`
+
+	// Reactions, the quoted parent of a reply and whether the message is
+	// pinned or starred are filled in for history, pages and threads, not
+	// for every query
+	Reactions []ReactionCount `json:"reactions,omitempty"`
+	Quote     *Quote          `json:"quote,omitempty"`
+	Pinned    bool            `json:"pinned,omitempty"`
+	Starred   bool            `json:"starred,omitempty"`
 }
 
 // NewDatabase creates a new database connection and initializes the schema
 func NewDatabase(dbPath string) (*Database, error) {
-	db, err := sql.Open("sqlite3", dbPath)
+	database := &Database{path: dbPath}
+	if err := database.open(); err != nil {
+		return nil, err
+	}
+
+	return database, nil
+}
+
+// open connects to the database file, brings its schema up to date and
+// loads its encryption and search state. The file is prepared on a
+// separate Database so nothing else can use it half initialized; its
+// handles and state are then installed here, leaving the store locked.
+func (d *Database) open() error {
+	h, err := openHandles(d.path)
+	if err != nil {
+		return err
+	}
+
+	fresh := &Database{path: d.path}
+	fresh.handles.Store(h)
+	if err := fresh.initialize(); err != nil {
+		h.close()
+		return err
+	}
+
+	d.keyMu.Lock()
+	d.encrypted = fresh.encrypted
+	d.aead = nil
+	d.ftsAvailable = fresh.ftsAvailable
+	d.keyMu.Unlock()
+
+	d.handles.Store(h)
+	return nil
+}
+
+// openHandles connects to a database file and starts its writer
+func openHandles(path string) (*handles, error) {
+	// secure_delete zeroes freed pages so deleted or re-keyed plaintext
+	// doesn't linger on disk; incremental auto-vacuum lets pruning hand
+	// freed pages back to the filesystem. WAL lets readers carry on while
+	// the writer commits, and busy_timeout covers checkpoints.
+	options := "_secure_delete=on&_auto_vacuum=incremental&_journal_mode=WAL&_synchronous=NORMAL&_busy_timeout=5000"
+
+	writeDB, err := sql.Open("sqlite3", path+"?"+options)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open database: %w", err)
 	}
+	writeDB.SetMaxOpenConns(1)
 
 	// Test the connection
-	if err := db.Ping(); err != nil {
+	if err := writeDB.Ping(); err != nil {
+		writeDB.Close()
 		return nil, fmt.Errorf("failed to ping database: %w", err)
 	}
 
-	database := &Database{db: db}
-
-	// Initialize schema
-	if err := database.initSchema(); err != nil {
-		return nil, fmt.Errorf("failed to initialize schema: %w", err)
+	// An in-memory database exists only on its own connection, so it is
+	// read through the writer connection too
+	readDB := writeDB
+	if path != "" && path != ":memory:" {
+		if readDB, err = sql.Open("sqlite3", path+"?"+options+"&_query_only=on"); err != nil {
+			writeDB.Close()
+			return nil, fmt.Errorf("failed to open database: %w", err)
+		}
+		readDB.SetMaxOpenConns(readPoolSize)
+		readDB.SetMaxIdleConns(readPoolSize)
 	}
 
-	return database, nil
+	return &handles{db: readDB, writeDB: writeDB, writer: newWriter(writeDB)}, nil
 }
 
-// initSchema creates the necessary tables
-func (d *Database) initSchema() error {
-	schema := `
-	CREATE TABLE IF NOT EXISTS messages (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		peer_id TEXT NOT NULL,
-		sender_id TEXT NOT NULL,
-		content TEXT NOT NULL,
-		timestamp DATETIME DEFAULT CURRENT_TIMESTAMP,
-		is_read BOOLEAN DEFAULT 0,
-		FOREIGN KEY (peer_id) REFERENCES peers(peer_id)
-	);
-
-	CREATE INDEX IF NOT EXISTS idx_messages_peer_id ON messages(peer_id);
-	CREATE INDEX IF NOT EXISTS idx_messages_timestamp ON messages(timestamp);
-
-	CREATE TABLE IF NOT EXISTS peers (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		peer_id TEXT UNIQUE NOT NULL,
-		name TEXT NOT NULL,
-		ip_address TEXT NOT NULL,
-		last_seen DATETIME DEFAULT CURRENT_TIMESTAMP,
-		is_online BOOLEAN DEFAULT 0,
-		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
-	);
-
-	CREATE INDEX IF NOT EXISTS idx_peers_peer_id ON peers(peer_id);
-	CREATE INDEX IF NOT EXISTS idx_peers_is_online ON peers(is_online);
-	`
+// initialize prepares a freshly opened database for use
+func (d *Database) initialize() error {
+	// Databases created before auto-vacuum need one full rewrite to switch
+	if err := d.enableIncrementalVacuum(); err != nil {
+		return fmt.Errorf("failed to enable incremental vacuum: %w", err)
+	}
 
-	_, err := d.db.Exec(schema)
-	return err
+	// Bring the schema up to date, refusing databases from newer versions
+	if err := d.migrate(); err != nil {
+		return fmt.Errorf("failed to migrate schema: %w", err)
+	}
+
+	stmts, err := prepareStatements(d.conn().writeDB)
+	if err != nil {
+		return err
+	}
+	d.conn().stmts = stmts
+
+	// Encrypted stores start locked until Unlock is called
+	if err := d.loadEncryptionState(); err != nil {
+		return fmt.Errorf("failed to load encryption state: %w", err)
+	}
+
+	// Full-text search is optional; without it search falls back to scanning
+	if err := d.loadSearchState(); err != nil {
+		return fmt.Errorf("failed to load search index state: %w", err)
+	}
+
+	return nil
 }
 
-// SaveMessage saves a new message to the database
+// SaveMessage saves a new message to the direct conversation with a peer.
+// Messages not sent by the peer are ours and therefore already read.
 func (d *Database) SaveMessage(peerID, senderID, content string) error {
-	query := `
-		INSERT INTO messages (peer_id, sender_id, content, timestamp)
-		VALUES (?, ?, ?, ?)
-	`
+	_, err := d.InsertMessage(Message{
+		MessageID:      newMessageID(),
+		ConversationID: DirectConversationID(peerID),
+		PeerID:         peerID,
+		SenderID:       senderID,
+		Content:        content,
+		Timestamp:      time.Now(),
+		IsRead:         senderID != peerID,
+	})
+	return err
+}
 
-	_, err := d.db.Exec(query, peerID, senderID, content, time.Now())
+// InsertMessage stores a message and returns its row ID. Retransmissions
+// of an already stored message ID are ignored and reported as
+// ErrDuplicateMessage so callers can skip side effects.
+func (d *Database) InsertMessage(msg Message) (int64, error) {
+	var id int64
+	err := d.withTx(func(tx *sql.Tx) error {
+		var err error
+		id, err = d.insertMessage(tx, msg)
+		return err
+	})
+	return id, err
+}
+
+// InsertMemberMessage stores a message a peer sent to a conversation,
+// refusing it with ErrNotMember unless the sender belongs to it, so peers
+// outside a group or removed from it can't post there
+func (d *Database) InsertMemberMessage(msg Message) (int64, error) {
+	var id int64
+	err := d.withTx(func(tx *sql.Tx) error {
+		member, err := isMember(tx, msg.ConversationID, msg.SenderID)
+		if err != nil {
+			return err
+		}
+		if !member {
+			return ErrNotMember
+		}
+		id, err = d.insertMessage(tx, msg)
+		return err
+	})
+	return id, err
+}
+
+// insertMessage stores a message inside an open transaction
+func (d *Database) insertMessage(tx *sql.Tx, msg Message) (int64, error) {
+	if msg.ConversationID == "" {
+		if msg.PeerID == "" {
+			return 0, fmt.Errorf("message has neither a conversation nor a peer")
+		}
+		msg.ConversationID = DirectConversationID(msg.PeerID)
+	}
+	if msg.MessageID == "" {
+		msg.MessageID = newMessageID()
+	}
+	if msg.Timestamp.IsZero() {
+		msg.Timestamp = time.Now()
+	}
+	// Store UTC so timestamps from peers in other zones sort and filter correctly
+	msg.Timestamp = msg.Timestamp.UTC()
+
+	sealed, err := d.sealText(msg.Content)
 	if err != nil {
-		return fmt.Errorf("failed to save message: %w", err)
+		return 0, fmt.Errorf("failed to encrypt message: %w", err)
 	}
 
-	return nil
+	result, err := tx.Stmt(d.conn().stmts.insertMessage).Exec(msg.MessageID, msg.ConversationID, msg.PeerID, msg.SenderID, sealed, msg.Timestamp, msg.IsRead, msg.ParentID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to save message: %w", err)
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to save message: %w", err)
+	}
+	if affected == 0 {
+		return 0, ErrDuplicateMessage
+	}
+
+	if err := d.ensureConversation(tx, msg.ConversationID, msg.Timestamp); err != nil {
+		return 0, err
+	}
+	if !msg.IsRead {
+		_, err := tx.Stmt(d.conn().stmts.incrementUnread).Exec(msg.ConversationID)
+		if err != nil {
+			return 0, fmt.Errorf("failed to update unread count: %w", err)
+		}
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("failed to save message: %w", err)
+	}
+	if err := d.indexMessage(tx, id, msg.Content); err != nil {
+		return 0, err
+	}
+	return id, nil
 }
 
-// GetMessageHistory retrieves message history for a specific peer
+// GetMessageHistory retrieves the direct chat history with a specific peer
 func (d *Database) GetMessageHistory(peerID string, limit int) ([]Message, error) {
 	if limit <= 0 {
 		limit = 50 // default limit
 	}
+	if d.IsLocked() {
+		return nil, ErrLocked
+	}
 
 	query := `
-		SELECT id, peer_id, sender_id, content, timestamp, is_read
+		SELECT ` + messageColumns + `
 		FROM messages
-		WHERE peer_id = ?
-		ORDER BY timestamp DESC
+		WHERE conversation_id = ?
+		ORDER BY timestamp DESC, id DESC
 		LIMIT ?
 	`
 
-	rows, err := d.db.Query(query, peerID, limit)
+	rows, err := d.conn().db.Query(query, DirectConversationID(peerID), limit)
 	if err != nil {
 		return nil, fmt.Errorf("failed to query messages: %w", err)
 	}
 	defer rows.Close()
 
-	var messages []Message
-	for rows.Next() {
-		var msg Message
-		err := rows.Scan(&msg.ID, &msg.PeerID, &msg.SenderID, &msg.Content, &msg.Timestamp, &msg.IsRead)
-		if err != nil {
-			return nil, fmt.Errorf("failed to scan message: %w", err)
-		}
-		messages = append(messages, msg)
+	messages, err := d.scanMessages(rows)
+	if err != nil {
+		return nil, err
 	}
-
-	// Reverse to get chronological order
-	for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
-		messages[i], messages[j] = messages[j], messages[i]
+	rows.Close()
+	if err := d.attachDetails(messages); err != nil {
+		return nil, err
 	}
 
+	// Reverse to get chronological order
+	reverseMessages(messages)
 	return messages, nil
 }
 
-// SavePeer saves or updates a peer in the database
-func (d *Database) SavePeer(peerID, name, ipAddress string) error {
-	query := `
-		INSERT INTO peers (peer_id, name, ip_address, last_seen, is_online)
-		VALUES (?, ?, ?, ?, 1)
-		ON CONFLICT(peer_id) DO UPDATE SET
-			name = excluded.name,
-			ip_address = excluded.ip_address,
-			last_seen = excluded.last_seen,
-			is_online = excluded.is_online
-	`
+// GetMessage retrieves a single message by row ID
+func (d *Database) GetMessage(id int64) (Message, error) {
+	if d.IsLocked() {
+		return Message{}, ErrLocked
+	}
 
-	_, err := d.db.Exec(query, peerID, name, ipAddress, time.Now())
+	rows, err := d.conn().db.Query(`SELECT `+messageColumns+` FROM messages WHERE id = ?`, id)
 	if err != nil {
-		return fmt.Errorf("failed to save peer: %w", err)
+		return Message{}, fmt.Errorf("failed to query message: %w", err)
 	}
+	defer rows.Close()
 
-	return nil
-}
-
-// GetPeers retrieves all peers from the database
-func (d *Database) GetPeers() ([]Peer, error) {
-	query := `
-		SELECT id, peer_id, name, ip_address, last_seen, is_online, created_at
-		FROM peers
-		ORDER BY last_seen DESC
-	`
-
-	rows, err := d.db.Query(query)
+	messages, err := d.scanMessages(rows)
 	if err != nil {
-		return nil, fmt.Errorf("failed to query peers: %w", err)
+		return Message{}, err
 	}
-	defer rows.Close()
+	if len(messages) == 0 {
+		return Message{}, ErrMessageNotFound
+	}
+	return messages[0], nil
+}
 
-	var peers []Peer
+// scanMessages reads rows selected with messageColumns, decrypting content
+func (d *Database) scanMessages(rows *sql.Rows) ([]Message, error) {
+	var messages []Message
 	for rows.Next() {
-		var peer Peer
-		err := rows.Scan(&peer.ID, &peer.PeerID, &peer.Name, &peer.IPAddress,
-			&peer.LastSeen, &peer.IsOnline, &peer.CreatedAt)
+		msg, err := d.scanMessage(rows)
 		if err != nil {
-			return nil, fmt.Errorf("failed to scan peer: %w", err)
+			return nil, err
 		}
-		peers = append(peers, peer)
+		messages = append(messages, msg)
 	}
 
-	return peers, nil
+	return messages, rows.Err()
 }
 
-// UpdatePeerStatus updates the online status of a peer
-func (d *Database) UpdatePeerStatus(peerID string, isOnline bool) error {
-	query := `
-		UPDATE peers
-		SET is_online = ?, last_seen = ?
-		WHERE peer_id = ?
-	`
+// scanMessage reads one row selected with messageColumns followed by any
+// extra columns, decrypting content
+func (d *Database) scanMessage(rows *sql.Rows, extra ...interface{}) (Message, error) {
+	var msg Message
+	var editedAt, deletedAt sql.NullTime
+	dest := []interface{}{&msg.ID, &msg.MessageID, &msg.ConversationID, &msg.PeerID, &msg.SenderID,
+		&msg.Content, &msg.Timestamp, &msg.IsRead, &editedAt, &deletedAt, &msg.ParentID}
 
-	_, err := d.db.Exec(query, isOnline, time.Now(), peerID)
-	if err != nil {
-		return fmt.Errorf("failed to update peer status: %w", err)
+	if err := rows.Scan(append(dest, extra...)...); err != nil {
+		return Message{}, fmt.Errorf("failed to scan message: %w", err)
+	}
+	if editedAt.Valid {
+		msg.EditedAt = &editedAt.Time
+	}
+	if deletedAt.Valid {
+		msg.DeletedAt = &deletedAt.Time
 	}
 
-	return nil
+	var err error
+	if msg.Content, err = d.openText(msg.Content); err != nil {
+		return Message{}, err
+	}
+	return msg, nil
 }
 
-// MarkMessageAsRead marks a message as read
-func (d *Database) MarkMessageAsRead(messageID int64) error {
-	query := `UPDATE messages SET is_read = 1 WHERE id = ?`
+// reverseMessages reverses a slice of messages in place
+func reverseMessages(messages []Message) {
+	for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
+		messages[i], messages[j] = messages[j], messages[i]
+	}
+}
 
-	_, err := d.db.Exec(query, messageID)
-	if err != nil {
-		return fmt.Errorf("failed to mark message as read: %w", err)
+// newMessageID returns a random identifier for locally created messages
+func newMessageID() string {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		panic(fmt.Sprintf("failed to read random bytes: %v", err))
 	}
+	return hex.EncodeToString(buf)
+}
+
+// getMeta reads a value from the meta key/value table
+func getMeta(q queryer, key string) (string, error) {
+	var value string
+	err := q.QueryRow(`SELECT value FROM meta WHERE key = ?`, key).Scan(&value)
+	return value, err
+}
+
+// setMeta writes a value to the meta key/value table
+func setMeta(e execer, key, value string) error {
+	query := `
+		INSERT INTO meta (key, value) VALUES (?, ?)
+		ON CONFLICT(key) DO UPDATE SET value = excluded.value
+	`
 
+	if _, err := e.Exec(query, key, value); err != nil {
+		return fmt.Errorf("failed to save %s: %w", key, err)
+	}
 	return nil
 }
 
-// Close closes the database connection
+// Close waits for the write in progress, fails any still queued and
+// closes the database connections
 func (d *Database) Close() error {
-	return d.db.Close()
+	return d.close()
+}
+
+// close stops the writer and closes both connection pools
+func (d *Database) close() error {
+	return d.conn().close()
+}
+
+// close stops the writer and closes both connection pools
+func (h *handles) close() error {
+	h.writer.close()
+	if h.stmts != nil {
+		h.stmts.close()
+	}
+
+	var err error
+	if h.db != h.writeDB {
+		err = h.db.Close()
+	}
+	// Closing the last connection checkpoints the WAL into the main file
+	if closeErr := h.writeDB.Close(); closeErr != nil {
+		err = closeErr
+	}
+	return err
 }
@@ -0,0 +1,62 @@
+package database
+
+import (
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	// Migrations and backups log every step
+	log.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+// newTestDatabase opens a fresh database in a temporary directory
+func newTestDatabase(t *testing.T) *Database {
+	t.Helper()
+
+	d, err := NewDatabase(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	t.Cleanup(func() { d.Close() })
+	return d
+}
+
+// insertMessages stores n direct messages from peerID, a second apart
+// from start, returning their row IDs in order
+func insertMessages(t *testing.T, d *Database, peerID string, start time.Time, n int) []int64 {
+	t.Helper()
+
+	ids := make([]int64, 0, n)
+	for i := 0; i < n; i++ {
+		id, err := d.InsertMessage(Message{
+			ConversationID: DirectConversationID(peerID),
+			PeerID:         peerID,
+			SenderID:       peerID,
+			Content:        "message " + string(rune('a'+i%26)),
+			Timestamp:      start.Add(time.Duration(i) * time.Second),
+		})
+		if err != nil {
+			t.Fatalf("failed to insert message %d: %v", i, err)
+		}
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// firstMessage stores a message from "peer" in its direct chat
+func firstMessage(t *testing.T, d *Database) Message {
+	t.Helper()
+
+	id := insertMessages(t, d, "peer", time.Date(2026, 1, 1, 11, 0, 0, 0, time.UTC), 1)[0]
+	msg, err := d.GetMessage(id)
+	if err != nil {
+		t.Fatalf("failed to load message: %v", err)
+	}
+	return msg
+}
@@ -0,0 +1,112 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// maxDraftLength bounds a draft in bytes; nothing longer fits in a frame
+const maxDraftLength = 64 * 1024
+
+// Draft is the unsent message typed into a conversation. Its content is
+// encrypted like messages.
+type Draft struct {
+	ConversationID string    `json:"conversation_id"`
+	Content        string    `json:"content"`
+	UpdatedAt      time.Time `json:"updated_at"`
+}
+
+// SaveDraft stores the draft for a conversation, replacing any earlier
+// one. A blank draft clears it.
+func (d *Database) SaveDraft(conversationID, content string) error {
+	if _, err := conversationKind(conversationID); err != nil {
+		return err
+	}
+	if strings.TrimSpace(content) == "" {
+		return d.ClearDraft(conversationID)
+	}
+	if len(content) > maxDraftLength {
+		return fmt.Errorf("draft is longer than %d bytes", maxDraftLength)
+	}
+
+	return d.withTx(func(tx *sql.Tx) error {
+		// Sealed in the write so a concurrent re-key can't leave it under a retired key
+		sealed, err := d.sealText(content)
+		if err != nil {
+			return fmt.Errorf("failed to encrypt draft: %w", err)
+		}
+
+		_, err = tx.Exec(`
+			INSERT INTO drafts (conversation_id, content, updated_at)
+			VALUES (?, ?, ?)
+			ON CONFLICT(conversation_id) DO UPDATE SET
+				content = excluded.content,
+				updated_at = excluded.updated_at
+		`, conversationID, sealed, time.Now().UTC())
+		if err != nil {
+			return fmt.Errorf("failed to save draft: %w", err)
+		}
+		return nil
+	})
+}
+
+// GetDraft returns the draft for a conversation, with empty content if
+// there is none
+func (d *Database) GetDraft(conversationID string) (Draft, error) {
+	if d.IsLocked() {
+		return Draft{}, ErrLocked
+	}
+
+	draft := Draft{ConversationID: conversationID}
+	err := d.conn().db.QueryRow(`SELECT content, updated_at FROM drafts WHERE conversation_id = ?`, conversationID).
+		Scan(&draft.Content, &draft.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return draft, nil
+	}
+	if err != nil {
+		return Draft{}, fmt.Errorf("failed to load draft: %w", err)
+	}
+
+	if draft.Content, err = d.openText(draft.Content); err != nil {
+		return Draft{}, err
+	}
+	return draft, nil
+}
+
+// GetDrafts returns every saved draft, most recently updated first
+func (d *Database) GetDrafts() ([]Draft, error) {
+	if d.IsLocked() {
+		return nil, ErrLocked
+	}
+
+	rows, err := d.conn().db.Query(`SELECT conversation_id, content, updated_at FROM drafts ORDER BY updated_at DESC`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query drafts: %w", err)
+	}
+	defer rows.Close()
+
+	drafts := []Draft{}
+	for rows.Next() {
+		var draft Draft
+		if err := rows.Scan(&draft.ConversationID, &draft.Content, &draft.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan draft: %w", err)
+		}
+		if draft.Content, err = d.openText(draft.Content); err != nil {
+			return nil, err
+		}
+		drafts = append(drafts, draft)
+	}
+
+	return drafts, rows.Err()
+}
+
+// ClearDraft removes the draft for a conversation, if any
+func (d *Database) ClearDraft(conversationID string) error {
+	if _, err := d.exec(`DELETE FROM drafts WHERE conversation_id = ?`, conversationID); err != nil {
+		return fmt.Errorf("failed to clear draft: %w", err)
+	}
+	return nil
+}
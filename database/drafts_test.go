@@ -0,0 +1,82 @@
+package database
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSaveDraft(t *testing.T) {
+	conversationID := DirectConversationID("peer")
+
+	tests := []struct {
+		name    string
+		saves   []string
+		want    string
+		wantErr bool
+	}{
+		{"new draft", []string{"hello"}, "hello", false},
+		{"replaced", []string{"hello", "hello again"}, "hello again", false},
+		{"blank clears", []string{"hello", " \n "}, "", false},
+		// Only an encrypted store's own values are opened
+		{"looks sealed", []string{sealedPrefix + "x"}, sealedPrefix + "x", false},
+		{"longest allowed", []string{strings.Repeat("a", maxDraftLength)}, strings.Repeat("a", maxDraftLength), false},
+		{"too long", []string{"hello", strings.Repeat("a", maxDraftLength+1)}, "hello", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := newTestDatabase(t)
+			var err error
+			for _, content := range tt.saves {
+				if err = d.SaveDraft(conversationID, content); err != nil {
+					break
+				}
+			}
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %v", err, tt.wantErr)
+			}
+
+			draft, err := d.GetDraft(conversationID)
+			if err != nil {
+				t.Fatalf("failed to load draft: %v", err)
+			}
+			if draft.ConversationID != conversationID || draft.Content != tt.want {
+				t.Fatalf("got draft %q, want %q", draft.Content, tt.want)
+			}
+		})
+	}
+}
+
+func TestSaveDraftUnknownConversation(t *testing.T) {
+	d := newTestDatabase(t)
+	if err := d.SaveDraft("nowhere:1", "hello"); err == nil {
+		t.Fatal("saved a draft for an unknown kind of conversation")
+	}
+}
+
+func TestGetDrafts(t *testing.T) {
+	d := newTestDatabase(t)
+	for _, peerID := range []string{"first", "second", "third"} {
+		if err := d.SaveDraft(DirectConversationID(peerID), "to "+peerID); err != nil {
+			t.Fatalf("failed to save draft: %v", err)
+		}
+	}
+	if err := d.ClearDraft(DirectConversationID("second")); err != nil {
+		t.Fatalf("failed to clear draft: %v", err)
+	}
+	if err := d.EnableEncryption("correct horse battery staple"); err != nil {
+		t.Fatalf("failed to enable encryption: %v", err)
+	}
+
+	drafts, err := d.GetDrafts()
+	if err != nil {
+		t.Fatalf("failed to load drafts: %v", err)
+	}
+	var got []string
+	for _, draft := range drafts {
+		got = append(got, draft.Content)
+	}
+	if len(got) != 2 || got[0] != "to third" || got[1] != "to first" {
+		t.Fatalf("got drafts %q, want the newest first", got)
+	}
+}
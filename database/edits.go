@@ -0,0 +1,223 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultEditWindow applies until the user picks their own edit window
+const defaultEditWindow = 15 * time.Minute
+
+var (
+	// ErrMessageNotFound is returned when a referenced message isn't stored
+	ErrMessageNotFound = errors.New("message not found")
+	// ErrNotMessageAuthor is returned when someone other than the sender
+	// tries to edit or delete a message
+	ErrNotMessageAuthor = errors.New("only the sender can change a message")
+	// ErrMessageDeleted is returned when editing a deleted message
+	ErrMessageDeleted = errors.New("message was deleted")
+	// ErrEditWindowExpired is returned for edits arriving after the edit window
+	ErrEditWindowExpired = errors.New("edit window has expired")
+)
+
+// MessageRevision is a superseded version of an edited message. WrittenAt
+// is when that version was sent or edited.
+type MessageRevision struct {
+	Content   string    `json:"content"`
+	WrittenAt time.Time `json:"written_at"`
+}
+
+// storedMessage is the state of a message needed to apply an edit or deletion
+type storedMessage struct {
+	id        int64
+	senderID  string
+	content   string
+	timestamp time.Time
+	editedAt  sql.NullTime
+	deletedAt sql.NullTime
+	isRead    bool
+	convID    string
+}
+
+// loadStoredMessage looks up a message by its sender-assigned ID and
+// checks that editorID sent it
+func loadStoredMessage(tx *sql.Tx, messageID, editorID string) (storedMessage, error) {
+	var m storedMessage
+	err := tx.QueryRow(`
+		SELECT id, sender_id, content, timestamp, edited_at, deleted_at, is_read, conversation_id
+		FROM messages WHERE message_id = ?
+	`, messageID).Scan(&m.id, &m.senderID, &m.content, &m.timestamp, &m.editedAt, &m.deletedAt, &m.isRead, &m.convID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return storedMessage{}, ErrMessageNotFound
+	}
+	if err != nil {
+		return storedMessage{}, fmt.Errorf("failed to load message: %w", err)
+	}
+
+	if m.senderID != editorID {
+		return storedMessage{}, ErrNotMessageAuthor
+	}
+	return m, nil
+}
+
+// EditMessage replaces the content of a message, keeping the previous
+// version as a revision. Only the original sender may edit, and when
+// window is positive only within window of sending. Edits arriving out of
+// order are kept as revisions without replacing newer content.
+func (d *Database) EditMessage(messageID, editorID, content string, editedAt time.Time, window time.Duration) error {
+	if strings.TrimSpace(content) == "" {
+		return fmt.Errorf("edited message cannot be empty")
+	}
+	editedAt = editedAt.UTC()
+
+	return d.withTx(func(tx *sql.Tx) error {
+		// Sealed in the write so a concurrent re-key can't leave it under a retired key
+		sealed, err := d.sealText(content)
+		if err != nil {
+			return fmt.Errorf("failed to encrypt message: %w", err)
+		}
+
+		m, err := loadStoredMessage(tx, messageID, editorID)
+		if err != nil {
+			return err
+		}
+		if m.deletedAt.Valid {
+			return ErrMessageDeleted
+		}
+		if window > 0 && editedAt.Sub(m.timestamp) > window {
+			return ErrEditWindowExpired
+		}
+		if m.editedAt.Valid && m.editedAt.Time.Equal(editedAt) {
+			return ErrDuplicateMessage
+		}
+
+		// A late edit older than the current version only joins the history
+		if m.editedAt.Valid && editedAt.Before(m.editedAt.Time) {
+			return insertRevision(tx, m.id, sealed, editedAt)
+		}
+
+		writtenAt := m.timestamp
+		if m.editedAt.Valid {
+			writtenAt = m.editedAt.Time
+		}
+		if err := insertRevision(tx, m.id, m.content, writtenAt); err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(`UPDATE messages SET content = ?, edited_at = ? WHERE id = ?`, sealed, editedAt, m.id)
+		if err != nil {
+			return fmt.Errorf("failed to edit message: %w", err)
+		}
+		return d.indexMessage(tx, m.id, content)
+	})
+}
+
+// insertRevision records a version of a message's content
+func insertRevision(tx *sql.Tx, rowID int64, content string, writtenAt time.Time) error {
+	_, err := tx.Exec(`INSERT INTO message_revisions (message_row_id, content, written_at) VALUES (?, ?, ?)`,
+		rowID, content, writtenAt)
+	if err != nil {
+		return fmt.Errorf("failed to save message revision: %w", err)
+	}
+	return nil
+}
+
+// DeleteMessage turns a message into a tombstone for everyone. Its content,
+// edit history, reactions, pin and star are erased, since deletion is how
+// a pasted secret is taken back; only the original sender may delete.
+func (d *Database) DeleteMessage(messageID, deleterID string, deletedAt time.Time) error {
+	return d.withTx(func(tx *sql.Tx) error {
+		m, err := loadStoredMessage(tx, messageID, deleterID)
+		if err != nil {
+			return err
+		}
+		if m.deletedAt.Valid {
+			return ErrDuplicateMessage
+		}
+
+		_, err = tx.Exec(`UPDATE messages SET content = '', deleted_at = ?, is_read = 1 WHERE id = ?`,
+			deletedAt.UTC(), m.id)
+		if err != nil {
+			return fmt.Errorf("failed to delete message: %w", err)
+		}
+		if _, err := tx.Exec(`DELETE FROM message_revisions WHERE message_row_id = ?`, m.id); err != nil {
+			return fmt.Errorf("failed to delete message history: %w", err)
+		}
+		if _, err := tx.Exec(`DELETE FROM reactions WHERE message_id = ?`, messageID); err != nil {
+			return fmt.Errorf("failed to delete message reactions: %w", err)
+		}
+		if _, err := tx.Exec(`DELETE FROM pins WHERE message_id = ?`, messageID); err != nil {
+			return fmt.Errorf("failed to unpin message: %w", err)
+		}
+		if _, err := tx.Exec(`DELETE FROM stars WHERE message_row_id = ?`, m.id); err != nil {
+			return fmt.Errorf("failed to unstar message: %w", err)
+		}
+		if !m.isRead {
+			if err := adjustUnread(tx, m.convID, -1); err != nil {
+				return err
+			}
+		}
+		return d.unindexMessage(tx, m.id)
+	})
+}
+
+// GetMessageRevisions returns the previous versions of a message, oldest first
+func (d *Database) GetMessageRevisions(id int64) ([]MessageRevision, error) {
+	if d.IsLocked() {
+		return nil, ErrLocked
+	}
+
+	rows, err := d.conn().db.Query(`
+		SELECT content, written_at FROM message_revisions
+		WHERE message_row_id = ?
+		ORDER BY written_at, id
+	`, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query message revisions: %w", err)
+	}
+	defer rows.Close()
+
+	revisions := []MessageRevision{}
+	for rows.Next() {
+		var revision MessageRevision
+		if err := rows.Scan(&revision.Content, &revision.WrittenAt); err != nil {
+			return nil, fmt.Errorf("failed to scan message revision: %w", err)
+		}
+		if revision.Content, err = d.openText(revision.Content); err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, revision)
+	}
+
+	return revisions, rows.Err()
+}
+
+// SetEditWindow sets how long after sending a peer's edits are accepted;
+// zero accepts edits at any time
+func (d *Database) SetEditWindow(window time.Duration) error {
+	if window < 0 {
+		return fmt.Errorf("edit window cannot be negative")
+	}
+	return setMeta(execFunc(d.exec), "messages.edit_window_seconds", strconv.Itoa(int(window/time.Second)))
+}
+
+// EditWindow returns the configured edit window
+func (d *Database) EditWindow() (time.Duration, error) {
+	value, err := getMeta(d.conn().db, "messages.edit_window_seconds")
+	if errors.Is(err, sql.ErrNoRows) {
+		return defaultEditWindow, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to load edit window: %w", err)
+	}
+
+	seconds, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid edit window: %w", err)
+	}
+	return time.Duration(seconds) * time.Second, nil
+}
@@ -0,0 +1,606 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"strings"
+	"time"
+)
+
+const (
+	// ExportJSON is the lossless, re-importable export format
+	ExportJSON = "json"
+	// ExportHTML is a self-contained page for reading
+	ExportHTML = "html"
+	// ExportText is plain text for reading
+	ExportText = "text"
+
+	// exportFormatName and exportVersion identify JSON exports on import
+	exportFormatName = "lanvochat-export"
+	exportVersion    = 2
+)
+
+// ExportOptions selects what to export and how. An empty ConversationID
+// exports the whole history. Names adds display names, such as the local
+// user's, to those known from the peers table.
+type ExportOptions struct {
+	Format         string            `json:"format"`
+	ConversationID string            `json:"conversation_id,omitempty"`
+	Names          map[string]string `json:"names,omitempty"`
+}
+
+// Export is the JSON export document
+type Export struct {
+	Format        string                 `json:"format"`
+	Version       int                    `json:"version"`
+	ExportedAt    time.Time              `json:"exported_at"`
+	Conversations []ExportedConversation `json:"conversations"`
+}
+
+// ExportedConversation is a conversation with its full history
+type ExportedConversation struct {
+	Conversation
+	Messages []ExportedMessage `json:"messages"`
+}
+
+// ExportedMessage is a message with its edit history, the state of every
+// reaction to it and of its pin, including withdrawn ones so an import
+// converges with what is already stored, and when we starred it
+type ExportedMessage struct {
+	Message
+	Revisions      []MessageRevision  `json:"revisions,omitempty"`
+	ReactionStates []ExportedReaction `json:"reaction_states,omitempty"`
+	PinState       *ExportedPin       `json:"pin_state,omitempty"`
+	StarredAt      *time.Time         `json:"starred_at,omitempty"`
+}
+
+// ExportedReaction is one peer's reaction to a message with an emoji
+type ExportedReaction struct {
+	PeerID    string    `json:"peer_id"`
+	Emoji     string    `json:"emoji"`
+	Active    bool      `json:"active"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// ExportedPin is the last pin or unpin of a message
+type ExportedPin struct {
+	ChangedBy string    `json:"changed_by"`
+	Active    bool      `json:"active"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// ImportResult summarizes what an import merged in
+type ImportResult struct {
+	Conversations int `json:"conversations"`
+	Messages      int `json:"messages"`
+	Skipped       int `json:"skipped"`
+}
+
+// ExportHistory writes a conversation, or the whole history, to w
+func (d *Database) ExportHistory(w io.Writer, opts ExportOptions) error {
+	if d.IsLocked() {
+		return ErrLocked
+	}
+
+	conversations, err := d.exportConversations(opts.ConversationID)
+	if err != nil {
+		return err
+	}
+
+	export := Export{
+		Format:        exportFormatName,
+		Version:       exportVersion,
+		ExportedAt:    time.Now().UTC(),
+		Conversations: conversations,
+	}
+
+	switch opts.Format {
+	case ExportJSON:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(export)
+	case ExportHTML, ExportText:
+		names, err := d.displayNames(opts.Names)
+		if err != nil {
+			return err
+		}
+		if opts.Format == ExportHTML {
+			return writeHTMLExport(w, export, names)
+		}
+		return writeTextExport(w, export, names)
+	default:
+		return fmt.Errorf("unknown export format %q", opts.Format)
+	}
+}
+
+// exportConversations loads conversations with their messages and edit
+// history in chronological order
+func (d *Database) exportConversations(conversationID string) ([]ExportedConversation, error) {
+	var conversations []Conversation
+	if conversationID != "" {
+		conversation, err := d.GetConversation(conversationID)
+		if err != nil {
+			return nil, err
+		}
+		conversations = []Conversation{conversation}
+	} else {
+		var err error
+		if conversations, err = d.GetConversations(); err != nil {
+			return nil, err
+		}
+	}
+
+	exported := make([]ExportedConversation, 0, len(conversations))
+	for _, conversation := range conversations {
+		rows, err := d.conn().db.Query(`
+			SELECT `+messageColumns+` FROM messages
+			WHERE conversation_id = ?
+			ORDER BY timestamp, id
+		`, conversation.ID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to query messages: %w", err)
+		}
+		messages, err := d.scanMessages(rows)
+		rows.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		revisions, err := d.conversationRevisions(conversation.ID)
+		if err != nil {
+			return nil, err
+		}
+		reactions, err := d.conversationReactions(conversation.ID)
+		if err != nil {
+			return nil, err
+		}
+		pins, stars, err := d.conversationMarks(conversation.ID)
+		if err != nil {
+			return nil, err
+		}
+
+		entry := ExportedConversation{Conversation: conversation, Messages: make([]ExportedMessage, 0, len(messages))}
+		for _, msg := range messages {
+			entry.Messages = append(entry.Messages, ExportedMessage{
+				Message:        msg,
+				Revisions:      revisions[msg.ID],
+				ReactionStates: reactions[msg.MessageID],
+				PinState:       pins[msg.MessageID],
+				StarredAt:      stars[msg.ID],
+			})
+		}
+		exported = append(exported, entry)
+	}
+
+	return exported, nil
+}
+
+// conversationRevisions loads the edit history of a conversation's
+// messages, keyed by message row ID
+func (d *Database) conversationRevisions(conversationID string) (map[int64][]MessageRevision, error) {
+	rows, err := d.conn().db.Query(`
+		SELECT r.message_row_id, r.content, r.written_at
+		FROM message_revisions r
+		JOIN messages m ON m.id = r.message_row_id
+		WHERE m.conversation_id = ?
+		ORDER BY r.written_at, r.id
+	`, conversationID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query message revisions: %w", err)
+	}
+	defer rows.Close()
+
+	revisions := make(map[int64][]MessageRevision)
+	for rows.Next() {
+		var rowID int64
+		var revision MessageRevision
+		if err := rows.Scan(&rowID, &revision.Content, &revision.WrittenAt); err != nil {
+			return nil, fmt.Errorf("failed to scan message revision: %w", err)
+		}
+		if revision.Content, err = d.openText(revision.Content); err != nil {
+			return nil, err
+		}
+		revisions[rowID] = append(revisions[rowID], revision)
+	}
+
+	return revisions, rows.Err()
+}
+
+// conversationReactions loads the reaction states of a conversation's
+// messages, keyed by message ID
+func (d *Database) conversationReactions(conversationID string) (map[string][]ExportedReaction, error) {
+	rows, err := d.conn().db.Query(`
+		SELECT r.message_id, r.peer_id, r.emoji, r.active, r.updated_at
+		FROM reactions r
+		JOIN messages m ON m.message_id = r.message_id
+		WHERE m.conversation_id = ?
+		ORDER BY r.updated_at, r.peer_id, r.emoji
+	`, conversationID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query reactions: %w", err)
+	}
+	defer rows.Close()
+
+	reactions := make(map[string][]ExportedReaction)
+	for rows.Next() {
+		var messageID string
+		var updatedAt int64
+		var reaction ExportedReaction
+		if err := rows.Scan(&messageID, &reaction.PeerID, &reaction.Emoji, &reaction.Active, &updatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan reaction: %w", err)
+		}
+		reaction.UpdatedAt = time.Unix(0, updatedAt).UTC()
+		reactions[messageID] = append(reactions[messageID], reaction)
+	}
+
+	return reactions, rows.Err()
+}
+
+// conversationMarks loads the pin states of a conversation's messages,
+// keyed by message ID, and when they were starred, keyed by row ID
+func (d *Database) conversationMarks(conversationID string) (map[string]*ExportedPin, map[int64]*time.Time, error) {
+	rows, err := d.conn().db.Query(`
+		SELECT p.message_id, p.changed_by, p.active, p.updated_at
+		FROM pins p
+		JOIN messages m ON m.message_id = p.message_id
+		WHERE m.conversation_id = ?
+	`, conversationID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to query pins: %w", err)
+	}
+	defer rows.Close()
+
+	pins := make(map[string]*ExportedPin)
+	for rows.Next() {
+		var messageID string
+		var updatedAt int64
+		var pin ExportedPin
+		if err := rows.Scan(&messageID, &pin.ChangedBy, &pin.Active, &updatedAt); err != nil {
+			return nil, nil, fmt.Errorf("failed to scan pin: %w", err)
+		}
+		pin.UpdatedAt = time.Unix(0, updatedAt).UTC()
+		pins[messageID] = &pin
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+	rows.Close()
+
+	rows, err = d.conn().db.Query(`
+		SELECT s.message_row_id, s.starred_at
+		FROM stars s
+		JOIN messages m ON m.id = s.message_row_id
+		WHERE m.conversation_id = ?
+	`, conversationID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to query stars: %w", err)
+	}
+	defer rows.Close()
+
+	stars := make(map[int64]*time.Time)
+	for rows.Next() {
+		var rowID int64
+		var starredAt time.Time
+		if err := rows.Scan(&rowID, &starredAt); err != nil {
+			return nil, nil, fmt.Errorf("failed to scan star: %w", err)
+		}
+		stars[rowID] = &starredAt
+	}
+
+	return pins, stars, rows.Err()
+}
+
+// displayNames maps peer IDs to nicknames or announced names from the
+// peers table, plus extra
+func (d *Database) displayNames(extra map[string]string) (map[string]string, error) {
+	peers, err := d.GetPeers()
+	if err != nil {
+		return nil, err
+	}
+
+	names := make(map[string]string, len(peers)+len(extra))
+	for _, peer := range peers {
+		if name := peer.DisplayName(); name != "" {
+			names[peer.PeerID] = name
+		}
+	}
+	for peerID, name := range extra {
+		names[peerID] = name
+	}
+	return names, nil
+}
+
+// conversationLabel is the heading used for a conversation in readable exports
+func conversationLabel(c Conversation, names map[string]string) string {
+	switch {
+	case c.Title != "":
+		return c.Title
+	case c.Kind == ConversationBroadcast:
+		return "Broadcast"
+	case c.Kind == ConversationDirect:
+		peerID := strings.TrimPrefix(c.ID, ConversationDirect+":")
+		if name, ok := names[peerID]; ok {
+			return name
+		}
+		return peerID
+	default:
+		return c.ID
+	}
+}
+
+// writeTextExport writes a plain text transcript
+func writeTextExport(w io.Writer, export Export, names map[string]string) error {
+	var b strings.Builder
+	for _, conversation := range export.Conversations {
+		if len(conversation.Messages) == 0 {
+			continue
+		}
+		if b.Len() > 0 {
+			b.WriteString("\n")
+		}
+		fmt.Fprintf(&b, "== %s (%s) ==\n", conversationLabel(conversation.Conversation, names), conversation.ID)
+
+		for _, msg := range conversation.Messages {
+			sender := msg.SenderID
+			if name, ok := names[sender]; ok {
+				sender = name
+			}
+
+			content := msg.Content
+			switch {
+			case msg.DeletedAt != nil:
+				content = "[deleted]"
+			case msg.EditedAt != nil:
+				content += " (edited)"
+			}
+			// Indent continuation lines so multi-line messages stay readable
+			content = strings.ReplaceAll(content, "\n", "\n    ")
+
+			fmt.Fprintf(&b, "[%s] %s: %s\n", msg.Timestamp.Local().Format("2006-01-02 15:04"), sender, content)
+		}
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// htmlExportTemplate renders a self-contained, readable transcript
+var htmlExportTemplate = template.Must(template.New("export").Funcs(template.FuncMap{
+	"label": conversationLabel,
+	"sender": func(names map[string]string, peerID string) string {
+		if name, ok := names[peerID]; ok {
+			return name
+		}
+		return peerID
+	},
+	"when": func(t time.Time) string {
+		return t.Local().Format("2006-01-02 15:04")
+	},
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>LanvoChat history</title>
+<style>
+body { font-family: sans-serif; max-width: 50em; margin: 2em auto; color: #222; }
+h2 { border-bottom: 1px solid #ccc; padding-bottom: .3em; }
+.message { margin: .4em 0; }
+.meta { color: #888; font-size: .85em; margin-right: .5em; }
+.sender { font-weight: bold; margin-right: .5em; }
+.content { white-space: pre-wrap; }
+.note { color: #888; font-style: italic; }
+</style>
+</head>
+<body>
+<h1>LanvoChat history</h1>
+<p class="note">Exported {{when .Export.ExportedAt}}</p>
+{{range .Export.Conversations}}{{if .Messages}}
+<h2>{{label .Conversation $.Names}}</h2>
+{{range .Messages}}
+<div class="message">
+<span class="meta">{{when .Timestamp}}</span><span class="sender">{{sender $.Names .SenderID}}</span>
+{{if .DeletedAt}}<span class="note">message deleted</span>{{else}}<span class="content">{{.Content}}</span>{{if .EditedAt}} <span class="note">(edited)</span>{{end}}{{end}}
+</div>
+{{end}}
+{{end}}{{end}}
+</body>
+</html>
+`))
+
+// writeHTMLExport writes an HTML transcript
+func writeHTMLExport(w io.Writer, export Export, names map[string]string) error {
+	return htmlExportTemplate.Execute(w, struct {
+		Export Export
+		Names  map[string]string
+	}{export, names})
+}
+
+// ImportHistory merges a JSON export into the database in one
+// transaction. Messages already stored, matched by message ID, are
+// skipped, so importing the same export twice changes nothing.
+func (d *Database) ImportHistory(r io.Reader) (ImportResult, error) {
+	if d.IsLocked() {
+		return ImportResult{}, ErrLocked
+	}
+
+	var export Export
+	if err := json.NewDecoder(r).Decode(&export); err != nil {
+		return ImportResult{}, fmt.Errorf("failed to read export: %w", err)
+	}
+	if export.Format != exportFormatName {
+		return ImportResult{}, fmt.Errorf("not a LanvoChat export")
+	}
+	if export.Version > exportVersion {
+		return ImportResult{}, fmt.Errorf("export version %d is newer than supported version %d", export.Version, exportVersion)
+	}
+
+	var result ImportResult
+	err := d.withTx(func(tx *sql.Tx) error {
+		for _, conversation := range export.Conversations {
+			created, err := d.importConversation(tx, conversation.Conversation)
+			if err != nil {
+				return err
+			}
+			if created {
+				result.Conversations++
+			}
+
+			for _, msg := range conversation.Messages {
+				imported, err := d.importMessage(tx, conversation.ID, msg)
+				if err != nil {
+					return err
+				}
+				// Reactions, pins and stars merge into messages already stored too
+				if err := importMarks(tx, msg); err != nil {
+					return err
+				}
+				if imported {
+					result.Messages++
+				} else {
+					result.Skipped++
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return ImportResult{}, err
+	}
+
+	return result, nil
+}
+
+// importConversation creates an exported conversation with its settings
+// if it doesn't exist and adds its members. Local settings of an existing
+// conversation are kept.
+func (d *Database) importConversation(tx *sql.Tx, c Conversation) (bool, error) {
+	kind, err := conversationKind(c.ID)
+	if err != nil {
+		return false, err
+	}
+
+	// Keeps the member list version so older lists from peers stay ignored
+	var membersChangedAt int64
+	if !c.MembersChangedAt.IsZero() {
+		membersChangedAt = c.MembersChangedAt.UnixNano()
+	}
+
+	result, err := tx.Exec(`
+		INSERT INTO conversations (id, kind, title, created_at, last_activity, muted, archived,
+			retention_days, retention_messages, legal_hold, members_changed_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(id) DO NOTHING
+	`, c.ID, kind, c.Title, c.CreatedAt.UTC(), c.LastActivity.UTC(), c.Muted, c.Archived,
+		c.Retention.Days, c.Retention.Messages, c.LegalHold, membersChangedAt)
+	if err != nil {
+		return false, fmt.Errorf("failed to import conversation %s: %w", c.ID, err)
+	}
+	created, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to import conversation %s: %w", c.ID, err)
+	}
+
+	if err := d.addMembers(tx, c.ID, c.Members, c.CreatedAt.UTC()); err != nil {
+		return false, err
+	}
+	return created > 0, nil
+}
+
+// importMessage stores an exported message with its edit history and
+// tombstone, reporting false if it was already stored
+func (d *Database) importMessage(tx *sql.Tx, conversationID string, msg ExportedMessage) (bool, error) {
+	if msg.MessageID == "" {
+		return false, fmt.Errorf("exported message %d has no message ID", msg.ID)
+	}
+	msg.ConversationID = conversationID
+	if msg.DeletedAt != nil {
+		// Tombstones never hold content or count as unread
+		msg.Content = ""
+		msg.IsRead = true
+	}
+
+	id, err := d.insertMessage(tx, msg.Message)
+	if errors.Is(err, ErrDuplicateMessage) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	if msg.EditedAt != nil || msg.DeletedAt != nil {
+		_, err := tx.Exec(`UPDATE messages SET edited_at = ?, deleted_at = ? WHERE id = ?`,
+			utcOrNil(msg.EditedAt), utcOrNil(msg.DeletedAt), id)
+		if err != nil {
+			return false, fmt.Errorf("failed to import message %s: %w", msg.MessageID, err)
+		}
+	}
+	if msg.DeletedAt != nil {
+		return true, d.unindexMessage(tx, id)
+	}
+
+	for _, revision := range msg.Revisions {
+		sealed, err := d.sealText(revision.Content)
+		if err != nil {
+			return false, fmt.Errorf("failed to encrypt message revision: %w", err)
+		}
+		if err := insertRevision(tx, id, sealed, revision.WrittenAt.UTC()); err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// importMarks merges a message's exported reaction and pin states,
+// keeping whichever of the stored and exported state is newer, and stars
+// it if it was starred. Deleted messages keep none of these.
+func importMarks(tx *sql.Tx, msg ExportedMessage) error {
+	if msg.DeletedAt != nil || (len(msg.ReactionStates) == 0 && msg.PinState == nil && msg.StarredAt == nil) {
+		return nil
+	}
+	var rowID int64
+	var deleted bool
+	err := tx.QueryRow(`SELECT id, deleted_at IS NOT NULL FROM messages WHERE message_id = ?`, msg.MessageID).Scan(&rowID, &deleted)
+	if err != nil {
+		return fmt.Errorf("failed to load message %s: %w", msg.MessageID, err)
+	}
+	if deleted {
+		return nil
+	}
+
+	for _, reaction := range msg.ReactionStates {
+		if err := validateEmoji(reaction.Emoji); err != nil {
+			return fmt.Errorf("invalid reaction to message %s: %w", msg.MessageID, err)
+		}
+		_, err := mergeReaction(tx, msg.MessageID, reaction.PeerID, reaction.Emoji, reaction.Active, reaction.UpdatedAt.UnixNano())
+		if err != nil {
+			return err
+		}
+	}
+
+	if pin := msg.PinState; pin != nil {
+		if _, err := mergePin(tx, msg.MessageID, pin.ChangedBy, pin.Active, pin.UpdatedAt.UnixNano()); err != nil {
+			return err
+		}
+	}
+
+	if msg.StarredAt != nil {
+		_, err := tx.Exec(`INSERT INTO stars (message_row_id, starred_at) VALUES (?, ?) ON CONFLICT DO NOTHING`,
+			rowID, msg.StarredAt.UTC())
+		if err != nil {
+			return fmt.Errorf("failed to import star: %w", err)
+		}
+	}
+	return nil
+}
+
+// utcOrNil converts an optional time for storage
+func utcOrNil(t *time.Time) interface{} {
+	if t == nil {
+		return nil
+	}
+	return t.UTC()
+}
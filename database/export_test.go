@@ -0,0 +1,160 @@
+package database
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// seedHistory fills a database with messages carrying every kind of
+// detail an export holds: edits, a deletion, reactions, a pin and a star
+func seedHistory(t *testing.T, d *Database) {
+	t.Helper()
+
+	start := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	ids := insertMessages(t, d, "peer", start, 4)
+	messages := make([]Message, len(ids))
+	for i, id := range ids {
+		msg, err := d.GetMessage(id)
+		if err != nil {
+			t.Fatalf("failed to load message: %v", err)
+		}
+		messages[i] = msg
+	}
+
+	group, err := d.CreateGroupConversation("team", []string{"peer", "other"})
+	if err != nil {
+		t.Fatalf("failed to create group: %v", err)
+	}
+	if _, err := d.InsertMessage(Message{ConversationID: group.ID, PeerID: "other", SenderID: "other", Content: "hello team", Timestamp: start}); err != nil {
+		t.Fatalf("failed to insert group message: %v", err)
+	}
+
+	steps := []struct {
+		name string
+		fn   func() error
+	}{
+		{"edit", func() error {
+			return d.EditMessage(messages[0].MessageID, "peer", "edited once", start.Add(time.Minute), 0)
+		}},
+		{"edit again", func() error {
+			return d.EditMessage(messages[0].MessageID, "peer", "edited twice", start.Add(2*time.Minute), 0)
+		}},
+		{"react", func() error {
+			return d.ApplyReaction(messages[1].MessageID, "peer", "👍", false, start.Add(time.Minute), false)
+		}},
+		{"withdrawn reaction", func() error {
+			return d.ApplyReaction(messages[1].MessageID, "other", "🎉", true, start.Add(time.Minute), false)
+		}},
+		{"pin", func() error {
+			return d.ApplyPin(messages[2].MessageID, "peer", false, start.Add(time.Minute), false)
+		}},
+		{"star", func() error { return d.StarMessage(messages[2].ID, true) }},
+		{"delete", func() error {
+			return d.DeleteMessage(messages[3].MessageID, "peer", start.Add(time.Minute))
+		}},
+	}
+	for _, step := range steps {
+		if err := step.fn(); err != nil {
+			t.Fatalf("failed to %s: %v", step.name, err)
+		}
+	}
+}
+
+// exportHistory returns a JSON export of the whole history, without the
+// time it was taken or local row IDs so exports can be compared
+func exportHistory(t *testing.T, d *Database) Export {
+	t.Helper()
+
+	var buf bytes.Buffer
+	if err := d.ExportHistory(&buf, ExportOptions{Format: ExportJSON}); err != nil {
+		t.Fatalf("failed to export: %v", err)
+	}
+	var export Export
+	if err := json.Unmarshal(buf.Bytes(), &export); err != nil {
+		t.Fatalf("failed to decode export: %v", err)
+	}
+	export.ExportedAt = time.Time{}
+	for _, c := range export.Conversations {
+		for i := range c.Messages {
+			c.Messages[i].ID = 0
+		}
+	}
+	return export
+}
+
+func TestExportImportRoundTrip(t *testing.T) {
+	tests := []struct {
+		name       string
+		passphrase string
+	}{
+		{"plain", ""},
+		{"into an encrypted database", "correct horse battery staple"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			source := newTestDatabase(t)
+			seedHistory(t, source)
+			want := exportHistory(t, source)
+
+			var buf bytes.Buffer
+			if err := source.ExportHistory(&buf, ExportOptions{Format: ExportJSON}); err != nil {
+				t.Fatalf("failed to export: %v", err)
+			}
+			data := buf.Bytes()
+
+			target := newTestDatabase(t)
+			if tt.passphrase != "" {
+				if err := target.EnableEncryption(tt.passphrase); err != nil {
+					t.Fatalf("failed to enable encryption: %v", err)
+				}
+			}
+
+			result, err := target.ImportHistory(bytes.NewReader(data))
+			if err != nil {
+				t.Fatalf("failed to import: %v", err)
+			}
+			if result.Conversations != 2 || result.Messages != 5 || result.Skipped != 0 {
+				t.Fatalf("got %+v, want 2 conversations and 5 messages", result)
+			}
+			if got := exportHistory(t, target); !reflect.DeepEqual(got, want) {
+				t.Fatalf("re-export differs from the original:\ngot  %+v\nwant %+v", got, want)
+			}
+
+			// Importing the same export again changes nothing
+			result, err = target.ImportHistory(bytes.NewReader(data))
+			if err != nil {
+				t.Fatalf("failed to import again: %v", err)
+			}
+			if result.Conversations != 0 || result.Messages != 0 || result.Skipped != 5 {
+				t.Fatalf("got %+v on re-import, want 5 skipped", result)
+			}
+			if got := exportHistory(t, target); !reflect.DeepEqual(got, want) {
+				t.Fatalf("re-import changed the history:\ngot  %+v\nwant %+v", got, want)
+			}
+		})
+	}
+}
+
+func TestImportRejectsOtherDocuments(t *testing.T) {
+	tests := []struct {
+		name string
+		doc  string
+	}{
+		{"not json", "hello"},
+		{"another format", `{"format": "something-else", "version": 1}`},
+		{"newer version", `{"format": "lanvochat-export", "version": 99}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := newTestDatabase(t)
+			if _, err := d.ImportHistory(bytes.NewReader([]byte(tt.doc))); err == nil {
+				t.Fatal("import succeeded")
+			}
+		})
+	}
+}
@@ -0,0 +1,574 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+// ErrSchemaTooNew is returned when the database was written by a newer
+// version of LanvoChat than this one
+var ErrSchemaTooNew = errors.New("database was created by a newer version of LanvoChat")
+
+// migration is one ordered, forward-only schema change. Early migrations
+// also run against databases created before versioning existed, so they
+// must tolerate tables and columns that are already there.
+type migration struct {
+	version int
+	name    string
+	up      func(tx *sql.Tx) error
+}
+
+// migrations lists every schema change in the order it is applied.
+// Never edit or reorder an entry once released; append a new one instead.
+var migrations = []migration{
+	{1, "initial messages and peers tables", migrateInitialSchema},
+	{2, "meta key/value table", migrateMetaTable},
+	{3, "peer access rules", migrateAccessRules},
+	{4, "message ids for idempotent storage", migrateMessageIDs},
+	{5, "history paging index", migrateHistoryIndex},
+	{6, "conversations", migrateConversations},
+	{7, "unread counters", migrateUnreadCounts},
+	{8, "message revisions", migrateMessageRevisions},
+	{9, "retention policies", migrateRetention},
+	{10, "backfill message ids", migrateBackfillMessageIDs},
+	{11, "peer presence history", migratePeerPresence},
+	{12, "contact details and tags", migrateContacts},
+	{13, "message reactions", migrateReactions},
+	{14, "reply threads", migrateThreads},
+	{15, "pinned and starred messages", migratePinsAndStars},
+	{16, "message drafts", migrateDrafts},
+	{17, "UTC message timestamps", migrateUTCTimestamps},
+	{18, "full-text search index", migrateSearchIndex},
+	{19, "group member list versions", migrateMemberVersions},
+}
+
+// latestSchemaVersion is the schema version this build writes
+func latestSchemaVersion() int {
+	return migrations[len(migrations)-1].version
+}
+
+// migrate brings the schema up to date, applying each pending migration
+// in its own transaction after taking a backup of existing data
+func (d *Database) migrate() error {
+	_, err := d.exec(`
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version INTEGER PRIMARY KEY,
+			name TEXT NOT NULL,
+			applied_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create schema_migrations: %w", err)
+	}
+
+	current, err := d.SchemaVersion()
+	if err != nil {
+		return err
+	}
+	if current > latestSchemaVersion() {
+		return fmt.Errorf("%w (schema version %d, supported up to %d)", ErrSchemaTooNew, current, latestSchemaVersion())
+	}
+	if current == latestSchemaVersion() {
+		return nil
+	}
+
+	if err := d.backupBeforeMigration(current); err != nil {
+		return err
+	}
+
+	for _, m := range migrations {
+		if m.version <= current {
+			continue
+		}
+
+		err := d.withTx(func(tx *sql.Tx) error {
+			if err := m.up(tx); err != nil {
+				return err
+			}
+			_, err := tx.Exec(`INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)`,
+				m.version, m.name, time.Now())
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("migration %d (%s) failed: %w", m.version, m.name, err)
+		}
+		log.Printf("Applied database migration %d: %s", m.version, m.name)
+	}
+
+	return nil
+}
+
+// SchemaVersion returns the highest migration applied to the database
+func (d *Database) SchemaVersion() (int, error) {
+	var version int
+	err := d.conn().db.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&version)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read schema version: %w", err)
+	}
+
+	return version, nil
+}
+
+// backupBeforeMigration snapshots a database that already holds data so a
+// failed or unwanted upgrade can be rolled back by hand
+func (d *Database) backupBeforeMigration(current int) error {
+	if d.path == "" || d.path == ":memory:" {
+		return nil
+	}
+
+	var tables int
+	err := d.conn().db.QueryRow(`
+		SELECT COUNT(*) FROM sqlite_master
+		WHERE type = 'table' AND name NOT LIKE 'sqlite_%' AND name != 'schema_migrations'
+	`).Scan(&tables)
+	if err != nil {
+		return fmt.Errorf("failed to inspect database: %w", err)
+	}
+	if tables == 0 {
+		return nil // fresh database, nothing to protect
+	}
+
+	backupPath := fmt.Sprintf("%s.pre-v%d-%s.bak", d.path, latestSchemaVersion(), time.Now().Format("20060102-150405"))
+	if _, err := d.conn().writeDB.Exec(`VACUUM INTO ?`, backupPath); err != nil {
+		return fmt.Errorf("failed to back up database before migration: %w", err)
+	}
+
+	log.Printf("Backed up schema version %d database to %s", current, backupPath)
+	d.pruneSafetyCopies()
+	return nil
+}
+
+// addColumnIfMissing adds a column to an existing table unless it's already there
+func addColumnIfMissing(tx *sql.Tx, table, column, definition string) error {
+	rows, err := tx.Query(fmt.Sprintf(`PRAGMA table_info(%s)`, table))
+	if err != nil {
+		return fmt.Errorf("failed to inspect %s: %w", table, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, colType string
+		var defaultValue sql.NullString
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &defaultValue, &pk); err != nil {
+			return fmt.Errorf("failed to scan %s columns: %w", table, err)
+		}
+		if name == column {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+
+	_, err = tx.Exec(fmt.Sprintf(`ALTER TABLE %s ADD COLUMN %s %s`, table, column, definition))
+	if err != nil {
+		return fmt.Errorf("failed to add %s.%s: %w", table, column, err)
+	}
+	return nil
+}
+
+// migrateInitialSchema creates the original messages and peers tables
+func migrateInitialSchema(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+	CREATE TABLE IF NOT EXISTS messages (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		peer_id TEXT NOT NULL,
+		sender_id TEXT NOT NULL,
+		content TEXT NOT NULL,
+		timestamp DATETIME DEFAULT CURRENT_TIMESTAMP,
+		is_read BOOLEAN DEFAULT 0,
+		FOREIGN KEY (peer_id) REFERENCES peers(peer_id)
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_messages_peer_id ON messages(peer_id);
+	CREATE INDEX IF NOT EXISTS idx_messages_timestamp ON messages(timestamp);
+
+	CREATE TABLE IF NOT EXISTS peers (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		peer_id TEXT UNIQUE NOT NULL,
+		name TEXT NOT NULL,
+		ip_address TEXT NOT NULL,
+		last_seen DATETIME DEFAULT CURRENT_TIMESTAMP,
+		is_online BOOLEAN DEFAULT 0,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_peers_peer_id ON peers(peer_id);
+	CREATE INDEX IF NOT EXISTS idx_peers_is_online ON peers(is_online);
+	`)
+	return err
+}
+
+// migrateMetaTable adds the key/value table used for encryption and lock settings
+func migrateMetaTable(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+	CREATE TABLE IF NOT EXISTS meta (
+		key TEXT PRIMARY KEY,
+		value TEXT NOT NULL
+	);
+	`)
+	return err
+}
+
+// migrateAccessRules adds the peer block/allow list
+func migrateAccessRules(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+	CREATE TABLE IF NOT EXISTS access_rules (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		kind TEXT NOT NULL CHECK (kind IN ('block', 'allow')),
+		peer_id TEXT NOT NULL DEFAULT '',
+		cidr TEXT NOT NULL DEFAULT '',
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		UNIQUE (kind, peer_id, cidr)
+	);
+	`)
+	return err
+}
+
+// migrateMessageIDs adds sender-assigned message IDs so retransmissions
+// are stored only once
+func migrateMessageIDs(tx *sql.Tx) error {
+	if err := addColumnIfMissing(tx, "messages", "message_id", "TEXT"); err != nil {
+		return err
+	}
+	_, err := tx.Exec(`CREATE UNIQUE INDEX IF NOT EXISTS idx_messages_message_id ON messages(message_id)`)
+	return err
+}
+
+// migrateHistoryIndex covers the (timestamp, id) keyset used for paging
+func migrateHistoryIndex(tx *sql.Tx) error {
+	_, err := tx.Exec(`CREATE INDEX IF NOT EXISTS idx_messages_peer_timestamp ON messages(peer_id, timestamp, id)`)
+	return err
+}
+
+// migrateConversations introduces conversations and their members, and
+// files every existing message under the direct chat with its peer
+func migrateConversations(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+	CREATE TABLE IF NOT EXISTS conversations (
+		id TEXT PRIMARY KEY,
+		kind TEXT NOT NULL CHECK (kind IN ('direct', 'group', 'broadcast')),
+		title TEXT NOT NULL DEFAULT '',
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		last_activity DATETIME DEFAULT CURRENT_TIMESTAMP,
+		muted BOOLEAN DEFAULT 0,
+		archived BOOLEAN DEFAULT 0
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_conversations_last_activity ON conversations(last_activity);
+
+	CREATE TABLE IF NOT EXISTS conversation_members (
+		conversation_id TEXT NOT NULL,
+		peer_id TEXT NOT NULL,
+		joined_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (conversation_id, peer_id),
+		FOREIGN KEY (conversation_id) REFERENCES conversations(id)
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_conversation_members_peer_id ON conversation_members(peer_id);
+	`)
+	if err != nil {
+		return err
+	}
+
+	if err := addColumnIfMissing(tx, "messages", "conversation_id", "TEXT"); err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`
+	UPDATE messages SET conversation_id = 'direct:' || peer_id WHERE conversation_id IS NULL;
+
+	INSERT INTO conversations (id, kind, created_at, last_activity)
+	SELECT conversation_id, 'direct', MIN(timestamp), MAX(timestamp)
+	FROM messages
+	GROUP BY conversation_id
+	ON CONFLICT(id) DO NOTHING;
+
+	INSERT INTO conversation_members (conversation_id, peer_id, joined_at)
+	SELECT conversation_id, peer_id, MIN(timestamp)
+	FROM messages
+	GROUP BY conversation_id
+	ON CONFLICT DO NOTHING;
+
+	CREATE INDEX IF NOT EXISTS idx_messages_conversation ON messages(conversation_id, timestamp, id);
+
+	INSERT INTO conversations (id, kind, title)
+	VALUES ('broadcast', 'broadcast', 'Broadcast')
+	ON CONFLICT(id) DO NOTHING;
+	`)
+	return err
+}
+
+// migrateUnreadCounts adds a maintained unread counter per conversation.
+// Our own messages were never marked read, so direct messages not sent by
+// the peer are marked read before counting.
+func migrateUnreadCounts(tx *sql.Tx) error {
+	if err := addColumnIfMissing(tx, "conversations", "unread_count", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+
+	_, err := tx.Exec(`
+	UPDATE messages SET is_read = 1 WHERE peer_id != '' AND sender_id != peer_id;
+
+	UPDATE conversations SET unread_count = (
+		SELECT COUNT(*) FROM messages
+		WHERE messages.conversation_id = conversations.id AND messages.is_read = 0
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_messages_unread ON messages(conversation_id, is_read);
+	`)
+	return err
+}
+
+// migrateMessageRevisions adds edit and deletion tombstones to messages and
+// keeps superseded versions of edited messages
+func migrateMessageRevisions(tx *sql.Tx) error {
+	if err := addColumnIfMissing(tx, "messages", "edited_at", "DATETIME"); err != nil {
+		return err
+	}
+	if err := addColumnIfMissing(tx, "messages", "deleted_at", "DATETIME"); err != nil {
+		return err
+	}
+
+	_, err := tx.Exec(`
+	CREATE TABLE IF NOT EXISTS message_revisions (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		message_row_id INTEGER NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+		content TEXT NOT NULL,
+		written_at DATETIME NOT NULL
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_message_revisions_message ON message_revisions(message_row_id, written_at);
+	`)
+	return err
+}
+
+// migrateRetention adds per-conversation retention limits and legal hold
+func migrateRetention(tx *sql.Tx) error {
+	columns := []struct{ name, definition string }{
+		{"retention_days", "INTEGER NOT NULL DEFAULT 0"},
+		{"retention_messages", "INTEGER NOT NULL DEFAULT 0"},
+		{"legal_hold", "BOOLEAN NOT NULL DEFAULT 0"},
+	}
+	for _, column := range columns {
+		if err := addColumnIfMissing(tx, "conversations", column.name, column.definition); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrateBackfillMessageIDs gives messages stored before message IDs existed
+// a random one, so exports of them can be merged back without duplicates
+func migrateBackfillMessageIDs(tx *sql.Tx) error {
+	_, err := tx.Exec(`UPDATE messages SET message_id = lower(hex(randomblob(16))) WHERE message_id IS NULL`)
+	return err
+}
+
+// migratePeerPresence records when each peer last came online or went
+// offline, with a log of those transitions
+func migratePeerPresence(tx *sql.Tx) error {
+	if err := addColumnIfMissing(tx, "peers", "status_changed_at", "DATETIME"); err != nil {
+		return err
+	}
+
+	_, err := tx.Exec(`
+		CREATE TABLE IF NOT EXISTS peer_presence (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			peer_id TEXT NOT NULL,
+			is_online BOOLEAN NOT NULL,
+			changed_at DATETIME NOT NULL
+		);
+
+		CREATE INDEX IF NOT EXISTS idx_peer_presence_peer ON peer_presence(peer_id, id);
+	`)
+	return err
+}
+
+// migrateContacts adds the local nickname, notes, favourite and hidden
+// flags to peers, and a table of contact tags
+func migrateContacts(tx *sql.Tx) error {
+	columns := []struct{ name, definition string }{
+		{"nickname", "TEXT NOT NULL DEFAULT ''"},
+		{"notes", "TEXT NOT NULL DEFAULT ''"},
+		{"favorite", "BOOLEAN NOT NULL DEFAULT 0"},
+		{"hidden", "BOOLEAN NOT NULL DEFAULT 0"},
+	}
+	for _, column := range columns {
+		if err := addColumnIfMissing(tx, "peers", column.name, column.definition); err != nil {
+			return err
+		}
+	}
+
+	_, err := tx.Exec(`
+		CREATE TABLE IF NOT EXISTS contact_tags (
+			peer_id TEXT NOT NULL,
+			tag TEXT NOT NULL COLLATE NOCASE,
+			PRIMARY KEY (peer_id, tag)
+		);
+
+		CREATE INDEX IF NOT EXISTS idx_contact_tags_tag ON contact_tags(tag);
+	`)
+	return err
+}
+
+// migrateReactions adds emoji reactions, keyed by the sender-assigned
+// message ID so reactions arriving before their message are kept
+func migrateReactions(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+		CREATE TABLE IF NOT EXISTS reactions (
+			message_id TEXT NOT NULL,
+			peer_id TEXT NOT NULL,
+			emoji TEXT NOT NULL,
+			active BOOLEAN NOT NULL,
+			updated_at INTEGER NOT NULL,
+			PRIMARY KEY (message_id, peer_id, emoji)
+		)
+	`)
+	return err
+}
+
+// migrateThreads records which message a reply answers, by its
+// sender-assigned ID so replies can arrive before their parent
+func migrateThreads(tx *sql.Tx) error {
+	if err := addColumnIfMissing(tx, "messages", "parent_id", "TEXT"); err != nil {
+		return err
+	}
+
+	_, err := tx.Exec(`CREATE INDEX IF NOT EXISTS idx_messages_parent ON messages(parent_id)`)
+	return err
+}
+
+// migratePinsAndStars adds pins, shared with the conversation and keyed by
+// the sender-assigned message ID like reactions, and local stars
+func migratePinsAndStars(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+		CREATE TABLE IF NOT EXISTS pins (
+			message_id TEXT PRIMARY KEY,
+			changed_by TEXT NOT NULL,
+			active BOOLEAN NOT NULL,
+			updated_at INTEGER NOT NULL
+		);
+
+		CREATE TABLE IF NOT EXISTS stars (
+			message_row_id INTEGER PRIMARY KEY REFERENCES messages(id) ON DELETE CASCADE,
+			starred_at DATETIME NOT NULL
+		);
+	`)
+	return err
+}
+
+// migrateDrafts adds one unsent draft per conversation. Drafts have a row
+// ID like messages so their content is re-keyed the same way.
+func migrateDrafts(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+		CREATE TABLE IF NOT EXISTS drafts (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			conversation_id TEXT NOT NULL UNIQUE,
+			content TEXT NOT NULL,
+			updated_at DATETIME NOT NULL
+		)
+	`)
+	return err
+}
+
+// migrateUTCTimestamps rewrites message times stored with a local zone
+// offset, before they were stored in UTC. Times are compared as text, so
+// those rows sorted and filtered hours off.
+func migrateUTCTimestamps(tx *sql.Tx) error {
+	columns := []struct{ table, column string }{
+		{"messages", "timestamp"},
+		{"messages", "edited_at"},
+		{"messages", "deleted_at"},
+		// Backfilled from message timestamps by migrateConversations
+		{"conversations", "created_at"},
+		{"conversations", "last_activity"},
+	}
+	for _, c := range columns {
+		if err := rewriteUTC(tx, c.table, c.column); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rewriteUTC converts the times in one column to UTC
+func rewriteUTC(tx *sql.Tx, table, column string) error {
+	rows, err := tx.Query(fmt.Sprintf(`SELECT rowid, %s FROM %s WHERE %s IS NOT NULL`, column, table, column))
+	if err != nil {
+		return fmt.Errorf("failed to query %s.%s: %w", table, column, err)
+	}
+
+	local := make(map[int64]time.Time)
+	for rows.Next() {
+		var rowID int64
+		var value interface{}
+		if err := rows.Scan(&rowID, &value); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan %s.%s: %w", table, column, err)
+		}
+		// Values that don't parse as times are left alone
+		if t, ok := value.(time.Time); ok {
+			if _, offset := t.Zone(); offset != 0 {
+				local[rowID] = t
+			}
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to read %s.%s: %w", table, column, err)
+	}
+
+	for rowID, t := range local {
+		_, err := tx.Exec(fmt.Sprintf(`UPDATE %s SET %s = ? WHERE rowid = ?`, table, column), t.UTC(), rowID)
+		if err != nil {
+			return fmt.Errorf("failed to update %s.%s: %w", table, column, err)
+		}
+	}
+	return nil
+}
+
+// migrateSearchIndex creates the FTS5 index when SQLite was built with
+// FTS5 and fills it with existing history. Encrypted stores get none, as
+// it would keep plaintext on disk; without FTS5, search scans instead.
+func migrateSearchIndex(tx *sql.Tx) error {
+	var encrypted, existing int
+	err := tx.QueryRow(`SELECT COUNT(*) FROM meta WHERE key = 'crypto.check'`).Scan(&encrypted)
+	if err != nil {
+		return fmt.Errorf("failed to read encryption state: %w", err)
+	}
+	if encrypted > 0 {
+		return nil
+	}
+	// Earlier versions created the index outside migrations
+	err = tx.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE name = 'messages_fts'`).Scan(&existing)
+	if err != nil {
+		return fmt.Errorf("failed to inspect search index: %w", err)
+	}
+	if existing > 0 {
+		return nil
+	}
+
+	_, err = tx.Exec(`
+		CREATE VIRTUAL TABLE messages_fts
+		USING fts5(content, tokenize = 'unicode61 remove_diacritics 2')
+	`)
+	if err != nil {
+		log.Printf("Full-text search unavailable, falling back to scanning: %v", err)
+		return nil
+	}
+
+	_, err = tx.Exec(`INSERT INTO messages_fts (rowid, content) SELECT id, content FROM messages WHERE deleted_at IS NULL`)
+	return err
+}
+
+// migrateMemberVersions versions each group's member list, so the newest
+// list a member sends can replace an older one, removals included
+func migrateMemberVersions(tx *sql.Tx) error {
+	return addColumnIfMissing(tx, "conversations", "members_changed_at", "INTEGER NOT NULL DEFAULT 0")
+}
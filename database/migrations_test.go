@@ -0,0 +1,143 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+// writeBaseline creates a database file with only the original messages
+// and peers tables, as written before schema versioning, holding a peer
+// and two messages. version records it as migrated that far; zero leaves
+// no schema_migrations table at all.
+func writeBaseline(t *testing.T, path string, version int) {
+	t.Helper()
+
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatalf("failed to create database: %v", err)
+	}
+	defer db.Close()
+
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+
+	if err := migrateInitialSchema(tx); err != nil {
+		t.Fatalf("failed to create baseline schema: %v", err)
+	}
+	statements := []string{
+		`INSERT INTO peers (peer_id, name, ip_address) VALUES ('peer', 'Peer', '192.0.2.1')`,
+		`INSERT INTO messages (peer_id, sender_id, content, timestamp, is_read) VALUES ('peer', 'peer', 'hello', '2025-06-01 10:00:00', 0)`,
+		`INSERT INTO messages (peer_id, sender_id, content, timestamp, is_read) VALUES ('peer', 'me', 'hi back', '2025-06-01 10:01:00', 1)`,
+	}
+	if version > 0 {
+		statements = append(statements,
+			`CREATE TABLE schema_migrations (version INTEGER PRIMARY KEY, name TEXT NOT NULL, applied_at DATETIME DEFAULT CURRENT_TIMESTAMP)`)
+	}
+	for _, statement := range statements {
+		if _, err := tx.Exec(statement); err != nil {
+			t.Fatalf("failed to write baseline: %v", err)
+		}
+	}
+	for v := 1; v <= version; v++ {
+		if _, err := tx.Exec(`INSERT INTO schema_migrations (version, name) VALUES (?, 'baseline')`, v); err != nil {
+			t.Fatalf("failed to write baseline: %v", err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMigrationsFromBaseline(t *testing.T) {
+	tests := []struct {
+		name    string
+		version int
+	}{
+		{"before versioning", 0},
+		{"versioned baseline", 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "baseline.db")
+			writeBaseline(t, path, tt.version)
+
+			d, err := NewDatabase(path)
+			if err != nil {
+				t.Fatalf("failed to migrate: %v", err)
+			}
+			defer d.Close()
+
+			version, err := d.SchemaVersion()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if version != latestSchemaVersion() {
+				t.Fatalf("got schema version %d, want %d", version, latestSchemaVersion())
+			}
+
+			messages, err := d.GetMessageHistory("peer", 10)
+			if err != nil {
+				t.Fatalf("failed to read migrated history: %v", err)
+			}
+			if len(messages) != 2 || messages[0].Content != "hello" || messages[1].Content != "hi back" {
+				t.Fatalf("got %+v, want both baseline messages in order", messages)
+			}
+			for _, msg := range messages {
+				if msg.MessageID == "" {
+					t.Errorf("message %d has no message ID", msg.ID)
+				}
+				if msg.ConversationID != DirectConversationID("peer") {
+					t.Errorf("message %d is in %q, want the direct conversation", msg.ID, msg.ConversationID)
+				}
+			}
+
+			conversation, err := d.GetConversation(DirectConversationID("peer"))
+			if err != nil {
+				t.Fatalf("failed to load migrated conversation: %v", err)
+			}
+			if conversation.UnreadCount != 1 {
+				t.Errorf("got %d unread, want 1", conversation.UnreadCount)
+			}
+
+			backups, err := filepath.Glob(path + ".pre-v*.bak")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(backups) != 1 {
+				t.Fatalf("got backups %v, want one safety copy", backups)
+			}
+		})
+	}
+}
+
+func TestMigrationsFreshDatabaseSkipsBackup(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "fresh.db")
+	d, err := NewDatabase(path)
+	if err != nil {
+		t.Fatalf("failed to create database: %v", err)
+	}
+	defer d.Close()
+
+	backups, err := filepath.Glob(path + ".pre-v*.bak")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backups) != 0 {
+		t.Fatalf("got backups %v of an empty database", backups)
+	}
+}
+
+func TestMigrationsRefuseNewerSchema(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "newer.db")
+	writeBaseline(t, path, latestSchemaVersion()+1)
+
+	if _, err := NewDatabase(path); !errors.Is(err, ErrSchemaTooNew) {
+		t.Fatalf("got %v, want %v", err, ErrSchemaTooNew)
+	}
+}
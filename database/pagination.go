@@ -0,0 +1,199 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"math"
+	"time"
+)
+
+// maxPageSize caps how many messages a single page may return
+const maxPageSize = 200
+
+// HistoryCursor selects a page of history relative to a message or time.
+// Set at most one of BeforeID, AfterID, Before or After; with none set
+// the newest messages are returned. Messages are ordered by timestamp and
+// then row ID, so pages stay stable when timestamps collide.
+type HistoryCursor struct {
+	BeforeID int64     `json:"before_id,omitempty"`
+	AfterID  int64     `json:"after_id,omitempty"`
+	Before   time.Time `json:"before,omitzero"`
+	After    time.Time `json:"after,omitzero"`
+	Limit    int       `json:"limit,omitempty"`
+}
+
+// HistoryPage is a chronological slice of history. HasBefore and HasAfter
+// report whether older or newer messages exist beyond the page; the first
+// and last message IDs are the cursors for fetching them.
+type HistoryPage struct {
+	Messages  []Message `json:"messages"`
+	HasBefore bool      `json:"has_before"`
+	HasAfter  bool      `json:"has_after"`
+}
+
+// GetMessagesPage returns one page of a conversation's history
+func (d *Database) GetMessagesPage(conversationID string, cursor HistoryCursor) (HistoryPage, error) {
+	limit := pageLimit(cursor.Limit)
+	if d.IsLocked() {
+		return HistoryPage{}, ErrLocked
+	}
+
+	var page HistoryPage
+	var err error
+
+	switch {
+	case cursor.AfterID != 0:
+		var more bool
+		page.Messages, more, err = d.queryNewer(conversationID, `(SELECT timestamp FROM messages WHERE id = ?), ?`,
+			[]interface{}{cursor.AfterID, cursor.AfterID}, limit)
+		page.HasAfter, page.HasBefore = more, true
+	case !cursor.After.IsZero():
+		var more bool
+		page.Messages, more, err = d.queryNewer(conversationID, `?, ?`,
+			[]interface{}{cursor.After.UTC(), int64(math.MaxInt64)}, limit)
+		page.HasAfter = more
+		if err == nil {
+			page.HasBefore, err = d.rowExists(`SELECT 1 FROM messages WHERE conversation_id = ? AND timestamp <= ? LIMIT 1`,
+				conversationID, cursor.After.UTC())
+		}
+	case cursor.BeforeID != 0:
+		var more bool
+		page.Messages, more, err = d.queryOlder(conversationID, `(SELECT timestamp FROM messages WHERE id = ?), ?`,
+			[]interface{}{cursor.BeforeID, cursor.BeforeID}, limit)
+		page.HasBefore, page.HasAfter = more, true
+	case !cursor.Before.IsZero():
+		var more bool
+		page.Messages, more, err = d.queryOlder(conversationID, `?, ?`,
+			[]interface{}{cursor.Before.UTC(), int64(0)}, limit)
+		page.HasBefore = more
+		if err == nil {
+			page.HasAfter, err = d.rowExists(`SELECT 1 FROM messages WHERE conversation_id = ? AND timestamp >= ? LIMIT 1`,
+				conversationID, cursor.Before.UTC())
+		}
+	default:
+		var more bool
+		page.Messages, more, err = d.queryOlder(conversationID, `?, ?`,
+			[]interface{}{maxTimestamp, int64(math.MaxInt64)}, limit)
+		page.HasBefore = more
+	}
+
+	if err != nil {
+		return HistoryPage{}, err
+	}
+	return page, nil
+}
+
+// GetMessageContext returns a message together with up to radius messages
+// on either side of it, for jumping to a search result
+func (d *Database) GetMessageContext(messageID int64, radius int) (HistoryPage, error) {
+	radius = pageLimit(radius)
+	if d.IsLocked() {
+		return HistoryPage{}, ErrLocked
+	}
+
+	var conversationID string
+	err := d.conn().db.QueryRow(`SELECT conversation_id FROM messages WHERE id = ?`, messageID).Scan(&conversationID)
+	if err == sql.ErrNoRows {
+		return HistoryPage{}, fmt.Errorf("message %d not found", messageID)
+	}
+	if err != nil {
+		return HistoryPage{}, fmt.Errorf("failed to load message: %w", err)
+	}
+
+	key := `(SELECT timestamp FROM messages WHERE id = ?), ?`
+	older, hasBefore, err := d.queryOlder(conversationID, key, []interface{}{messageID, messageID}, radius)
+	if err != nil {
+		return HistoryPage{}, err
+	}
+	// Newer messages start at the target itself so it is included
+	newer, hasAfter, err := d.queryNewer(conversationID, key, []interface{}{messageID, messageID - 1}, radius+1)
+	if err != nil {
+		return HistoryPage{}, err
+	}
+
+	return HistoryPage{
+		Messages:  append(older, newer...),
+		HasBefore: hasBefore,
+		HasAfter:  hasAfter,
+	}, nil
+}
+
+// maxTimestamp sorts after every stored timestamp
+const maxTimestamp = "9999-12-31"
+
+// pageLimit clamps a requested page size
+func pageLimit(limit int) int {
+	if limit <= 0 {
+		return 50
+	}
+	return min(limit, maxPageSize)
+}
+
+// queryOlder returns up to limit messages strictly before the (timestamp,
+// id) key in chronological order, and whether more exist beyond them
+func (d *Database) queryOlder(conversationID, key string, keyArgs []interface{}, limit int) ([]Message, bool, error) {
+	query := `
+		SELECT ` + messageColumns + `
+		FROM messages
+		WHERE conversation_id = ? AND (timestamp, id) < (` + key + `)
+		ORDER BY timestamp DESC, id DESC
+		LIMIT ?
+	`
+
+	messages, more, err := d.queryPage(query, conversationID, keyArgs, limit)
+	reverseMessages(messages)
+	return messages, more, err
+}
+
+// queryNewer returns up to limit messages strictly after the (timestamp,
+// id) key in chronological order, and whether more exist beyond them
+func (d *Database) queryNewer(conversationID, key string, keyArgs []interface{}, limit int) ([]Message, bool, error) {
+	query := `
+		SELECT ` + messageColumns + `
+		FROM messages
+		WHERE conversation_id = ? AND (timestamp, id) > (` + key + `)
+		ORDER BY timestamp ASC, id ASC
+		LIMIT ?
+	`
+
+	return d.queryPage(query, conversationID, keyArgs, limit)
+}
+
+// queryPage fetches limit+1 rows to learn whether another page exists
+func (d *Database) queryPage(query, conversationID string, keyArgs []interface{}, limit int) ([]Message, bool, error) {
+	args := append([]interface{}{conversationID}, keyArgs...)
+	args = append(args, limit+1)
+
+	rows, err := d.conn().db.Query(query, args...)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to query messages: %w", err)
+	}
+	defer rows.Close()
+
+	messages, err := d.scanMessages(rows)
+	if err != nil {
+		return nil, false, err
+	}
+	rows.Close()
+	if err := d.attachDetails(messages); err != nil {
+		return nil, false, err
+	}
+
+	if len(messages) > limit {
+		return messages[:limit], true, nil
+	}
+	return messages, false, nil
+}
+
+// rowExists reports whether a query returns any row
+func (d *Database) rowExists(query string, args ...interface{}) (bool, error) {
+	var one int
+	err := d.conn().db.QueryRow(query, args...).Scan(&one)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to query messages: %w", err)
+	}
+	return true, nil
+}
@@ -0,0 +1,136 @@
+package database
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestGetMessagesPageBoundaries(t *testing.T) {
+	d := newTestDatabase(t)
+	start := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	ids := insertMessages(t, d, "peer", start, 5)
+	conversationID := DirectConversationID("peer")
+
+	tests := []struct {
+		name       string
+		cursor     HistoryCursor
+		want       []int64
+		wantBefore bool
+		wantAfter  bool
+	}{
+		{"newest", HistoryCursor{Limit: 2}, ids[3:], true, false},
+		{"newest exactly fills the page", HistoryCursor{Limit: 5}, ids, false, false},
+		{"before the oldest page", HistoryCursor{BeforeID: ids[2], Limit: 2}, ids[:2], false, true},
+		{"before a middle page", HistoryCursor{BeforeID: ids[3], Limit: 2}, ids[1:3], true, true},
+		{"before the first message", HistoryCursor{BeforeID: ids[0], Limit: 2}, nil, false, true},
+		{"after a middle page", HistoryCursor{AfterID: ids[1], Limit: 2}, ids[2:4], true, true},
+		{"after the newest page", HistoryCursor{AfterID: ids[2], Limit: 2}, ids[3:], true, false},
+		{"after the last message", HistoryCursor{AfterID: ids[4], Limit: 2}, nil, true, false},
+		{"before a time excludes it", HistoryCursor{Before: start.Add(2 * time.Second)}, ids[:2], false, true},
+		{"after a time excludes it", HistoryCursor{After: start.Add(2 * time.Second)}, ids[3:], true, false},
+		{"before a time in another zone", HistoryCursor{Before: start.Add(2 * time.Second).In(time.FixedZone("UTC+5", 5*3600))}, ids[:2], false, true},
+		{"after every message", HistoryCursor{After: start.Add(time.Hour)}, nil, true, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			page, err := d.GetMessagesPage(conversationID, tt.cursor)
+			if err != nil {
+				t.Fatalf("failed to get page: %v", err)
+			}
+			assertPage(t, page, tt.want, tt.wantBefore, tt.wantAfter)
+		})
+	}
+}
+
+func TestGetMessagesPageEqualTimestamps(t *testing.T) {
+	d := newTestDatabase(t)
+	at := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	// Messages sharing a timestamp are paged by row ID, so none is skipped
+	// or repeated at a page boundary
+	var ids []int64
+	for range 4 {
+		id, err := d.InsertMessage(Message{PeerID: "peer", SenderID: "peer", Content: "same time", Timestamp: at})
+		if err != nil {
+			t.Fatalf("failed to insert message: %v", err)
+		}
+		ids = append(ids, id)
+	}
+	conversationID := DirectConversationID("peer")
+
+	var seen []int64
+	cursor := HistoryCursor{Limit: 1}
+	for {
+		page, err := d.GetMessagesPage(conversationID, cursor)
+		if err != nil {
+			t.Fatalf("failed to get page: %v", err)
+		}
+		if len(page.Messages) != 1 {
+			t.Fatalf("got %d messages, want 1", len(page.Messages))
+		}
+		seen = append([]int64{page.Messages[0].ID}, seen...)
+		if !page.HasBefore {
+			break
+		}
+		cursor.BeforeID = page.Messages[0].ID
+	}
+	assertIDs(t, seen, ids)
+
+	page, err := d.GetMessagesPage(conversationID, HistoryCursor{AfterID: ids[1], Limit: 10})
+	if err != nil {
+		t.Fatalf("failed to get page: %v", err)
+	}
+	assertPage(t, page, ids[2:], true, false)
+}
+
+func TestHistoryCursorJSON(t *testing.T) {
+	// A zero time isn't empty to omitempty, so a zero cursor used to carry
+	// both time bounds as 0001-01-01
+	data, err := json.Marshal(HistoryCursor{})
+	if err != nil {
+		t.Fatalf("failed to encode cursor: %v", err)
+	}
+	if string(data) != "{}" {
+		t.Fatalf("got %s, want {}", data)
+	}
+
+	var cursor HistoryCursor
+	if err := json.Unmarshal(data, &cursor); err != nil {
+		t.Fatalf("failed to decode cursor: %v", err)
+	}
+	if cursor != (HistoryCursor{}) {
+		t.Fatalf("got %+v, want the zero cursor", cursor)
+	}
+}
+
+// assertPage checks a page's messages and its has_before/has_after flags
+func assertPage(t *testing.T, page HistoryPage, want []int64, wantBefore, wantAfter bool) {
+	t.Helper()
+
+	var got []int64
+	for _, msg := range page.Messages {
+		got = append(got, msg.ID)
+	}
+	assertIDs(t, got, want)
+	if page.HasBefore != wantBefore {
+		t.Errorf("got has_before %v, want %v", page.HasBefore, wantBefore)
+	}
+	if page.HasAfter != wantAfter {
+		t.Errorf("got has_after %v, want %v", page.HasAfter, wantAfter)
+	}
+}
+
+func assertIDs(t *testing.T, got, want []int64) {
+	t.Helper()
+
+	if len(got) != len(want) {
+		t.Fatalf("got messages %v, want %v", got, want)
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			t.Fatalf("got messages %v, want %v", got, want)
+		}
+	}
+}
@@ -0,0 +1,239 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// maxPresenceChanges bounds how many presence changes are kept per peer
+const maxPresenceChanges = 100
+
+// Peer represents a chat peer/contact. StatusChangedAt is when the peer
+// last came online or went offline.
+type Peer struct {
+	ContactDetails
+
+	ID              int64      `json:"id"`
+	PeerID          string     `json:"peer_id"`
+	Name            string     `json:"name"`
+	IPAddress       string     `json:"ip_address"`
+	LastSeen        time.Time  `json:"last_seen"`
+	IsOnline        bool       `json:"is_online"`
+	StatusChangedAt *time.Time `json:"status_changed_at,omitempty"`
+	CreatedAt       time.Time  `json:"created_at"`
+}
+
+// PresenceChange is a peer coming online or going offline
+type PresenceChange struct {
+	IsOnline  bool      `json:"is_online"`
+	ChangedAt time.Time `json:"changed_at"`
+}
+
+// SavePeer records a peer seen on the network, marking it online. A peer
+// that was offline or unknown is logged as having come online.
+func (d *Database) SavePeer(peerID, name, ipAddress string) error {
+	now := time.Now().UTC()
+
+	err := d.withTx(func(tx *sql.Tx) error {
+		var wasOnline bool
+		err := tx.QueryRow(`SELECT is_online FROM peers WHERE peer_id = ?`, peerID).Scan(&wasOnline)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+
+		_, err = tx.Exec(`
+			INSERT INTO peers (peer_id, name, ip_address, last_seen, is_online, status_changed_at)
+			VALUES (?, ?, ?, ?, 1, ?)
+			ON CONFLICT(peer_id) DO UPDATE SET
+				name = excluded.name,
+				ip_address = excluded.ip_address,
+				last_seen = excluded.last_seen,
+				is_online = 1,
+				status_changed_at = CASE WHEN peers.is_online THEN peers.status_changed_at
+					ELSE excluded.status_changed_at END
+		`, peerID, name, ipAddress, now, now)
+		if err != nil {
+			return err
+		}
+
+		if wasOnline {
+			return nil
+		}
+		return recordPresence(tx, peerID, true, now)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to save peer: %w", err)
+	}
+
+	return nil
+}
+
+// GetPeers retrieves all peers from the database
+func (d *Database) GetPeers() ([]Peer, error) {
+	query := `
+		SELECT id, peer_id, name, ip_address, last_seen, is_online, status_changed_at, created_at,
+			nickname, notes, favorite, hidden
+		FROM peers
+		ORDER BY last_seen DESC
+	`
+
+	rows, err := d.conn().db.Query(query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query peers: %w", err)
+	}
+	defer rows.Close()
+
+	var peers []Peer
+	for rows.Next() {
+		var peer Peer
+		var changedAt sql.NullTime
+		err := rows.Scan(&peer.ID, &peer.PeerID, &peer.Name, &peer.IPAddress,
+			&peer.LastSeen, &peer.IsOnline, &changedAt, &peer.CreatedAt,
+			&peer.Nickname, &peer.Notes, &peer.Favorite, &peer.Hidden)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan peer: %w", err)
+		}
+		if changedAt.Valid {
+			peer.StatusChangedAt = &changedAt.Time
+		}
+		if peer.Nickname, err = d.openText(peer.Nickname); err != nil {
+			return nil, err
+		}
+		if peer.Notes, err = d.openText(peer.Notes); err != nil {
+			return nil, err
+		}
+		peers = append(peers, peer)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := d.loadContactTags(peers); err != nil {
+		return nil, err
+	}
+	return peers, nil
+}
+
+// UpdatePeerStatus marks a known peer online or offline, logging the
+// change if its status differs. Going offline keeps last_seen as the
+// last time the peer was actually seen.
+func (d *Database) UpdatePeerStatus(peerID string, isOnline bool) error {
+	now := time.Now().UTC()
+
+	err := d.withTx(func(tx *sql.Tx) error {
+		query := `UPDATE peers SET is_online = 0, status_changed_at = ? WHERE peer_id = ? AND is_online = 1`
+		if isOnline {
+			query = `UPDATE peers SET is_online = 1, status_changed_at = ?1, last_seen = ?1
+				WHERE peer_id = ?2 AND is_online = 0`
+		}
+
+		result, err := tx.Exec(query, now, peerID)
+		if err != nil {
+			return err
+		}
+		if affected, _ := result.RowsAffected(); affected == 0 {
+			return nil
+		}
+		return recordPresence(tx, peerID, isOnline, now)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to update peer status: %w", err)
+	}
+
+	return nil
+}
+
+// MarkAllPeersOffline marks every peer offline, as of when each was last
+// seen. Used at startup, since nobody is known to be online until they
+// announce themselves again.
+func (d *Database) MarkAllPeersOffline() error {
+	err := d.withTx(func(tx *sql.Tx) error {
+		rows, err := tx.Query(`SELECT peer_id, last_seen FROM peers WHERE is_online = 1`)
+		if err != nil {
+			return err
+		}
+
+		lastSeen := make(map[string]time.Time)
+		for rows.Next() {
+			var peerID string
+			var seen time.Time
+			if err := rows.Scan(&peerID, &seen); err != nil {
+				rows.Close()
+				return err
+			}
+			lastSeen[peerID] = seen
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		for peerID, seen := range lastSeen {
+			_, err := tx.Exec(`UPDATE peers SET is_online = 0, status_changed_at = ? WHERE peer_id = ?`, seen, peerID)
+			if err != nil {
+				return err
+			}
+			if err := recordPresence(tx, peerID, false, seen); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to reset peer status: %w", err)
+	}
+
+	return nil
+}
+
+// GetPeerPresence returns a peer's most recent presence changes, newest first
+func (d *Database) GetPeerPresence(peerID string, limit int) ([]PresenceChange, error) {
+	if limit <= 0 || limit > maxPresenceChanges {
+		limit = maxPresenceChanges
+	}
+
+	rows, err := d.conn().db.Query(`
+		SELECT is_online, changed_at FROM peer_presence
+		WHERE peer_id = ?
+		ORDER BY id DESC
+		LIMIT ?
+	`, peerID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query peer presence: %w", err)
+	}
+	defer rows.Close()
+
+	changes := []PresenceChange{}
+	for rows.Next() {
+		var change PresenceChange
+		if err := rows.Scan(&change.IsOnline, &change.ChangedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan peer presence: %w", err)
+		}
+		changes = append(changes, change)
+	}
+
+	return changes, rows.Err()
+}
+
+// recordPresence logs a presence change, keeping only the newest
+// maxPresenceChanges for the peer
+func recordPresence(tx *sql.Tx, peerID string, isOnline bool, at time.Time) error {
+	_, err := tx.Exec(`INSERT INTO peer_presence (peer_id, is_online, changed_at) VALUES (?, ?, ?)`,
+		peerID, isOnline, at)
+	if err != nil {
+		return fmt.Errorf("failed to record peer presence: %w", err)
+	}
+
+	_, err = tx.Exec(`
+		DELETE FROM peer_presence WHERE peer_id = ? AND id <= (
+			SELECT id FROM peer_presence WHERE peer_id = ?
+			ORDER BY id DESC LIMIT 1 OFFSET ?
+		)
+	`, peerID, peerID, maxPresenceChanges)
+	if err != nil {
+		return fmt.Errorf("failed to trim peer presence: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,236 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// PinnedMessage is a message pinned for everyone in its conversation
+type PinnedMessage struct {
+	Message
+	PinnedBy string    `json:"pinned_by"`
+	PinnedAt time.Time `json:"pinned_at"`
+}
+
+// StarredMessage is a message we starred for ourselves
+type StarredMessage struct {
+	Message
+	StarredAt time.Time `json:"starred_at"`
+}
+
+// ErrNotMember is returned when a peer changes a conversation it isn't a
+// member of
+var ErrNotMember = errors.New("peer is not a member of the conversation")
+
+// ApplyPin records a peer pinning or unpinning a message. Like reactions,
+// the latest change by timestamp wins, with unpinning winning a tie and
+// then the higher peer ID, so every member ends up with the same pins.
+// A change older than what is stored returns ErrDuplicateMessage.
+// Pins of messages that aren't stored or were deleted are refused, and
+// incoming ones are only accepted from members of the conversation.
+func (d *Database) ApplyPin(messageID, peerID string, unpinned bool, at time.Time, incoming bool) error {
+	return d.withTx(func(tx *sql.Tx) error {
+		if err := checkMarkTarget(tx, messageID, peerID, incoming); err != nil {
+			return err
+		}
+
+		applied, err := mergePin(tx, messageID, peerID, !unpinned, at.UnixNano())
+		if err != nil {
+			return err
+		}
+		if !applied {
+			return ErrDuplicateMessage
+		}
+		return nil
+	})
+}
+
+// mergePin stores a pin state unless a newer one is stored, reporting
+// whether it was applied
+func mergePin(tx *sql.Tx, messageID, changedBy string, active bool, updatedAt int64) (bool, error) {
+	result, err := tx.Exec(`
+		INSERT INTO pins (message_id, changed_by, active, updated_at)
+		VALUES (?, ?, ?, ?)
+		ON CONFLICT(message_id) DO UPDATE SET
+			changed_by = excluded.changed_by,
+			active = excluded.active,
+			updated_at = excluded.updated_at
+		WHERE excluded.updated_at > pins.updated_at
+			OR (excluded.updated_at = pins.updated_at AND (excluded.active < pins.active
+				OR (excluded.active = pins.active AND excluded.changed_by > pins.changed_by)))
+	`, messageID, changedBy, active, updatedAt)
+	if err != nil {
+		return false, fmt.Errorf("failed to save pin: %w", err)
+	}
+	affected, _ := result.RowsAffected()
+	return affected > 0, nil
+}
+
+// GetPinnedMessages returns the messages pinned in a conversation, most
+// recently pinned first
+func (d *Database) GetPinnedMessages(conversationID string) ([]PinnedMessage, error) {
+	if d.IsLocked() {
+		return nil, ErrLocked
+	}
+
+	rows, err := d.conn().db.Query(`
+		SELECT `+aliasedMessageColumns+`, p.changed_by, p.updated_at
+		FROM pins p JOIN messages m ON m.message_id = p.message_id
+		WHERE p.active = 1 AND m.conversation_id = ? AND m.deleted_at IS NULL
+		ORDER BY p.updated_at DESC
+	`, conversationID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query pinned messages: %w", err)
+	}
+	defer rows.Close()
+
+	var messages []Message
+	var pins []PinnedMessage
+	for rows.Next() {
+		var pin PinnedMessage
+		var pinnedAt int64
+		if pin.Message, err = d.scanMessage(rows, &pin.PinnedBy, &pinnedAt); err != nil {
+			return nil, err
+		}
+		pin.PinnedAt = time.Unix(0, pinnedAt).UTC()
+		messages = append(messages, pin.Message)
+		pins = append(pins, pin)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	if err := d.attachDetails(messages); err != nil {
+		return nil, err
+	}
+	for i := range pins {
+		pins[i].Message = messages[i]
+	}
+	if pins == nil {
+		pins = []PinnedMessage{}
+	}
+	return pins, nil
+}
+
+// StarMessage stars or unstars a message by row ID. Stars are private and
+// never leave this device.
+func (d *Database) StarMessage(id int64, starred bool) error {
+	return d.withTx(func(tx *sql.Tx) error {
+		var deletedAt sql.NullTime
+		err := tx.QueryRow(`SELECT deleted_at FROM messages WHERE id = ?`, id).Scan(&deletedAt)
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrMessageNotFound
+		}
+		if err != nil {
+			return fmt.Errorf("failed to load message: %w", err)
+		}
+
+		if !starred {
+			_, err = tx.Exec(`DELETE FROM stars WHERE message_row_id = ?`, id)
+		} else if deletedAt.Valid {
+			return ErrMessageDeleted
+		} else {
+			_, err = tx.Exec(`INSERT INTO stars (message_row_id, starred_at) VALUES (?, ?) ON CONFLICT DO NOTHING`,
+				id, time.Now().UTC())
+		}
+		if err != nil {
+			return fmt.Errorf("failed to save star: %w", err)
+		}
+		return nil
+	})
+}
+
+// GetStarredMessages returns our starred messages from every conversation,
+// most recently starred first
+func (d *Database) GetStarredMessages() ([]StarredMessage, error) {
+	if d.IsLocked() {
+		return nil, ErrLocked
+	}
+
+	rows, err := d.conn().db.Query(`
+		SELECT ` + aliasedMessageColumns + `, s.starred_at
+		FROM stars s JOIN messages m ON m.id = s.message_row_id
+		ORDER BY s.starred_at DESC, m.id DESC
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query starred messages: %w", err)
+	}
+	defer rows.Close()
+
+	var messages []Message
+	var stars []StarredMessage
+	for rows.Next() {
+		var star StarredMessage
+		if star.Message, err = d.scanMessage(rows, &star.StarredAt); err != nil {
+			return nil, err
+		}
+		messages = append(messages, star.Message)
+		stars = append(stars, star)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	if err := d.attachDetails(messages); err != nil {
+		return nil, err
+	}
+	for i := range stars {
+		stars[i].Message = messages[i]
+	}
+	if stars == nil {
+		stars = []StarredMessage{}
+	}
+	return stars, nil
+}
+
+// attachMarks flags which messages are pinned or starred
+func (d *Database) attachMarks(messages []Message) error {
+	var ids []interface{}
+	for _, msg := range messages {
+		if msg.DeletedAt == nil {
+			ids = append(ids, msg.ID)
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(ids)), ", ")
+	rows, err := d.conn().db.Query(`
+		SELECT m.id, p.active IS NOT NULL AND p.active, s.message_row_id IS NOT NULL
+		FROM messages m
+		LEFT JOIN pins p ON p.message_id = m.message_id
+		LEFT JOIN stars s ON s.message_row_id = m.id
+		WHERE m.id IN (`+placeholders+`)
+	`, ids...)
+	if err != nil {
+		return fmt.Errorf("failed to query pins and stars: %w", err)
+	}
+	defer rows.Close()
+
+	type marks struct{ pinned, starred bool }
+	byID := make(map[int64]marks, len(ids))
+	for rows.Next() {
+		var id int64
+		var m marks
+		if err := rows.Scan(&id, &m.pinned, &m.starred); err != nil {
+			return fmt.Errorf("failed to scan pins and stars: %w", err)
+		}
+		byID[id] = m
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for i := range messages {
+		m := byID[messages[i].ID]
+		messages[i].Pinned = m.pinned
+		messages[i].Starred = m.starred
+	}
+	return nil
+}
@@ -0,0 +1,138 @@
+package database
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestApplyPin(t *testing.T) {
+	base := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	type change struct {
+		peer     string
+		unpinned bool
+		at       time.Time
+		want     error
+	}
+	tests := []struct {
+		name     string
+		changes  []change
+		pinnedBy string
+	}{
+		{"pin", []change{{"peer", false, base, nil}}, "peer"},
+		{"pin then unpin", []change{{"peer", false, base, nil}, {"me", true, base.Add(time.Second), nil}}, ""},
+		{"late older pin is ignored", []change{{"me", true, base.Add(time.Second), nil}, {"peer", false, base, ErrDuplicateMessage}}, ""},
+		{"unpin wins a tie", []change{{"peer", false, base, nil}, {"me", true, base, nil}}, ""},
+		{"higher peer wins a tied pin", []change{{"me", false, base, nil}, {"peer", false, base, nil}}, "peer"},
+		{"lower peer loses a tied pin", []change{{"peer", false, base, nil}, {"me", false, base, ErrDuplicateMessage}}, "peer"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := newTestDatabase(t)
+			msg := firstMessage(t, d)
+			for i, c := range tt.changes {
+				if err := d.ApplyPin(msg.MessageID, c.peer, c.unpinned, c.at, false); !errors.Is(err, c.want) {
+					t.Fatalf("change %d: got %v, want %v", i, err, c.want)
+				}
+			}
+
+			pins, err := d.GetPinnedMessages(msg.ConversationID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			switch {
+			case tt.pinnedBy == "" && len(pins) != 0:
+				t.Fatalf("got pins %+v, want none", pins)
+			case tt.pinnedBy != "" && (len(pins) != 1 || pins[0].PinnedBy != tt.pinnedBy || pins[0].ID != msg.ID):
+				t.Fatalf("got pins %+v, want the message pinned by %s", pins, tt.pinnedBy)
+			}
+		})
+	}
+}
+
+func TestApplyPinRefused(t *testing.T) {
+	d := newTestDatabase(t)
+	msg := firstMessage(t, d)
+
+	tests := []struct {
+		name      string
+		messageID string
+		peerID    string
+		incoming  bool
+		want      error
+	}{
+		{"unknown message", "no-such-message", "peer", true, ErrMessageNotFound},
+		{"peer outside the conversation", msg.MessageID, "stranger", true, ErrNotMember},
+		{"member", msg.MessageID, "peer", true, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := d.ApplyPin(tt.messageID, tt.peerID, false, time.Now(), tt.incoming); !errors.Is(err, tt.want) {
+				t.Fatalf("got %v, want %v", err, tt.want)
+			}
+		})
+	}
+
+	// Deleting erases the pin, and a late pin must not bring it back
+	if err := d.DeleteMessage(msg.MessageID, "peer", time.Now()); err != nil {
+		t.Fatalf("failed to delete message: %v", err)
+	}
+	for _, incoming := range []bool{true, false} {
+		if err := d.ApplyPin(msg.MessageID, "peer", false, time.Now().Add(time.Hour), incoming); !errors.Is(err, ErrMessageDeleted) {
+			t.Fatalf("pin of a deleted message (incoming %v): got %v, want %v", incoming, err, ErrMessageDeleted)
+		}
+	}
+	var rows int
+	if err := d.conn().db.QueryRow(`SELECT COUNT(*) FROM pins`).Scan(&rows); err != nil {
+		t.Fatal(err)
+	}
+	if rows != 0 {
+		t.Fatalf("got %d pin rows after deletion, want none", rows)
+	}
+}
+
+func TestStarMessage(t *testing.T) {
+	d := newTestDatabase(t)
+	ids := insertMessages(t, d, "peer", time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC), 2)
+	deleted, err := d.GetMessage(ids[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.DeleteMessage(deleted.MessageID, "peer", time.Now()); err != nil {
+		t.Fatalf("failed to delete message: %v", err)
+	}
+
+	steps := []struct {
+		name    string
+		id      int64
+		starred bool
+		want    error
+	}{
+		{"star", ids[0], true, nil},
+		{"star again", ids[0], true, nil},
+		{"star a deleted message", ids[1], true, ErrMessageDeleted},
+		{"star an unknown message", ids[1] + 100, true, ErrMessageNotFound},
+	}
+	for _, step := range steps {
+		if err := d.StarMessage(step.id, step.starred); !errors.Is(err, step.want) {
+			t.Fatalf("%s: got %v, want %v", step.name, err, step.want)
+		}
+	}
+
+	stars, err := d.GetStarredMessages()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stars) != 1 || stars[0].ID != ids[0] || !stars[0].Starred {
+		t.Fatalf("got stars %+v, want the first message", stars)
+	}
+
+	if err := d.StarMessage(ids[0], false); err != nil {
+		t.Fatalf("failed to unstar: %v", err)
+	}
+	if stars, err = d.GetStarredMessages(); err != nil || len(stars) != 0 {
+		t.Fatalf("got stars %+v (%v), want none", stars, err)
+	}
+}
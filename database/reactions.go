@@ -0,0 +1,193 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// maxEmojiLength bounds a reaction, allowing for multi-codepoint emoji
+// such as flags and skin tones
+const maxEmojiLength = 16
+
+// ReactionCount is how many peers reacted to a message with one emoji
+type ReactionCount struct {
+	Emoji   string   `json:"emoji"`
+	Count   int      `json:"count"`
+	PeerIDs []string `json:"peer_ids"`
+}
+
+// ApplyReaction records a peer adding or removing an emoji reaction.
+// Each peer's reaction with an emoji is last-writer-wins by timestamp,
+// with removal winning a tie, so peers converge whatever order changes
+// arrive in. Removals are kept so a late, older add can't resurrect a
+// reaction. A change older than what is stored returns
+// ErrDuplicateMessage, since it has already been superseded. Reactions
+// to messages that aren't stored or were deleted are refused, and
+// incoming ones are only accepted from members of the conversation.
+func (d *Database) ApplyReaction(messageID, peerID, emoji string, removed bool, at time.Time, incoming bool) error {
+	if err := validateEmoji(emoji); err != nil {
+		return err
+	}
+
+	return d.withTx(func(tx *sql.Tx) error {
+		if err := checkMarkTarget(tx, messageID, peerID, incoming); err != nil {
+			return err
+		}
+		applied, err := mergeReaction(tx, messageID, peerID, emoji, !removed, at.UnixNano())
+		if err != nil {
+			return err
+		}
+		if !applied {
+			return ErrDuplicateMessage
+		}
+		return nil
+	})
+}
+
+// checkMarkTarget checks that a reaction or pin is for a stored message
+// that hasn't been deleted and, for a peer's change, that the peer is a
+// member of the message's conversation
+func checkMarkTarget(tx *sql.Tx, messageID, peerID string, incoming bool) error {
+	var conversationID string
+	var deleted bool
+	err := tx.QueryRow(`SELECT conversation_id, deleted_at IS NOT NULL FROM messages WHERE message_id = ?`,
+		messageID).Scan(&conversationID, &deleted)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrMessageNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to load message: %w", err)
+	}
+	if deleted {
+		return ErrMessageDeleted
+	}
+	if !incoming {
+		return nil
+	}
+
+	member, err := isMember(tx, conversationID, peerID)
+	if err != nil {
+		return err
+	}
+	if !member {
+		return ErrNotMember
+	}
+	return nil
+}
+
+// mergeReaction stores a reaction state unless a newer one is stored,
+// reporting whether it was applied
+func mergeReaction(tx *sql.Tx, messageID, peerID, emoji string, active bool, updatedAt int64) (bool, error) {
+	result, err := tx.Exec(`
+		INSERT INTO reactions (message_id, peer_id, emoji, active, updated_at)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(message_id, peer_id, emoji) DO UPDATE SET
+			active = excluded.active,
+			updated_at = excluded.updated_at
+		WHERE excluded.updated_at > reactions.updated_at
+			OR (excluded.updated_at = reactions.updated_at AND excluded.active < reactions.active)
+	`, messageID, peerID, emoji, active, updatedAt)
+	if err != nil {
+		return false, fmt.Errorf("failed to save reaction: %w", err)
+	}
+	affected, _ := result.RowsAffected()
+	return affected > 0, nil
+}
+
+// validateEmoji checks that a reaction is a short token without spaces
+func validateEmoji(emoji string) error {
+	if emoji == "" {
+		return fmt.Errorf("reaction cannot be empty")
+	}
+	if utf8.RuneCountInString(emoji) > maxEmojiLength {
+		return fmt.Errorf("reaction is too long")
+	}
+	if strings.IndexFunc(emoji, unicode.IsSpace) >= 0 {
+		return fmt.Errorf("reaction cannot contain spaces")
+	}
+	return nil
+}
+
+// GetReactions returns the reactions to a message by its sender-assigned
+// ID, most popular first
+func (d *Database) GetReactions(messageID string) ([]ReactionCount, error) {
+	reactions, err := d.loadReactions([]interface{}{messageID})
+	if err != nil {
+		return nil, err
+	}
+	if reactions[messageID] == nil {
+		return []ReactionCount{}, nil
+	}
+	return reactions[messageID], nil
+}
+
+// attachReactions fills in the reactions of messages that haven't been
+// deleted. Callers must have closed the rows the messages came from.
+func (d *Database) attachReactions(messages []Message) error {
+	var ids []interface{}
+	for _, msg := range messages {
+		if msg.MessageID != "" && msg.DeletedAt == nil {
+			ids = append(ids, msg.MessageID)
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	reactions, err := d.loadReactions(ids)
+	if err != nil {
+		return err
+	}
+	for i := range messages {
+		if messages[i].DeletedAt == nil {
+			messages[i].Reactions = reactions[messages[i].MessageID]
+		}
+	}
+	return nil
+}
+
+// loadReactions aggregates the active reactions to messages by ID
+func (d *Database) loadReactions(messageIDs []interface{}) (map[string][]ReactionCount, error) {
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(messageIDs)), ", ")
+	rows, err := d.conn().db.Query(`
+		SELECT message_id, emoji, peer_id FROM reactions
+		WHERE active = 1 AND message_id IN (`+placeholders+`)
+		ORDER BY message_id, emoji, updated_at
+	`, messageIDs...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query reactions: %w", err)
+	}
+	defer rows.Close()
+
+	reactions := make(map[string][]ReactionCount)
+	for rows.Next() {
+		var messageID, emoji, peerID string
+		if err := rows.Scan(&messageID, &emoji, &peerID); err != nil {
+			return nil, fmt.Errorf("failed to scan reaction: %w", err)
+		}
+
+		counts := reactions[messageID]
+		if n := len(counts); n > 0 && counts[n-1].Emoji == emoji {
+			counts[n-1].Count++
+			counts[n-1].PeerIDs = append(counts[n-1].PeerIDs, peerID)
+		} else {
+			counts = append(counts, ReactionCount{Emoji: emoji, Count: 1, PeerIDs: []string{peerID}})
+		}
+		reactions[messageID] = counts
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// Most popular first; ties keep emoji order for a stable display
+	for _, counts := range reactions {
+		sort.SliceStable(counts, func(i, j int) bool { return counts[i].Count > counts[j].Count })
+	}
+	return reactions, nil
+}
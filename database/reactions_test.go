@@ -0,0 +1,130 @@
+package database
+
+import (
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestApplyReactionConverges(t *testing.T) {
+	base := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	type change struct {
+		removed bool
+		at      time.Time
+		want    error
+	}
+	tests := []struct {
+		name    string
+		changes []change
+		active  bool
+	}{
+		{"add", []change{{false, base, nil}}, true},
+		{"add then remove", []change{{false, base, nil}, {true, base.Add(time.Second), nil}}, false},
+		{"late older add can't resurrect", []change{{true, base.Add(time.Second), nil}, {false, base, ErrDuplicateMessage}}, false},
+		{"removal wins a tie", []change{{false, base, nil}, {true, base, nil}}, false},
+		{"add loses a tie", []change{{true, base, nil}, {false, base, ErrDuplicateMessage}}, false},
+		{"redelivery", []change{{false, base, nil}, {false, base, ErrDuplicateMessage}}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := newTestDatabase(t)
+			msg := firstMessage(t, d)
+			for i, c := range tt.changes {
+				err := d.ApplyReaction(msg.MessageID, "peer", "👍", c.removed, c.at, true)
+				if !errors.Is(err, c.want) {
+					t.Fatalf("change %d: got %v, want %v", i, err, c.want)
+				}
+			}
+
+			reactions, err := d.GetReactions(msg.MessageID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if active := len(reactions) == 1; active != tt.active {
+				t.Fatalf("got reactions %+v, want active %v", reactions, tt.active)
+			}
+		})
+	}
+}
+
+func TestApplyReactionCountsPeers(t *testing.T) {
+	d := newTestDatabase(t)
+	msg := firstMessage(t, d)
+
+	at := time.Now()
+	for _, r := range []struct{ peer, emoji string }{
+		{"peer", "🎉"},
+		{"peer", "👍"},
+		{"me", "👍"},
+	} {
+		if err := d.ApplyReaction(msg.MessageID, r.peer, r.emoji, false, at, false); err != nil {
+			t.Fatalf("failed to react: %v", err)
+		}
+	}
+
+	reactions, err := d.GetReactions(msg.MessageID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reactions) != 2 || reactions[0].Emoji != "👍" || reactions[0].Count != 2 || reactions[1].Emoji != "🎉" {
+		t.Fatalf("got %+v, want 👍 twice then 🎉", reactions)
+	}
+	peers := slices.Clone(reactions[0].PeerIDs)
+	slices.Sort(peers)
+	if !slices.Equal(peers, []string{"me", "peer"}) {
+		t.Fatalf("got peers %v for 👍", peers)
+	}
+}
+
+func TestApplyReactionRefused(t *testing.T) {
+	d := newTestDatabase(t)
+	msg := firstMessage(t, d)
+	deleted := insertMessages(t, d, "peer", time.Now(), 1)[0]
+	tombstone, err := d.GetMessage(deleted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.DeleteMessage(tombstone.MessageID, "peer", time.Now()); err != nil {
+		t.Fatalf("failed to delete message: %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		messageID string
+		peerID    string
+		emoji     string
+		incoming  bool
+		want      error
+	}{
+		{"unknown message", "no-such-message", "peer", "👍", true, ErrMessageNotFound},
+		{"unknown message of our own", "no-such-message", "me", "👍", false, ErrMessageNotFound},
+		{"deleted message", tombstone.MessageID, "peer", "👍", true, ErrMessageDeleted},
+		{"peer outside the conversation", msg.MessageID, "stranger", "👍", true, ErrNotMember},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := d.ApplyReaction(tt.messageID, tt.peerID, tt.emoji, false, time.Now(), tt.incoming)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("got %v, want %v", err, tt.want)
+			}
+		})
+	}
+
+	for _, emoji := range []string{"", "two words", "🎉🎉🎉🎉🎉🎉🎉🎉🎉🎉🎉🎉🎉🎉🎉🎉🎉"} {
+		if err := d.ApplyReaction(msg.MessageID, "peer", emoji, false, time.Now(), true); err == nil {
+			t.Errorf("reaction %q accepted", emoji)
+		}
+	}
+
+	var rows int
+	if err := d.conn().db.QueryRow(`SELECT COUNT(*) FROM reactions`).Scan(&rows); err != nil {
+		t.Fatal(err)
+	}
+	if rows != 0 {
+		t.Fatalf("got %d reaction rows, want none", rows)
+	}
+}
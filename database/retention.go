@@ -0,0 +1,241 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// pruneBatchSize bounds how many messages one pruning transaction deletes,
+// so pruning a large history doesn't block writers for long
+const pruneBatchSize = 500
+
+// RetentionPolicy limits how much of a conversation's history is kept.
+// Days drops messages older than that many days and Messages keeps only
+// the newest that many; zero disables a limit, so the zero value keeps
+// everything forever.
+type RetentionPolicy struct {
+	Days     int `json:"days"`
+	Messages int `json:"messages"`
+}
+
+// SetRetentionPolicy sets how much history a conversation keeps
+func (d *Database) SetRetentionPolicy(conversationID string, policy RetentionPolicy) error {
+	if policy.Days < 0 || policy.Messages < 0 {
+		return fmt.Errorf("retention limits cannot be negative")
+	}
+
+	result, err := d.exec(`UPDATE conversations SET retention_days = ?, retention_messages = ? WHERE id = ?`,
+		policy.Days, policy.Messages, conversationID)
+	if err != nil {
+		return fmt.Errorf("failed to set retention policy: %w", err)
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return fmt.Errorf("conversation %s not found", conversationID)
+	}
+
+	return nil
+}
+
+// SetLegalHold exempts a conversation from pruning while hold is set
+func (d *Database) SetLegalHold(conversationID string, hold bool) error {
+	result, err := d.exec(`UPDATE conversations SET legal_hold = ? WHERE id = ?`, hold, conversationID)
+	if err != nil {
+		return fmt.Errorf("failed to set legal hold: %w", err)
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return fmt.Errorf("conversation %s not found", conversationID)
+	}
+
+	return nil
+}
+
+// PruneHistory deletes messages outside their conversation's retention
+// policy, skipping conversations under legal hold, then returns the freed
+// pages to the filesystem. It returns how many messages were deleted.
+func (d *Database) PruneHistory(now time.Time) (int64, error) {
+	rows, err := d.conn().db.Query(`
+		SELECT id, retention_days, retention_messages FROM conversations
+		WHERE legal_hold = 0 AND (retention_days > 0 OR retention_messages > 0)
+	`)
+	if err != nil {
+		return 0, fmt.Errorf("failed to query retention policies: %w", err)
+	}
+
+	policies := make(map[string]RetentionPolicy)
+	for rows.Next() {
+		var conversationID string
+		var policy RetentionPolicy
+		if err := rows.Scan(&conversationID, &policy.Days, &policy.Messages); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("failed to scan retention policy: %w", err)
+		}
+		policies[conversationID] = policy
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	var total int64
+	for conversationID, policy := range policies {
+		pruned, err := d.pruneConversation(conversationID, policy, now)
+		total += pruned
+		if err != nil {
+			return total, err
+		}
+	}
+
+	if total > 0 {
+		d.incrementalVacuum()
+	}
+	return total, nil
+}
+
+// pruneConversation deletes one conversation's expired messages in batches,
+// re-checking the legal hold in each batch's transaction
+func (d *Database) pruneConversation(conversationID string, policy RetentionPolicy, now time.Time) (int64, error) {
+	var clauses []string
+	var args []interface{}
+	if policy.Days > 0 {
+		clauses = append(clauses, "timestamp < ?")
+		args = append(args, now.UTC().AddDate(0, 0, -policy.Days))
+	}
+	if policy.Messages > 0 {
+		// Everything at or before the first message past the newest N
+		clauses = append(clauses, `(timestamp, id) <= (
+			SELECT timestamp, id FROM messages WHERE conversation_id = ?
+			ORDER BY timestamp DESC, id DESC LIMIT 1 OFFSET ?
+		)`)
+		args = append(args, conversationID, policy.Messages)
+	}
+
+	query := `
+		SELECT id, is_read FROM messages
+		WHERE conversation_id = ? AND (` + strings.Join(clauses, " OR ") + `)
+		LIMIT ?
+	`
+	args = append([]interface{}{conversationID}, args...)
+	args = append(args, pruneBatchSize)
+
+	var total int64
+	for {
+		var deleted int
+		err := d.withTx(func(tx *sql.Tx) error {
+			// A hold placed since the policies were read stops the rest
+			var held bool
+			if err := tx.QueryRow(`SELECT legal_hold FROM conversations WHERE id = ?`, conversationID).Scan(&held); err != nil {
+				return fmt.Errorf("failed to read legal hold: %w", err)
+			}
+			if held {
+				return nil
+			}
+
+			rows, err := tx.Query(query, args...)
+			if err != nil {
+				return fmt.Errorf("failed to query expired messages: %w", err)
+			}
+
+			var ids []interface{}
+			unread := 0
+			for rows.Next() {
+				var id int64
+				var isRead bool
+				if err := rows.Scan(&id, &isRead); err != nil {
+					rows.Close()
+					return fmt.Errorf("failed to scan expired message: %w", err)
+				}
+				ids = append(ids, id)
+				if !isRead {
+					unread++
+				}
+			}
+			rows.Close()
+			if err := rows.Err(); err != nil {
+				return err
+			}
+			if len(ids) == 0 {
+				return nil
+			}
+
+			if err := d.deleteMessages(tx, ids); err != nil {
+				return err
+			}
+			if unread > 0 {
+				if err := adjustUnread(tx, conversationID, -unread); err != nil {
+					return err
+				}
+			}
+			deleted = len(ids)
+			return nil
+		})
+		total += int64(deleted)
+		if err != nil {
+			return total, fmt.Errorf("failed to prune %s: %w", conversationID, err)
+		}
+		if deleted < pruneBatchSize {
+			return total, nil
+		}
+	}
+}
+
+// deleteMessages removes messages by row ID along with their edit history,
+// reactions, pins, stars and search index entries
+func (d *Database) deleteMessages(tx *sql.Tx, ids []interface{}) error {
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(ids)), ", ")
+
+	statements := []string{
+		`DELETE FROM reactions WHERE message_id IN (SELECT message_id FROM messages WHERE id IN (` + placeholders + `))`,
+		`DELETE FROM pins WHERE message_id IN (SELECT message_id FROM messages WHERE id IN (` + placeholders + `))`,
+		`DELETE FROM stars WHERE message_row_id IN (` + placeholders + `)`,
+		`DELETE FROM message_revisions WHERE message_row_id IN (` + placeholders + `)`,
+		`DELETE FROM messages WHERE id IN (` + placeholders + `)`,
+	}
+	if d.searchIndexed() {
+		statements = append(statements, `DELETE FROM messages_fts WHERE rowid IN (`+placeholders+`)`)
+	}
+
+	for _, statement := range statements {
+		if _, err := tx.Exec(statement, ids...); err != nil {
+			return fmt.Errorf("failed to delete messages: %w", err)
+		}
+	}
+	return nil
+}
+
+// enableIncrementalVacuum switches an existing database to incremental
+// auto-vacuum. New files pick it up from the connection string; older ones
+// need a one-off VACUUM for the setting to take effect.
+func (d *Database) enableIncrementalVacuum() error {
+	var mode int
+	if err := d.conn().db.QueryRow(`PRAGMA auto_vacuum`).Scan(&mode); err != nil {
+		return fmt.Errorf("failed to read auto_vacuum: %w", err)
+	}
+	if mode == 2 {
+		return nil
+	}
+
+	log.Println("Rewriting database to enable incremental vacuum")
+	if _, err := d.conn().writeDB.Exec(`VACUUM`); err != nil {
+		return err
+	}
+	return nil
+}
+
+// incrementalVacuum returns free pages to the filesystem. The pragma frees
+// pages as it is stepped, so its rows are drained rather than executed once.
+func (d *Database) incrementalVacuum() {
+	rows, err := d.conn().writeDB.Query(`PRAGMA incremental_vacuum`)
+	if err == nil {
+		for rows.Next() {
+		}
+		err = rows.Err()
+		rows.Close()
+	}
+	if err != nil {
+		// Not fatal: the pages are reused by later writes
+		log.Printf("Warning: incremental vacuum failed: %v", err)
+	}
+}
@@ -0,0 +1,123 @@
+package database
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPruneHistory(t *testing.T) {
+	start := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	now := start.AddDate(0, 0, 10)
+
+	tests := []struct {
+		name   string
+		policy RetentionPolicy
+		hold   bool
+		keep   func(ids []int64) []int64
+	}{
+		{"no policy", RetentionPolicy{}, false, func(ids []int64) []int64 { return ids }},
+		{"by age", RetentionPolicy{Days: 5}, false, func(ids []int64) []int64 { return ids[3:] }},
+		{"by count", RetentionPolicy{Messages: 2}, false, func(ids []int64) []int64 { return ids[3:] }},
+		{"either limit", RetentionPolicy{Days: 5, Messages: 1}, false, func(ids []int64) []int64 { return ids[4:] }},
+		{"legal hold", RetentionPolicy{Days: 5, Messages: 1}, true, func(ids []int64) []int64 { return ids }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := newTestDatabase(t)
+			conversationID := DirectConversationID("peer")
+
+			// Three old messages and two recent ones
+			ids := insertMessages(t, d, "peer", start, 3)
+			ids = append(ids, insertMessages(t, d, "peer", now.Add(-time.Hour), 2)...)
+
+			if err := d.SetRetentionPolicy(conversationID, tt.policy); err != nil {
+				t.Fatalf("failed to set policy: %v", err)
+			}
+			if err := d.SetLegalHold(conversationID, tt.hold); err != nil {
+				t.Fatalf("failed to set legal hold: %v", err)
+			}
+
+			want := tt.keep(ids)
+			pruned, err := d.PruneHistory(now)
+			if err != nil {
+				t.Fatalf("failed to prune: %v", err)
+			}
+			if pruned != int64(len(ids)-len(want)) {
+				t.Errorf("pruned %d messages, want %d", pruned, len(ids)-len(want))
+			}
+
+			page, err := d.GetMessagesPage(conversationID, HistoryCursor{})
+			if err != nil {
+				t.Fatalf("failed to read history: %v", err)
+			}
+			assertPage(t, page, want, false, false)
+
+			// The unread count follows the messages that are left
+			conversation, err := d.GetConversation(conversationID)
+			if err != nil {
+				t.Fatalf("failed to load conversation: %v", err)
+			}
+			if conversation.UnreadCount != len(want) {
+				t.Errorf("got %d unread, want %d", conversation.UnreadCount, len(want))
+			}
+		})
+	}
+}
+
+func TestPruneStopsAtLegalHold(t *testing.T) {
+	d := newTestDatabase(t)
+	conversationID := DirectConversationID("peer")
+	start := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	ids := insertMessages(t, d, "peer", start, 3)
+
+	policy := RetentionPolicy{Messages: 1}
+	if err := d.SetRetentionPolicy(conversationID, policy); err != nil {
+		t.Fatalf("failed to set policy: %v", err)
+	}
+
+	// A hold placed after PruneHistory read the policies, but before the
+	// conversation's batches run, keeps every message
+	if err := d.SetLegalHold(conversationID, true); err != nil {
+		t.Fatalf("failed to set legal hold: %v", err)
+	}
+	pruned, err := d.pruneConversation(conversationID, policy, start.Add(time.Hour))
+	if err != nil {
+		t.Fatalf("failed to prune: %v", err)
+	}
+	if pruned != 0 {
+		t.Fatalf("pruned %d messages under legal hold", pruned)
+	}
+
+	page, err := d.GetMessagesPage(conversationID, HistoryCursor{})
+	if err != nil {
+		t.Fatalf("failed to read history: %v", err)
+	}
+	assertPage(t, page, ids, false, false)
+}
+
+func TestSetRetentionPolicyRefused(t *testing.T) {
+	d := newTestDatabase(t)
+	firstMessage(t, d)
+
+	tests := []struct {
+		name           string
+		conversationID string
+		policy         RetentionPolicy
+	}{
+		{"negative days", DirectConversationID("peer"), RetentionPolicy{Days: -1}},
+		{"negative count", DirectConversationID("peer"), RetentionPolicy{Messages: -1}},
+		{"unknown conversation", DirectConversationID("stranger"), RetentionPolicy{Days: 1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := d.SetRetentionPolicy(tt.conversationID, tt.policy); err == nil {
+				t.Fatal("policy was set")
+			}
+		})
+	}
+	if err := d.SetLegalHold(DirectConversationID("stranger"), true); err == nil {
+		t.Fatal("held an unknown conversation")
+	}
+}
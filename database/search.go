@@ -0,0 +1,389 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+	"unicode"
+)
+
+const (
+	// snippetStart and snippetEnd delimit matches in FTS5 snippets before
+	// they are split into SnippetParts
+	snippetStart = "\x02"
+	snippetEnd   = "\x03"
+	// snippetRunes is the context kept around a match in fallback snippets
+	snippetRunes = 40
+)
+
+// SearchQuery describes a message search. Text holds plain terms, which
+// must all match, and "quoted phrases"; a trailing * makes a term a prefix.
+type SearchQuery struct {
+	Text           string    `json:"text"`
+	ConversationID string    `json:"conversation_id,omitempty"`
+	SenderID       string    `json:"sender_id,omitempty"`
+	From           time.Time `json:"from,omitzero"`
+	To             time.Time `json:"to,omitzero"`
+	Limit          int       `json:"limit,omitempty"`
+}
+
+// SnippetPart is a piece of a search snippet; Match marks highlighted text
+type SnippetPart struct {
+	Text  string `json:"text"`
+	Match bool   `json:"match"`
+}
+
+// SearchResult is a message matching a search with its highlighted snippet
+type SearchResult struct {
+	Message Message       `json:"message"`
+	Snippet []SnippetPart `json:"snippet"`
+}
+
+// searchTerm is a word, prefix or phrase parsed from a query
+type searchTerm struct {
+	text   string
+	prefix bool
+}
+
+// loadSearchState detects whether the FTS5 index created by
+// migrateSearchIndex exists and this build can use it
+func (d *Database) loadSearchState() error {
+	if d.encrypted {
+		return nil
+	}
+
+	var existing int
+	err := d.conn().db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE name = 'messages_fts'`).Scan(&existing)
+	if err != nil {
+		return fmt.Errorf("failed to inspect search index: %w", err)
+	}
+	if existing == 0 {
+		return nil
+	}
+
+	// A build without FTS5 can't read an index written by one with it
+	rows, err := d.conn().db.Query(`SELECT rowid FROM messages_fts LIMIT 0`)
+	if err != nil {
+		log.Printf("Full-text search unavailable, falling back to scanning: %v", err)
+		return nil
+	}
+	rows.Close()
+
+	d.ftsAvailable = true
+	return nil
+}
+
+// searchIndexed reports whether the FTS5 index is being maintained
+func (d *Database) searchIndexed() bool {
+	d.keyMu.RLock()
+	defer d.keyMu.RUnlock()
+	return d.ftsAvailable && !d.encrypted
+}
+
+// indexMessage adds or replaces a message's entry in the search index
+func (d *Database) indexMessage(tx *sql.Tx, id int64, content string) error {
+	if err := d.unindexMessage(tx, id); err != nil {
+		return err
+	}
+	if !d.searchIndexed() {
+		return nil
+	}
+
+	if _, err := tx.Exec(`INSERT INTO messages_fts (rowid, content) VALUES (?, ?)`, id, content); err != nil {
+		return fmt.Errorf("failed to update search index: %w", err)
+	}
+	return nil
+}
+
+// unindexMessage removes a message from the search index
+func (d *Database) unindexMessage(tx *sql.Tx, id int64) error {
+	if !d.searchIndexed() {
+		return nil
+	}
+
+	if _, err := tx.Exec(`DELETE FROM messages_fts WHERE rowid = ?`, id); err != nil {
+		return fmt.Errorf("failed to update search index: %w", err)
+	}
+	return nil
+}
+
+// RebuildSearchIndex re-indexes every message from scratch
+func (d *Database) RebuildSearchIndex() error {
+	if !d.searchIndexed() {
+		return fmt.Errorf("full-text index is not available")
+	}
+
+	return d.withTx(func(tx *sql.Tx) error {
+		if _, err := tx.Exec(`DELETE FROM messages_fts`); err != nil {
+			return fmt.Errorf("failed to clear search index: %w", err)
+		}
+
+		_, err := tx.Exec(`INSERT INTO messages_fts (rowid, content) SELECT id, content FROM messages WHERE deleted_at IS NULL`)
+		if err != nil {
+			return fmt.Errorf("failed to rebuild search index: %w", err)
+		}
+		return nil
+	})
+}
+
+// SearchMessages finds messages matching a query, newest first when
+// scanning and by relevance when the full-text index is available
+func (d *Database) SearchMessages(q SearchQuery) ([]SearchResult, error) {
+	terms := parseSearchTerms(q.Text)
+	if len(terms) == 0 {
+		return nil, fmt.Errorf("search text is required")
+	}
+	if q.Limit <= 0 {
+		q.Limit = 50
+	}
+	if d.IsLocked() {
+		return nil, ErrLocked
+	}
+
+	if d.searchIndexed() {
+		return d.searchIndex(q, terms)
+	}
+	return d.searchScan(q, terms)
+}
+
+// searchFilters renders the non-text filters of a query as SQL
+func searchFilters(q SearchQuery) (string, []interface{}) {
+	var clauses []string
+	var args []interface{}
+
+	if q.ConversationID != "" {
+		clauses = append(clauses, "m.conversation_id = ?")
+		args = append(args, q.ConversationID)
+	}
+	if q.SenderID != "" {
+		clauses = append(clauses, "m.sender_id = ?")
+		args = append(args, q.SenderID)
+	}
+	if !q.From.IsZero() {
+		clauses = append(clauses, "m.timestamp >= ?")
+		args = append(args, q.From.UTC())
+	}
+	if !q.To.IsZero() {
+		clauses = append(clauses, "m.timestamp < ?")
+		args = append(args, q.To.UTC())
+	}
+
+	if len(clauses) == 0 {
+		return "", nil
+	}
+	return " AND " + strings.Join(clauses, " AND "), args
+}
+
+// searchIndex runs a query against the FTS5 index
+func (d *Database) searchIndex(q SearchQuery, terms []searchTerm) ([]SearchResult, error) {
+	filters, args := searchFilters(q)
+	query := `
+		SELECT ` + aliasedMessageColumns + `,
+			snippet(messages_fts, 0, char(2), char(3), '…', 16)
+		FROM messages_fts
+		JOIN messages m ON m.id = messages_fts.rowid
+		WHERE messages_fts MATCH ?` + filters + `
+		ORDER BY rank
+		LIMIT ?
+	`
+
+	args = append([]interface{}{ftsMatchExpression(terms)}, args...)
+	args = append(args, q.Limit)
+
+	rows, err := d.conn().db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search messages: %w", err)
+	}
+	defer rows.Close()
+
+	var results []SearchResult
+	for rows.Next() {
+		var snippet string
+		msg, err := d.scanMessage(rows, &snippet)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, SearchResult{Message: msg, Snippet: splitSnippet(snippet)})
+	}
+
+	return results, rows.Err()
+}
+
+// searchScan decrypts and matches messages one by one. It is used when
+// SQLite lacks FTS5 or the store is encrypted.
+func (d *Database) searchScan(q SearchQuery, terms []searchTerm) ([]SearchResult, error) {
+	filters, args := searchFilters(q)
+	query := `
+		SELECT ` + aliasedMessageColumns + `
+		FROM messages m
+		WHERE m.deleted_at IS NULL` + filters + `
+		ORDER BY m.timestamp DESC, m.id DESC
+	`
+
+	rows, err := d.conn().db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search messages: %w", err)
+	}
+	defer rows.Close()
+
+	var results []SearchResult
+	for rows.Next() && len(results) < q.Limit {
+		msg, err := d.scanMessage(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		if snippet, ok := matchSnippet(msg.Content, terms); ok {
+			results = append(results, SearchResult{Message: msg, Snippet: snippet})
+		}
+	}
+
+	return results, rows.Err()
+}
+
+// parseSearchTerms splits query text into words and "quoted phrases"
+func parseSearchTerms(text string) []searchTerm {
+	var terms []searchTerm
+	for i, part := range strings.Split(text, `"`) {
+		if i%2 == 1 {
+			// Inside quotes: the whole phrase is one term
+			if phrase := strings.Join(strings.Fields(part), " "); phrase != "" {
+				terms = append(terms, searchTerm{text: phrase})
+			}
+			continue
+		}
+
+		for _, word := range strings.Fields(part) {
+			prefix := strings.HasSuffix(word, "*")
+			word = strings.TrimRight(word, "*")
+			if word != "" {
+				terms = append(terms, searchTerm{text: word, prefix: prefix})
+			}
+		}
+	}
+	return terms
+}
+
+// ftsMatchExpression quotes every term so user input can't inject FTS5
+// operators; terms are implicitly ANDed
+func ftsMatchExpression(terms []searchTerm) string {
+	parts := make([]string, 0, len(terms))
+	for _, term := range terms {
+		quoted := `"` + strings.ReplaceAll(term.text, `"`, `""`) + `"`
+		if term.prefix {
+			quoted += "*"
+		}
+		parts = append(parts, quoted)
+	}
+	return strings.Join(parts, " ")
+}
+
+// splitSnippet turns an FTS5 snippet with marker characters into parts
+func splitSnippet(snippet string) []SnippetPart {
+	var parts []SnippetPart
+	for _, chunk := range strings.Split(snippet, snippetStart) {
+		match, rest, found := strings.Cut(chunk, snippetEnd)
+		if !found {
+			if chunk != "" {
+				parts = append(parts, SnippetPart{Text: chunk})
+			}
+			continue
+		}
+		parts = append(parts, SnippetPart{Text: match, Match: true})
+		if rest != "" {
+			parts = append(parts, SnippetPart{Text: rest})
+		}
+	}
+	return parts
+}
+
+// matchSnippet checks that content contains every term, case-insensitively,
+// and builds a snippet around the first match
+func matchSnippet(content string, terms []searchTerm) ([]SnippetPart, bool) {
+	runes := []rune(content)
+	lower := []rune(strings.ToLower(content))
+	if len(lower) != len(runes) {
+		// Case folding changed the length; match on the original text
+		lower = runes
+	}
+
+	type span struct{ start, end int }
+	var spans []span
+	for _, term := range terms {
+		needle := []rune(strings.ToLower(term.text))
+		found := false
+		for i := 0; i+len(needle) <= len(lower); i++ {
+			if !runesEqual(lower[i:i+len(needle)], needle) {
+				continue
+			}
+			// Words match at word boundaries; prefixes may continue
+			if i > 0 && isWordRune(lower[i-1]) {
+				continue
+			}
+			end := i + len(needle)
+			if !term.prefix && end < len(lower) && isWordRune(lower[end]) {
+				continue
+			}
+			for term.prefix && end < len(lower) && isWordRune(lower[end]) {
+				end++
+			}
+			spans = append(spans, span{i, end})
+			found = true
+		}
+		if !found {
+			return nil, false
+		}
+	}
+
+	// Window the snippet around the earliest match
+	first := spans[0]
+	for _, sp := range spans {
+		if sp.start < first.start {
+			first = sp
+		}
+	}
+	from := max(first.start-snippetRunes, 0)
+	to := min(first.end+snippetRunes, len(runes))
+
+	highlighted := make([]bool, len(runes))
+	for _, sp := range spans {
+		for i := sp.start; i < sp.end; i++ {
+			highlighted[i] = true
+		}
+	}
+
+	var parts []SnippetPart
+	if from > 0 {
+		parts = append(parts, SnippetPart{Text: "…"})
+	}
+	for start := from; start < to; {
+		end := start
+		for end < to && highlighted[end] == highlighted[start] {
+			end++
+		}
+		parts = append(parts, SnippetPart{Text: string(runes[start:end]), Match: highlighted[start]})
+		start = end
+	}
+	if to < len(runes) {
+		parts = append(parts, SnippetPart{Text: "…"})
+	}
+
+	return parts, true
+}
+
+// runesEqual compares two rune slices of equal length
+func runesEqual(a, b []rune) bool {
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// isWordRune reports whether r is part of a word for matching purposes
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
@@ -0,0 +1,96 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// maxDisplayNameLength bounds the name announced to peers
+const maxDisplayNameLength = 64
+
+// Settings are the user-configurable options that aren't tied to a
+// feature of their own. Durations are in seconds so they round-trip
+// through the frontend unchanged.
+type Settings struct {
+	// DisplayName is announced to peers; empty keeps the profile's name
+	DisplayName string `json:"display_name"`
+	// TCPPort is where messages are received
+	TCPPort int `json:"tcp_port"`
+	// AnnounceIntervalSeconds is how often presence is multicast
+	AnnounceIntervalSeconds int `json:"announce_interval_seconds"`
+	// PeerTimeoutSeconds is how long a silent peer is kept as online
+	PeerTimeoutSeconds int `json:"peer_timeout_seconds"`
+	// ConnectTimeoutSeconds bounds connecting to a peer
+	ConnectTimeoutSeconds int `json:"connect_timeout_seconds"`
+}
+
+// DefaultSettings returns the settings used until the user changes them
+func DefaultSettings() Settings {
+	return Settings{
+		TCPPort:                 8080,
+		AnnounceIntervalSeconds: 30,
+		PeerTimeoutSeconds:      300,
+		ConnectTimeoutSeconds:   5,
+	}
+}
+
+// Validate reports the first setting that is out of range
+func (s Settings) Validate() error {
+	switch {
+	case utf8.RuneCountInString(s.DisplayName) > maxDisplayNameLength:
+		return fmt.Errorf("display name must be at most %d characters", maxDisplayNameLength)
+	case strings.IndexFunc(s.DisplayName, unicode.IsControl) >= 0:
+		return fmt.Errorf("display name cannot contain control characters")
+	case strings.TrimSpace(s.DisplayName) != s.DisplayName:
+		return fmt.Errorf("display name cannot start or end with spaces")
+	case s.TCPPort < 1 || s.TCPPort > 65535:
+		return fmt.Errorf("TCP port must be between 1 and 65535")
+	case s.AnnounceIntervalSeconds < 5 || s.AnnounceIntervalSeconds > 3600:
+		return fmt.Errorf("announce interval must be between 5 seconds and an hour")
+	case s.PeerTimeoutSeconds < 60 || s.PeerTimeoutSeconds > 86400:
+		return fmt.Errorf("peer timeout must be between a minute and a day")
+	case s.PeerTimeoutSeconds < 2*s.AnnounceIntervalSeconds:
+		// Otherwise a single lost announcement drops a peer
+		return fmt.Errorf("peer timeout must be at least twice the announce interval")
+	case s.ConnectTimeoutSeconds < 1 || s.ConnectTimeoutSeconds > 60:
+		return fmt.Errorf("connect timeout must be between 1 and 60 seconds")
+	}
+	return nil
+}
+
+// GetSettings returns the saved settings. Settings added since they were
+// saved keep their defaults.
+func (d *Database) GetSettings() (Settings, error) {
+	settings := DefaultSettings()
+
+	value, err := getMeta(d.conn().db, "settings")
+	if errors.Is(err, sql.ErrNoRows) {
+		return settings, nil
+	}
+	if err != nil {
+		return Settings{}, fmt.Errorf("failed to load settings: %w", err)
+	}
+
+	if err := json.Unmarshal([]byte(value), &settings); err != nil {
+		return Settings{}, fmt.Errorf("invalid settings: %w", err)
+	}
+	return settings, nil
+}
+
+// SaveSettings validates and saves the settings
+func (d *Database) SaveSettings(settings Settings) error {
+	if err := settings.Validate(); err != nil {
+		return err
+	}
+
+	value, err := json.Marshal(settings)
+	if err != nil {
+		return fmt.Errorf("failed to encode settings: %w", err)
+	}
+	return setMeta(execFunc(d.exec), "settings", string(value))
+}
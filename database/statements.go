@@ -0,0 +1,59 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// statements are the writes on the message insert path, prepared once on
+// the writer connection instead of being parsed for every message
+type statements struct {
+	insertMessage      *sql.Stmt
+	upsertConversation *sql.Stmt
+	addMember          *sql.Stmt
+	incrementUnread    *sql.Stmt
+}
+
+// prepareStatements prepares the hot write statements on db. It must run
+// after migrations, since statements can't refer to missing columns.
+func prepareStatements(db *sql.DB) (*statements, error) {
+	queries := map[**sql.Stmt]string{}
+	s := &statements{}
+
+	queries[&s.insertMessage] = `
+		INSERT INTO messages (message_id, conversation_id, peer_id, sender_id, content, timestamp, is_read, parent_id)
+		VALUES (?, ?, ?, ?, ?, ?, ?, NULLIF(?, ''))
+		ON CONFLICT(message_id) DO NOTHING
+	`
+	queries[&s.upsertConversation] = `
+		INSERT INTO conversations (id, kind, created_at, last_activity)
+		VALUES (?, ?, ?, ?)
+		ON CONFLICT(id) DO UPDATE SET
+			last_activity = MAX(last_activity, excluded.last_activity)
+	`
+	queries[&s.addMember] = `
+		INSERT INTO conversation_members (conversation_id, peer_id, joined_at)
+		VALUES (?, ?, ?)
+		ON CONFLICT DO NOTHING
+	`
+	queries[&s.incrementUnread] = `UPDATE conversations SET unread_count = unread_count + 1 WHERE id = ?`
+
+	for target, query := range queries {
+		stmt, err := db.Prepare(query)
+		if err != nil {
+			s.close()
+			return nil, fmt.Errorf("failed to prepare statement: %w", err)
+		}
+		*target = stmt
+	}
+	return s, nil
+}
+
+// close releases the prepared statements
+func (s *statements) close() {
+	for _, stmt := range []*sql.Stmt{s.insertMessage, s.upsertConversation, s.addMember, s.incrementUnread} {
+		if stmt != nil {
+			stmt.Close()
+		}
+	}
+}
@@ -0,0 +1,421 @@
+package database
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Statistics bucket sizes
+const (
+	BucketDay   = "day"
+	BucketWeek  = "week"
+	BucketMonth = "month"
+)
+
+const (
+	// maxStatsBuckets bounds how many buckets one report may span
+	maxStatsBuckets = 1000
+	// maxBusiestConversations is how many conversations are ranked
+	maxBusiestConversations = 10
+	// maxResponseGap is the longest gap still counted as a response
+	// rather than a new conversation starting
+	maxResponseGap = 8 * time.Hour
+	// defaultStatsRange is reported when no start is given
+	defaultStatsRange = 30 * 24 * time.Hour
+)
+
+// StatsQuery selects the period a report covers. Buckets start at local
+// midnight for UTCOffsetMinutes, weeks on Monday. A zero To means now and
+// a zero From means 30 days before To.
+type StatsQuery struct {
+	From             time.Time `json:"from"`
+	To               time.Time `json:"to"`
+	Bucket           string    `json:"bucket"`
+	UTCOffsetMinutes int       `json:"utc_offset_minutes"`
+}
+
+// Statistics is an activity report over a period
+type Statistics struct {
+	From                 time.Time              `json:"from"`
+	To                   time.Time              `json:"to"`
+	Bucket               string                 `json:"bucket"`
+	TotalMessages        int                    `json:"total_messages"`
+	Buckets              []StatsBucket          `json:"buckets"`
+	BusiestConversations []ConversationActivity `json:"busiest_conversations"`
+	OurResponses         ResponseTimes          `json:"our_responses"`
+	TheirResponses       ResponseTimes          `json:"their_responses"`
+	PeerOnline           []PeerOnlineTime       `json:"peer_online"`
+}
+
+// StatsBucket counts the messages in one day, week or month. Empty
+// buckets are included so charts have no gaps.
+type StatsBucket struct {
+	Start    time.Time `json:"start"`
+	Messages int       `json:"messages"`
+	Sent     int       `json:"sent"`
+	Received int       `json:"received"`
+}
+
+// ConversationActivity is how busy a conversation was
+type ConversationActivity struct {
+	ConversationID string `json:"conversation_id"`
+	Label          string `json:"label"`
+	Messages       int    `json:"messages"`
+	Senders        int    `json:"senders"`
+}
+
+// ResponseTimes summarizes how quickly messages were answered in direct
+// and group chats. A response is a message following one from someone
+// else within maxResponseGap.
+type ResponseTimes struct {
+	Count          int     `json:"count"`
+	AverageSeconds float64 `json:"average_seconds"`
+}
+
+// PeerOnlineTime is how long a peer was online during the period, as far
+// as the presence history kept for it reaches back
+type PeerOnlineTime struct {
+	PeerID      string  `json:"peer_id"`
+	Name        string  `json:"name"`
+	OnlineHours float64 `json:"online_hours"`
+	Sessions    int     `json:"sessions"`
+}
+
+// GetStatistics aggregates activity over a period. The queries walk only
+// the period's entries in the timestamp and conversation indexes, so their
+// cost follows the period's activity rather than the size of the history.
+// localPeerID tells our messages from peers'.
+func (d *Database) GetStatistics(query StatsQuery, localPeerID string) (Statistics, error) {
+	query, err := normalizeStatsQuery(query, time.Now())
+	if err != nil {
+		return Statistics{}, err
+	}
+
+	stats := Statistics{From: query.From, To: query.To, Bucket: query.Bucket}
+	if stats.Buckets, err = d.statsBuckets(query, localPeerID); err != nil {
+		return Statistics{}, err
+	}
+	for _, bucket := range stats.Buckets {
+		stats.TotalMessages += bucket.Messages
+	}
+	if stats.BusiestConversations, err = d.busiestConversations(query); err != nil {
+		return Statistics{}, err
+	}
+	if stats.OurResponses, stats.TheirResponses, err = d.responseTimes(query, localPeerID); err != nil {
+		return Statistics{}, err
+	}
+	if stats.PeerOnline, err = d.peerOnlineTimes(query, time.Now()); err != nil {
+		return Statistics{}, err
+	}
+	return stats, nil
+}
+
+// normalizeStatsQuery fills in defaults and checks the period
+func normalizeStatsQuery(query StatsQuery, now time.Time) (StatsQuery, error) {
+	if query.Bucket == "" {
+		query.Bucket = BucketDay
+	}
+	if query.Bucket != BucketDay && query.Bucket != BucketWeek && query.Bucket != BucketMonth {
+		return StatsQuery{}, fmt.Errorf("unknown bucket %q", query.Bucket)
+	}
+	if query.UTCOffsetMinutes < -14*60 || query.UTCOffsetMinutes > 14*60 {
+		return StatsQuery{}, fmt.Errorf("UTC offset must be within 14 hours")
+	}
+
+	if query.To.IsZero() {
+		query.To = now
+	}
+	if query.From.IsZero() {
+		query.From = query.To.Add(-defaultStatsRange)
+	}
+	// Stored timestamps are compared to the second; see timeBound
+	query.From = query.From.UTC().Truncate(time.Second)
+	query.To = query.To.UTC().Truncate(time.Second)
+	if !query.From.Before(query.To) {
+		return StatsQuery{}, fmt.Errorf("statistics period must end after it starts")
+	}
+
+	if len(bucketStarts(query)) > maxStatsBuckets {
+		return StatsQuery{}, fmt.Errorf("period is too long for %s buckets", query.Bucket)
+	}
+	return query, nil
+}
+
+// timeBound formats a whole-second UTC time for comparing with stored
+// timestamps. Those are text with a varying number of fractional digits
+// followed by the zone; a bound cut before the fraction sorts before every
+// timestamp in its second and after every earlier one.
+func timeBound(t time.Time) string {
+	return t.UTC().Format("2006-01-02 15:04:05")
+}
+
+// bucketStarts lists the start of every bucket overlapping the period
+func bucketStarts(query StatsQuery) []time.Time {
+	zone := time.FixedZone("", query.UTCOffsetMinutes*60)
+	from := query.From.In(zone)
+
+	start := time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, zone)
+	switch query.Bucket {
+	case BucketWeek:
+		start = start.AddDate(0, 0, -((int(start.Weekday()) + 6) % 7))
+	case BucketMonth:
+		start = time.Date(from.Year(), from.Month(), 1, 0, 0, 0, 0, zone)
+	}
+
+	var starts []time.Time
+	for t := start; t.Before(query.To) && len(starts) <= maxStatsBuckets; {
+		starts = append(starts, t)
+		switch query.Bucket {
+		case BucketWeek:
+			t = t.AddDate(0, 0, 7)
+		case BucketMonth:
+			t = t.AddDate(0, 1, 0)
+		default:
+			t = t.AddDate(0, 0, 1)
+		}
+	}
+	return starts
+}
+
+// statsBuckets counts messages per bucket. Each bucket is a range count
+// on the timestamp index; grouping by a computed bucket key instead would
+// parse and sort every message in the period.
+func (d *Database) statsBuckets(query StatsQuery, localPeerID string) ([]StatsBucket, error) {
+	starts := bucketStarts(query)
+	bounds := make([][2]string, len(starts))
+	for i, start := range starts {
+		from, to := start, query.To
+		if i+1 < len(starts) {
+			to = starts[i+1]
+		}
+		if from.Before(query.From) {
+			from = query.From
+		}
+		bounds[i] = [2]string{timeBound(from), timeBound(to)}
+	}
+	encoded, err := json.Marshal(bounds)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode buckets: %w", err)
+	}
+
+	rows, err := d.conn().db.Query(`
+		SELECT b.key,
+			(SELECT COUNT(*) FROM messages
+				WHERE timestamp >= b.value ->> 0 AND timestamp < b.value ->> 1),
+			(SELECT COUNT(*) FROM messages
+				WHERE timestamp >= b.value ->> 0 AND timestamp < b.value ->> 1 AND sender_id = ?)
+		FROM json_each(?) b
+	`, localPeerID, string(encoded))
+	if err != nil {
+		return nil, fmt.Errorf("failed to count messages: %w", err)
+	}
+	defer rows.Close()
+
+	buckets := make([]StatsBucket, len(starts))
+	for rows.Next() {
+		var i int
+		var bucket StatsBucket
+		if err := rows.Scan(&i, &bucket.Messages, &bucket.Sent); err != nil {
+			return nil, fmt.Errorf("failed to scan message counts: %w", err)
+		}
+		bucket.Received = bucket.Messages - bucket.Sent
+		buckets[i] = bucket
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i, start := range starts {
+		buckets[i].Start = start
+	}
+	return buckets, nil
+}
+
+// busiestConversations ranks conversations by messages in the period,
+// counting each conversation along its own index
+func (d *Database) busiestConversations(query StatsQuery) ([]ConversationActivity, error) {
+	rows, err := d.conn().db.Query(`
+		SELECT id, messages,
+			(SELECT COUNT(DISTINCT sender_id) FROM messages
+				WHERE conversation_id = ranked.id AND timestamp >= ?2 AND timestamp < ?3)
+		FROM (
+			SELECT c.id,
+				(SELECT COUNT(*) FROM messages m
+					WHERE m.conversation_id = c.id AND m.timestamp >= ?2 AND m.timestamp < ?3) AS messages
+			FROM conversations c
+			ORDER BY messages DESC, c.id
+			LIMIT ?1
+		) ranked
+		WHERE messages > 0
+	`, maxBusiestConversations, timeBound(query.From), timeBound(query.To))
+	if err != nil {
+		return nil, fmt.Errorf("failed to rank conversations: %w", err)
+	}
+	defer rows.Close()
+
+	busiest := []ConversationActivity{}
+	for rows.Next() {
+		var activity ConversationActivity
+		if err := rows.Scan(&activity.ConversationID, &activity.Messages, &activity.Senders); err != nil {
+			return nil, fmt.Errorf("failed to scan conversation activity: %w", err)
+		}
+		busiest = append(busiest, activity)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	names, err := d.displayNames(nil)
+	if err != nil {
+		return nil, err
+	}
+	for i := range busiest {
+		conversation, err := d.GetConversation(busiest[i].ConversationID)
+		if err != nil {
+			return nil, err
+		}
+		busiest[i].Label = conversationLabel(conversation, names)
+	}
+	return busiest, nil
+}
+
+// responseTimes measures the gap before each message that answers someone
+// else, split into our responses and peers'. Broadcasts aren't
+// conversations in that sense and are left out.
+func (d *Database) responseTimes(query StatsQuery, localPeerID string) (ours, theirs ResponseTimes, err error) {
+	rows, err := d.conn().db.Query(`
+		SELECT sender_id = ? AS ours, COUNT(*), AVG(gap)
+		FROM (
+			SELECT sender_id,
+				LAG(sender_id) OVER w AS previous_sender,
+				seconds - LAG(seconds) OVER w AS gap
+			FROM (
+				SELECT id, conversation_id, sender_id, timestamp, julianday(timestamp) * 86400 AS seconds
+				FROM messages
+				WHERE timestamp >= ? AND timestamp < ? AND conversation_id != ?
+			)
+			WINDOW w AS (PARTITION BY conversation_id ORDER BY timestamp, id)
+		)
+		WHERE previous_sender IS NOT NULL AND previous_sender != sender_id AND gap <= ?
+		GROUP BY ours
+	`, localPeerID, timeBound(query.From), timeBound(query.To), BroadcastConversationID, maxResponseGap.Seconds())
+	if err != nil {
+		return ours, theirs, fmt.Errorf("failed to measure response times: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var isOurs bool
+		var times ResponseTimes
+		if err := rows.Scan(&isOurs, &times.Count, &times.AverageSeconds); err != nil {
+			return ours, theirs, fmt.Errorf("failed to scan response times: %w", err)
+		}
+		if isOurs {
+			ours = times
+		} else {
+			theirs = times
+		}
+	}
+	return ours, theirs, rows.Err()
+}
+
+// peerOnlineTimes adds up each peer's online sessions within the period
+// from its presence history. Each peer's last change before the period
+// seeds whether it was online at the start; a session still open counts up
+// to now.
+func (d *Database) peerOnlineTimes(query StatsQuery, now time.Time) ([]PeerOnlineTime, error) {
+	rows, err := d.conn().db.Query(`
+		WITH seeds AS (
+			SELECT MAX(id) AS id FROM peer_presence
+			WHERE changed_at < ?1
+			GROUP BY peer_id
+		)
+		SELECT peer_id, is_online, changed_at FROM peer_presence
+		WHERE (changed_at >= ?1 AND changed_at < ?2) OR id IN (SELECT id FROM seeds)
+		ORDER BY peer_id, id
+	`, timeBound(query.From), timeBound(query.To))
+	if err != nil {
+		return nil, fmt.Errorf("failed to query peer presence: %w", err)
+	}
+	defer rows.Close()
+
+	end := query.To
+	if now.Before(end) {
+		end = now
+	}
+
+	online := make(map[string]*PeerOnlineTime)
+	since := make(map[string]time.Time)
+	for rows.Next() {
+		var peerID string
+		var isOnline bool
+		var at time.Time
+		if err := rows.Scan(&peerID, &isOnline, &at); err != nil {
+			return nil, fmt.Errorf("failed to scan peer presence: %w", err)
+		}
+
+		started, wasOnline := since[peerID]
+		switch {
+		case isOnline && !wasOnline:
+			since[peerID] = at
+		case !isOnline && wasOnline:
+			addOnlineTime(online, peerID, started, at, query.From, end)
+			delete(since, peerID)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	for peerID, started := range since {
+		addOnlineTime(online, peerID, started, end, query.From, end)
+	}
+
+	peers, err := d.GetPeers()
+	if err != nil {
+		return nil, err
+	}
+	for _, peer := range peers {
+		if t, ok := online[peer.PeerID]; ok {
+			t.Name = peer.DisplayName()
+		}
+	}
+
+	times := []PeerOnlineTime{}
+	for _, t := range online {
+		times = append(times, *t)
+	}
+	sort.Slice(times, func(i, j int) bool {
+		if times[i].OnlineHours != times[j].OnlineHours {
+			return times[i].OnlineHours > times[j].OnlineHours
+		}
+		return times[i].PeerID < times[j].PeerID
+	})
+	return times, nil
+}
+
+// addOnlineTime adds the part of a session from start to stop that falls
+// between from and to
+func addOnlineTime(online map[string]*PeerOnlineTime, peerID string, start, stop, from, to time.Time) {
+	if start.Before(from) {
+		start = from
+	}
+	if stop.After(to) {
+		stop = to
+	}
+	if !stop.After(start) {
+		return
+	}
+
+	t, ok := online[peerID]
+	if !ok {
+		t = &PeerOnlineTime{PeerID: peerID, Name: peerID}
+		online[peerID] = t
+	}
+	t.OnlineHours += stop.Sub(start).Hours()
+	t.Sessions++
+}
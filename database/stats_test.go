@@ -0,0 +1,182 @@
+package database
+
+import (
+	"database/sql"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestPeerOnlineTimes(t *testing.T) {
+	from := time.Date(2026, 1, 10, 0, 0, 0, 0, time.UTC)
+	to := from.Add(24 * time.Hour)
+
+	type change struct {
+		peerID   string
+		isOnline bool
+		at       time.Time
+	}
+	tests := []struct {
+		name    string
+		changes []change
+		now     time.Time
+		want    map[string]PeerOnlineTime
+	}{
+		{
+			name: "session inside the period",
+			changes: []change{
+				{"peer", true, from.Add(time.Hour)},
+				{"peer", false, from.Add(3 * time.Hour)},
+			},
+			now:  to.Add(time.Hour),
+			want: map[string]PeerOnlineTime{"peer": {OnlineHours: 2, Sessions: 1}},
+		},
+		{
+			// Only the last change before the period says the peer was
+			// online when it started
+			name: "online since before the period",
+			changes: []change{
+				{"peer", true, from.AddDate(0, 0, -5)},
+				{"peer", false, from.AddDate(0, 0, -4)},
+				{"peer", true, from.Add(-time.Hour)},
+				{"peer", false, from.Add(2 * time.Hour)},
+			},
+			now:  to.Add(time.Hour),
+			want: map[string]PeerOnlineTime{"peer": {OnlineHours: 2, Sessions: 1}},
+		},
+		{
+			name: "offline since before the period",
+			changes: []change{
+				{"peer", true, from.AddDate(0, 0, -2)},
+				{"peer", false, from.AddDate(0, 0, -1)},
+				{"other", true, from.Add(-time.Hour)},
+				{"other", false, from.Add(-time.Minute)},
+			},
+			now:  to.Add(time.Hour),
+			want: map[string]PeerOnlineTime{},
+		},
+		{
+			name: "session still open",
+			changes: []change{
+				{"peer", true, from.Add(-time.Hour)},
+				{"other", true, from.Add(20 * time.Hour)},
+			},
+			now: from.Add(22 * time.Hour),
+			want: map[string]PeerOnlineTime{
+				"peer":  {OnlineHours: 22, Sessions: 1},
+				"other": {OnlineHours: 2, Sessions: 1},
+			},
+		},
+		{
+			name: "changes after the period",
+			changes: []change{
+				{"peer", true, from.Add(23 * time.Hour)},
+				{"peer", false, to.Add(time.Hour)},
+			},
+			now:  to.Add(2 * time.Hour),
+			want: map[string]PeerOnlineTime{"peer": {OnlineHours: 1, Sessions: 1}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := newTestDatabase(t)
+			err := d.withTx(func(tx *sql.Tx) error {
+				for _, c := range tt.changes {
+					if err := recordPresence(tx, c.peerID, c.isOnline, c.at); err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("failed to record presence: %v", err)
+			}
+
+			times, err := d.peerOnlineTimes(StatsQuery{From: from, To: to}, tt.now)
+			if err != nil {
+				t.Fatalf("failed to add up online time: %v", err)
+			}
+			if len(times) != len(tt.want) {
+				t.Fatalf("got %+v, want %+v", times, tt.want)
+			}
+			for _, got := range times {
+				want, ok := tt.want[got.PeerID]
+				if !ok || math.Abs(got.OnlineHours-want.OnlineHours) > 1e-9 || got.Sessions != want.Sessions {
+					t.Fatalf("got %+v, want %+v", times, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestGetStatistics(t *testing.T) {
+	d := newTestDatabase(t)
+	from := time.Date(2026, 1, 10, 0, 0, 0, 0, time.UTC)
+
+	// Two received messages on the first day and one on the third, each
+	// answered a minute later, and one message before the period
+	insertMessages(t, d, "peer", from.Add(-time.Hour), 1)
+	for _, at := range []time.Time{from.Add(time.Hour), from.Add(2 * time.Hour), from.AddDate(0, 0, 2)} {
+		insertMessages(t, d, "peer", at, 1)
+		if _, err := d.InsertMessage(Message{PeerID: "peer", SenderID: "me", Content: "reply", Timestamp: at.Add(time.Minute)}); err != nil {
+			t.Fatalf("failed to insert reply: %v", err)
+		}
+	}
+
+	stats, err := d.GetStatistics(StatsQuery{From: from, To: from.AddDate(0, 0, 3)}, "me")
+	if err != nil {
+		t.Fatalf("failed to get statistics: %v", err)
+	}
+
+	if stats.TotalMessages != 6 {
+		t.Errorf("got %d messages, want 6", stats.TotalMessages)
+	}
+	wantBuckets := []StatsBucket{
+		{Start: from, Messages: 4, Sent: 2, Received: 2},
+		{Start: from.AddDate(0, 0, 1)},
+		{Start: from.AddDate(0, 0, 2), Messages: 2, Sent: 1, Received: 1},
+	}
+	if len(stats.Buckets) != len(wantBuckets) {
+		t.Fatalf("got buckets %+v, want %+v", stats.Buckets, wantBuckets)
+	}
+	for i, want := range wantBuckets {
+		got := stats.Buckets[i]
+		if !got.Start.Equal(want.Start) || got.Messages != want.Messages || got.Sent != want.Sent || got.Received != want.Received {
+			t.Errorf("got bucket %+v, want %+v", got, want)
+		}
+	}
+
+	if len(stats.BusiestConversations) != 1 || stats.BusiestConversations[0].Messages != 6 || stats.BusiestConversations[0].Senders != 2 {
+		t.Errorf("got busiest %+v, want the direct chat with 6 messages from 2 senders", stats.BusiestConversations)
+	}
+	if stats.OurResponses.Count != 3 || math.Abs(stats.OurResponses.AverageSeconds-60) > 0.01 {
+		t.Errorf("got our responses %+v, want 3 after a minute", stats.OurResponses)
+	}
+	// Only the second message is within maxResponseGap of our reply
+	if stats.TheirResponses.Count != 1 {
+		t.Errorf("got their responses %+v, want 1", stats.TheirResponses)
+	}
+}
+
+func TestStatsQueryRefused(t *testing.T) {
+	now := time.Date(2026, 1, 10, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name  string
+		query StatsQuery
+	}{
+		{"unknown bucket", StatsQuery{Bucket: "year"}},
+		{"offset out of range", StatsQuery{UTCOffsetMinutes: 15 * 60}},
+		{"ends before it starts", StatsQuery{From: now, To: now.Add(-time.Hour)}},
+		{"too many buckets", StatsQuery{From: now.AddDate(-5, 0, 0), To: now}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := normalizeStatsQuery(tt.query, now); err == nil {
+				t.Fatal("query accepted")
+			}
+		})
+	}
+}
@@ -0,0 +1,187 @@
+package database
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	// maxThreadDepth bounds how far replies are followed, so a reply
+	// cycle forged by a peer can't loop forever
+	maxThreadDepth = 100
+	// maxQuoteLength is how many characters of a parent are quoted
+	maxQuoteLength = 140
+)
+
+// Quote is an excerpt of the message a reply answers. Missing is set when
+// that message isn't stored here, for example because it was sent before
+// we joined or has since been pruned, so the reply can show a stub.
+type Quote struct {
+	MessageID string     `json:"message_id"`
+	SenderID  string     `json:"sender_id,omitempty"`
+	Excerpt   string     `json:"excerpt,omitempty"`
+	Timestamp *time.Time `json:"timestamp,omitempty"`
+	Deleted   bool       `json:"deleted,omitempty"`
+	Missing   bool       `json:"missing,omitempty"`
+}
+
+// Thread is a message that was replied to and every reply below it, in
+// chronological order. When the root isn't stored here, Root only holds
+// its message ID and conversation and RootMissing is set.
+type Thread struct {
+	Root        Message   `json:"root"`
+	RootMissing bool      `json:"root_missing"`
+	Replies     []Message `json:"replies"`
+}
+
+// GetThread returns the thread a message belongs to, by row ID. Replies
+// only count towards a thread within the same conversation.
+func (d *Database) GetThread(id int64) (Thread, error) {
+	if d.IsLocked() {
+		return Thread{}, ErrLocked
+	}
+
+	msg, err := d.GetMessage(id)
+	if err != nil {
+		return Thread{}, err
+	}
+
+	// Walk up to the oldest ancestor stored here
+	var topID, topParentID string
+	err = d.conn().db.QueryRow(`
+		WITH RECURSIVE ancestors(message_id, parent_id, depth) AS (
+			SELECT message_id, parent_id, 0 FROM messages WHERE id = ?
+			UNION ALL
+			SELECT m.message_id, m.parent_id, a.depth + 1
+			FROM messages m JOIN ancestors a ON m.message_id = a.parent_id
+			WHERE m.conversation_id = ? AND a.depth < ?
+		)
+		SELECT message_id, COALESCE(parent_id, '') FROM ancestors
+		ORDER BY depth DESC LIMIT 1
+	`, id, msg.ConversationID, maxThreadDepth).Scan(&topID, &topParentID)
+	if err != nil {
+		return Thread{}, fmt.Errorf("failed to find thread root: %w", err)
+	}
+
+	thread := Thread{Replies: []Message{}}
+	rootID := topID
+	if topParentID != "" {
+		rootID = topParentID
+		thread.RootMissing = true
+		thread.Root = Message{MessageID: rootID, ConversationID: msg.ConversationID}
+	}
+
+	rows, err := d.conn().db.Query(`
+		WITH RECURSIVE replies(id, message_id, depth) AS (
+			SELECT id, message_id, 1 FROM messages WHERE parent_id = ?1 AND conversation_id = ?2
+			UNION
+			SELECT m.id, m.message_id, r.depth + 1
+			FROM messages m JOIN replies r ON m.parent_id = r.message_id
+			WHERE m.conversation_id = ?2 AND r.depth < ?3
+		)
+		SELECT `+messageColumns+` FROM messages
+		WHERE id IN (SELECT id FROM replies) OR (message_id = ?1 AND conversation_id = ?2)
+		ORDER BY timestamp, id
+	`, rootID, msg.ConversationID, maxThreadDepth)
+	if err != nil {
+		return Thread{}, fmt.Errorf("failed to query thread: %w", err)
+	}
+	defer rows.Close()
+
+	messages, err := d.scanMessages(rows)
+	if err != nil {
+		return Thread{}, err
+	}
+	rows.Close()
+	if err := d.attachDetails(messages); err != nil {
+		return Thread{}, err
+	}
+
+	for _, m := range messages {
+		if m.MessageID == rootID {
+			thread.Root = m
+		} else {
+			thread.Replies = append(thread.Replies, m)
+		}
+	}
+	return thread, nil
+}
+
+// attachDetails fills in the reactions, quoted parents, pins and stars of
+// messages. Callers must have closed the rows the messages came from.
+func (d *Database) attachDetails(messages []Message) error {
+	if err := d.attachReactions(messages); err != nil {
+		return err
+	}
+	if err := d.attachQuotes(messages); err != nil {
+		return err
+	}
+	return d.attachMarks(messages)
+}
+
+// attachQuotes quotes the parent of each reply that hasn't been deleted.
+// Parents from another conversation are treated as missing, so a reply
+// can't be used to reveal a message to peers outside its conversation.
+func (d *Database) attachQuotes(messages []Message) error {
+	var ids []interface{}
+	for _, msg := range messages {
+		if msg.ParentID != "" && msg.DeletedAt == nil {
+			ids = append(ids, msg.ParentID)
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(ids)), ", ")
+	rows, err := d.conn().db.Query(`SELECT `+messageColumns+` FROM messages WHERE message_id IN (`+placeholders+`)`, ids...)
+	if err != nil {
+		return fmt.Errorf("failed to query quoted messages: %w", err)
+	}
+	defer rows.Close()
+
+	parents, err := d.scanMessages(rows)
+	if err != nil {
+		return err
+	}
+	byID := make(map[string]Message, len(parents))
+	for _, parent := range parents {
+		byID[parent.MessageID] = parent
+	}
+
+	for i := range messages {
+		msg := &messages[i]
+		if msg.ParentID == "" || msg.DeletedAt != nil {
+			continue
+		}
+
+		parent, ok := byID[msg.ParentID]
+		if !ok || parent.ConversationID != msg.ConversationID {
+			msg.Quote = &Quote{MessageID: msg.ParentID, Missing: true}
+			continue
+		}
+		msg.Quote = &Quote{
+			MessageID: parent.MessageID,
+			SenderID:  parent.SenderID,
+			Excerpt:   quoteExcerpt(parent.Content),
+			Timestamp: &parent.Timestamp,
+			Deleted:   parent.DeletedAt != nil,
+		}
+	}
+	return nil
+}
+
+// quoteExcerpt shortens content to its first line, cut at maxQuoteLength
+func quoteExcerpt(content string) string {
+	content, _, cut := strings.Cut(strings.TrimSpace(content), "\n")
+	if utf8.RuneCountInString(content) > maxQuoteLength {
+		content = string([]rune(content)[:maxQuoteLength])
+		cut = true
+	}
+	if cut {
+		content = strings.TrimSpace(content) + "…"
+	}
+	return content
+}
@@ -0,0 +1,164 @@
+package database
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// insertReply stores a message from peer in its direct chat, replying to
+// parentID, and returns its row ID
+func insertReply(t *testing.T, d *Database, peerID, messageID, parentID string, at time.Time) int64 {
+	t.Helper()
+
+	id, err := d.InsertMessage(Message{
+		MessageID: messageID,
+		PeerID:    peerID,
+		SenderID:  peerID,
+		Content:   "text of " + messageID,
+		Timestamp: at,
+		ParentID:  parentID,
+	})
+	if err != nil {
+		t.Fatalf("failed to insert %s: %v", messageID, err)
+	}
+	return id
+}
+
+// threadIDs returns the message IDs of a thread's replies
+func threadIDs(thread Thread) []string {
+	var ids []string
+	for _, msg := range thread.Replies {
+		ids = append(ids, msg.MessageID)
+	}
+	return ids
+}
+
+func TestGetThread(t *testing.T) {
+	d := newTestDatabase(t)
+	at := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	root := insertReply(t, d, "peer", "root", "", at)
+	insertReply(t, d, "peer", "first", "root", at.Add(time.Minute))
+	nested := insertReply(t, d, "peer", "nested", "first", at.Add(3*time.Minute))
+	insertReply(t, d, "peer", "second", "root", at.Add(2*time.Minute))
+	insertReply(t, d, "peer", "unrelated", "", at.Add(4*time.Minute))
+
+	// A reply from another conversation to the same message ID isn't part
+	// of the thread
+	insertReply(t, d, "other", "elsewhere", "root", at.Add(time.Minute))
+
+	orphan := insertReply(t, d, "peer", "orphan", "never-seen", at)
+	insertReply(t, d, "peer", "orphan-reply", "orphan", at.Add(time.Minute))
+
+	// A reply cycle forged by a peer still ends
+	cycle := insertReply(t, d, "peer", "cycle-a", "cycle-b", at)
+	insertReply(t, d, "peer", "cycle-b", "cycle-a", at.Add(time.Minute))
+
+	tests := []struct {
+		name        string
+		id          int64
+		wantRoot    string
+		wantMissing bool
+		wantReplies []string
+	}{
+		{"from the root", root, "root", false, []string{"first", "second", "nested"}},
+		{"from a nested reply", nested, "root", false, []string{"first", "second", "nested"}},
+		{"root not stored here", orphan, "never-seen", true, []string{"orphan", "orphan-reply"}},
+		{"reply cycle", cycle, "", false, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			thread, err := d.GetThread(tt.id)
+			if err != nil {
+				t.Fatalf("failed to get thread: %v", err)
+			}
+			if tt.wantRoot == "" {
+				// Where a cycle is cut depends on the walk; it must only
+				// hold the two messages
+				if len(thread.Replies) > 2 {
+					t.Fatalf("got %d replies in a two-message cycle", len(thread.Replies))
+				}
+				return
+			}
+			if thread.Root.MessageID != tt.wantRoot || thread.RootMissing != tt.wantMissing {
+				t.Fatalf("got root %q (missing %v), want %q (missing %v)",
+					thread.Root.MessageID, thread.RootMissing, tt.wantRoot, tt.wantMissing)
+			}
+			if got := threadIDs(thread); strings.Join(got, ",") != strings.Join(tt.wantReplies, ",") {
+				t.Fatalf("got replies %v, want %v", got, tt.wantReplies)
+			}
+		})
+	}
+}
+
+func TestQuotes(t *testing.T) {
+	d := newTestDatabase(t)
+	at := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	insertReply(t, d, "peer", "parent", "", at)
+	insertReply(t, d, "peer", "deleted-parent", "", at)
+	insertReply(t, d, "other", "private", "", at)
+	if err := d.DeleteMessage("deleted-parent", "peer", at.Add(time.Minute)); err != nil {
+		t.Fatalf("failed to delete message: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		parentID string
+		want     Quote
+	}{
+		{"stored parent", "parent", Quote{MessageID: "parent", SenderID: "peer", Excerpt: "text of parent"}},
+		{"deleted parent", "deleted-parent", Quote{MessageID: "deleted-parent", SenderID: "peer", Deleted: true}},
+		{"parent not stored here", "never-seen", Quote{MessageID: "never-seen", Missing: true}},
+		// Quoting it would reveal a message to peers outside its conversation
+		{"parent in another conversation", "private", Quote{MessageID: "private", Missing: true}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id := insertReply(t, d, "peer", "reply to "+tt.parentID, tt.parentID, at.Add(time.Hour))
+			msg, err := d.GetMessage(id)
+			if err != nil {
+				t.Fatalf("failed to load reply: %v", err)
+			}
+			messages := []Message{msg}
+			if err := d.attachQuotes(messages); err != nil {
+				t.Fatalf("failed to attach quote: %v", err)
+			}
+			got := messages[0].Quote
+			if got == nil {
+				t.Fatal("reply has no quote")
+			}
+			if got.MessageID != tt.want.MessageID || got.SenderID != tt.want.SenderID ||
+				got.Excerpt != tt.want.Excerpt || got.Deleted != tt.want.Deleted || got.Missing != tt.want.Missing {
+				t.Fatalf("got quote %+v, want %+v", *got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQuoteExcerpt(t *testing.T) {
+	long := strings.Repeat("é", maxQuoteLength+5)
+
+	tests := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{"short", "hello", "hello"},
+		{"surrounding space", "  hello \n", "hello"},
+		{"first line only", "hello\nworld", "hello…"},
+		{"exactly the limit", long[:2*maxQuoteLength], long[:2*maxQuoteLength]},
+		{"cut by characters", long, long[:2*maxQuoteLength] + "…"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := quoteExcerpt(tt.content); got != tt.want {
+				t.Fatalf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
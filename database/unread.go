@@ -0,0 +1,187 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// ConversationSummary is a conversation with its latest message, for
+// rendering a conversation list
+type ConversationSummary struct {
+	Conversation
+	LastMessage *Message `json:"last_message"`
+}
+
+// UnreadCounts are the unread messages per conversation. Total leaves out
+// muted conversations so it can drive a tray badge directly.
+type UnreadCounts struct {
+	Total         int            `json:"total"`
+	Conversations map[string]int `json:"conversations"`
+}
+
+// MarkMessageAsRead marks a message as read
+func (d *Database) MarkMessageAsRead(messageID int64) error {
+	return d.withTx(func(tx *sql.Tx) error {
+		var conversationID string
+		err := tx.QueryRow(`
+			UPDATE messages SET is_read = 1
+			WHERE id = ? AND is_read = 0
+			RETURNING conversation_id
+		`, messageID).Scan(&conversationID)
+		if err == sql.ErrNoRows {
+			return nil // already read or missing
+		}
+		if err != nil {
+			return fmt.Errorf("failed to mark message as read: %w", err)
+		}
+
+		return adjustUnread(tx, conversationID, -1)
+	})
+}
+
+// MarkConversationRead marks every message in a conversation up to and
+// including upToMessageID as read; zero marks the whole conversation
+func (d *Database) MarkConversationRead(conversationID string, upToMessageID int64) error {
+	return d.withTx(func(tx *sql.Tx) error {
+		var result sql.Result
+		var err error
+
+		if upToMessageID == 0 {
+			result, err = tx.Exec(`
+				UPDATE messages SET is_read = 1
+				WHERE conversation_id = ? AND is_read = 0
+			`, conversationID)
+		} else {
+			result, err = tx.Exec(`
+				UPDATE messages SET is_read = 1
+				WHERE conversation_id = ? AND is_read = 0
+					AND (timestamp, id) <= ((SELECT timestamp FROM messages WHERE id = ?), ?)
+			`, conversationID, upToMessageID, upToMessageID)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to mark conversation as read: %w", err)
+		}
+
+		marked, err := result.RowsAffected()
+		if err != nil {
+			return fmt.Errorf("failed to mark conversation as read: %w", err)
+		}
+		if marked == 0 {
+			return nil
+		}
+		return adjustUnread(tx, conversationID, -int(marked))
+	})
+}
+
+// MarkOwnMessagesRead marks every message sent by localPeerID as read and
+// recounts unread messages. Versions that stored our group and broadcast
+// messages as unread left them counted; this runs once per database.
+func (d *Database) MarkOwnMessagesRead(localPeerID string) error {
+	err := d.withTx(func(tx *sql.Tx) error {
+		var done int
+		if err := tx.QueryRow(`SELECT COUNT(*) FROM meta WHERE key = 'unread.own_marked'`).Scan(&done); err != nil {
+			return err
+		}
+		if done > 0 {
+			return nil
+		}
+
+		_, err := tx.Exec(`
+			UPDATE messages SET is_read = 1 WHERE sender_id = ? AND is_read = 0;
+
+			UPDATE conversations SET unread_count = (
+				SELECT COUNT(*) FROM messages
+				WHERE messages.conversation_id = conversations.id AND messages.is_read = 0
+			);
+		`, localPeerID)
+		if err != nil {
+			return err
+		}
+		return setMeta(tx, "unread.own_marked", "1")
+	})
+	if err != nil {
+		return fmt.Errorf("failed to mark own messages as read: %w", err)
+	}
+	return nil
+}
+
+// adjustUnread changes a conversation's unread counter, never below zero
+func adjustUnread(tx *sql.Tx, conversationID string, delta int) error {
+	_, err := tx.Exec(`
+		UPDATE conversations SET unread_count = MAX(unread_count + ?, 0)
+		WHERE id = ?
+	`, delta, conversationID)
+	if err != nil {
+		return fmt.Errorf("failed to update unread count: %w", err)
+	}
+	return nil
+}
+
+// GetUnreadCounts returns the unread counters of every conversation
+func (d *Database) GetUnreadCounts() (UnreadCounts, error) {
+	rows, err := d.conn().db.Query(`SELECT id, unread_count, muted FROM conversations WHERE unread_count > 0`)
+	if err != nil {
+		return UnreadCounts{}, fmt.Errorf("failed to query unread counts: %w", err)
+	}
+	defer rows.Close()
+
+	counts := UnreadCounts{Conversations: make(map[string]int)}
+	for rows.Next() {
+		var conversationID string
+		var unread int
+		var muted bool
+		if err := rows.Scan(&conversationID, &unread, &muted); err != nil {
+			return UnreadCounts{}, fmt.Errorf("failed to scan unread count: %w", err)
+		}
+		counts.Conversations[conversationID] = unread
+		if !muted {
+			counts.Total += unread
+		}
+	}
+
+	return counts, rows.Err()
+}
+
+// GetConversationSummaries returns every conversation with its latest
+// message and unread count, most recently active first
+func (d *Database) GetConversationSummaries() ([]ConversationSummary, error) {
+	if d.IsLocked() {
+		return nil, ErrLocked
+	}
+
+	conversations, err := d.GetConversations()
+	if err != nil {
+		return nil, err
+	}
+
+	// Each lookup is a single seek on the (conversation_id, timestamp, id) index
+	query := `
+		SELECT ` + messageColumns + `
+		FROM messages
+		WHERE conversation_id = ?
+		ORDER BY timestamp DESC, id DESC
+		LIMIT 1
+	`
+
+	summaries := make([]ConversationSummary, 0, len(conversations))
+	for _, conversation := range conversations {
+		summary := ConversationSummary{Conversation: conversation}
+
+		rows, err := d.conn().db.Query(query, conversation.ID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to query last message: %w", err)
+		}
+		messages, err := d.scanMessages(rows)
+		rows.Close()
+		if err != nil {
+			return nil, err
+		}
+		if len(messages) > 0 {
+			summary.LastMessage = &messages[0]
+		}
+
+		summaries = append(summaries, summary)
+	}
+
+	return summaries, nil
+}
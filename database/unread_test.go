@@ -0,0 +1,129 @@
+package database
+
+import (
+	"testing"
+	"time"
+)
+
+// assertUnread checks a conversation's unread counter
+func assertUnread(t *testing.T, d *Database, conversationID string, want int) {
+	t.Helper()
+
+	conversation, err := d.GetConversation(conversationID)
+	if err != nil {
+		t.Fatalf("failed to load conversation: %v", err)
+	}
+	if conversation.UnreadCount != want {
+		t.Fatalf("got %d unread in %s, want %d", conversation.UnreadCount, conversationID, want)
+	}
+}
+
+func TestMarkRead(t *testing.T) {
+	start := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	conversationID := DirectConversationID("peer")
+
+	tests := []struct {
+		name string
+		mark func(d *Database, ids []int64) error
+		want int
+	}{
+		{"one message", func(d *Database, ids []int64) error {
+			return d.MarkMessageAsRead(ids[1])
+		}, 3},
+		{"one message twice", func(d *Database, ids []int64) error {
+			if err := d.MarkMessageAsRead(ids[1]); err != nil {
+				return err
+			}
+			return d.MarkMessageAsRead(ids[1])
+		}, 3},
+		{"missing message", func(d *Database, ids []int64) error {
+			return d.MarkMessageAsRead(ids[3] + 100)
+		}, 4},
+		{"up to a message", func(d *Database, ids []int64) error {
+			return d.MarkConversationRead(conversationID, ids[2])
+		}, 1},
+		{"up to a message after reading one", func(d *Database, ids []int64) error {
+			if err := d.MarkMessageAsRead(ids[0]); err != nil {
+				return err
+			}
+			return d.MarkConversationRead(conversationID, ids[2])
+		}, 1},
+		{"whole conversation", func(d *Database, ids []int64) error {
+			return d.MarkConversationRead(conversationID, 0)
+		}, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := newTestDatabase(t)
+			ids := insertMessages(t, d, "peer", start, 4)
+			assertUnread(t, d, conversationID, 4)
+
+			if err := tt.mark(d, ids); err != nil {
+				t.Fatalf("failed to mark read: %v", err)
+			}
+			assertUnread(t, d, conversationID, tt.want)
+		})
+	}
+}
+
+func TestGetUnreadCounts(t *testing.T) {
+	d := newTestDatabase(t)
+	start := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	insertMessages(t, d, "peer", start, 2)
+	insertMessages(t, d, "other", start, 3)
+	insertMessages(t, d, "quiet", start, 1)
+	if err := d.MarkConversationRead(DirectConversationID("quiet"), 0); err != nil {
+		t.Fatalf("failed to mark read: %v", err)
+	}
+
+	// A muted conversation keeps its count but is left out of the total
+	if err := d.UpdateConversationSettings(DirectConversationID("other"), ConversationSettings{Muted: true}); err != nil {
+		t.Fatalf("failed to mute: %v", err)
+	}
+
+	counts, err := d.GetUnreadCounts()
+	if err != nil {
+		t.Fatalf("failed to get unread counts: %v", err)
+	}
+	if counts.Total != 2 {
+		t.Errorf("got total %d, want 2", counts.Total)
+	}
+	want := map[string]int{DirectConversationID("peer"): 2, DirectConversationID("other"): 3}
+	if len(counts.Conversations) != len(want) {
+		t.Fatalf("got %v, want %v", counts.Conversations, want)
+	}
+	for id, n := range want {
+		if counts.Conversations[id] != n {
+			t.Fatalf("got %v, want %v", counts.Conversations, want)
+		}
+	}
+}
+
+func TestMarkOwnMessagesRead(t *testing.T) {
+	d := newTestDatabase(t)
+	conversationID := DirectConversationID("peer")
+	at := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	// As stored by versions that left our own messages unread
+	for _, sender := range []string{"me", "me", "peer"} {
+		if _, err := d.InsertMessage(Message{PeerID: "peer", SenderID: sender, Content: "hello", Timestamp: at}); err != nil {
+			t.Fatalf("failed to insert message: %v", err)
+		}
+	}
+	assertUnread(t, d, conversationID, 3)
+
+	if err := d.MarkOwnMessagesRead("me"); err != nil {
+		t.Fatalf("failed to mark own messages read: %v", err)
+	}
+	assertUnread(t, d, conversationID, 1)
+
+	// It runs once, so later messages keep whatever state they were given
+	if _, err := d.InsertMessage(Message{PeerID: "peer", SenderID: "me", Content: "again", Timestamp: at}); err != nil {
+		t.Fatalf("failed to insert message: %v", err)
+	}
+	if err := d.MarkOwnMessagesRead("me"); err != nil {
+		t.Fatalf("failed to mark own messages read: %v", err)
+	}
+	assertUnread(t, d, conversationID, 2)
+}
@@ -0,0 +1,214 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	// writeQueueSize is how many writes may wait for the writer goroutine
+	writeQueueSize = 256
+	// maxWriteBatch bounds how many queued writes share one transaction
+	maxWriteBatch = 64
+	// writeQueueTimeout is how long a write waits for room in a full queue
+	writeQueueTimeout = 5 * time.Second
+)
+
+var (
+	// ErrWriteQueueFull is returned when the writer is too far behind to
+	// accept another write in time
+	ErrWriteQueueFull = errors.New("database write queue is full")
+	// ErrClosed is returned for writes made after the database is closed
+	ErrClosed = errors.New("database is closed")
+)
+
+// writeJob is one unit of work for the writer goroutine. onCommit, if set,
+// runs on the writer goroutine once the job's transaction has committed,
+// before any later write; such a job always ends its batch.
+type writeJob struct {
+	fn       func(tx *sql.Tx) error
+	onCommit func()
+	done     chan error
+}
+
+// writer serializes every write to the database through one connection.
+// Queued jobs are committed together in batches, each inside its own
+// savepoint so one failing job doesn't roll back the others.
+type writer struct {
+	db    *sql.DB
+	jobs  chan writeJob
+	stop  chan struct{}
+	ended chan struct{}
+	once  sync.Once
+}
+
+// newWriter starts the writer goroutine on db, which must be limited to a
+// single connection
+func newWriter(db *sql.DB) *writer {
+	w := &writer{
+		db:    db,
+		jobs:  make(chan writeJob, writeQueueSize),
+		stop:  make(chan struct{}),
+		ended: make(chan struct{}),
+	}
+	go w.run()
+	return w
+}
+
+// submit queues a job and waits for its result. When the queue is full it
+// applies backpressure, waiting up to writeQueueTimeout for room.
+func (w *writer) submit(job writeJob) error {
+	job.done = make(chan error, 1)
+
+	select {
+	case w.jobs <- job:
+	default:
+		timer := time.NewTimer(writeQueueTimeout)
+		defer timer.Stop()
+
+		select {
+		case w.jobs <- job:
+		case <-w.ended:
+			return ErrClosed
+		case <-timer.C:
+			return ErrWriteQueueFull
+		}
+	}
+
+	select {
+	case err := <-job.done:
+		return err
+	case <-w.ended:
+		// The job may have finished just before the writer stopped
+		select {
+		case err := <-job.done:
+			return err
+		default:
+			return ErrClosed
+		}
+	}
+}
+
+// close stops the writer after the batch in progress, failing queued
+// jobs. Closing it again waits for the first close.
+func (w *writer) close() {
+	w.once.Do(func() { close(w.stop) })
+	<-w.ended
+}
+
+// run commits queued jobs until the writer is stopped
+func (w *writer) run() {
+	defer close(w.ended)
+
+	for {
+		select {
+		case <-w.stop:
+			w.failQueued()
+			return
+		case job := <-w.jobs:
+			w.commit(w.collect(job))
+		}
+	}
+}
+
+// collect gathers whatever else is already queued behind job into a batch
+func (w *writer) collect(job writeJob) []writeJob {
+	batch := []writeJob{job}
+	for job.onCommit == nil && len(batch) < maxWriteBatch {
+		select {
+		case job = <-w.jobs:
+			batch = append(batch, job)
+		default:
+			return batch
+		}
+	}
+	return batch
+}
+
+// commit runs a batch in one transaction and reports each job's result
+func (w *writer) commit(batch []writeJob) {
+	results := make([]error, len(batch))
+
+	err := func() error {
+		tx, err := w.db.Begin()
+		if err != nil {
+			return fmt.Errorf("failed to begin transaction: %w", err)
+		}
+		defer tx.Rollback()
+
+		for i, job := range batch {
+			if _, err := tx.Exec(`SAVEPOINT job`); err != nil {
+				return fmt.Errorf("failed to begin write: %w", err)
+			}
+			if results[i] = job.fn(tx); results[i] != nil {
+				if _, err := tx.Exec(`ROLLBACK TO job`); err != nil {
+					return fmt.Errorf("failed to roll back write: %w", err)
+				}
+			}
+			if _, err := tx.Exec(`RELEASE job`); err != nil {
+				return fmt.Errorf("failed to finish write: %w", err)
+			}
+		}
+
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("failed to commit transaction: %w", err)
+		}
+		return nil
+	}()
+	if err != nil && len(batch) > 1 {
+		log.Printf("Error committing batch of %d writes: %v", len(batch), err)
+	}
+
+	for i, job := range batch {
+		if err != nil {
+			job.done <- err
+			continue
+		}
+		if results[i] == nil && job.onCommit != nil {
+			job.onCommit()
+		}
+		job.done <- results[i]
+	}
+}
+
+// failQueued rejects jobs still waiting when the writer stops
+func (w *writer) failQueued() {
+	for {
+		select {
+		case job := <-w.jobs:
+			job.done <- ErrClosed
+		default:
+			return
+		}
+	}
+}
+
+// withTx runs fn inside a transaction on the writer goroutine, committing
+// only if it succeeds. fn may share its transaction with other queued
+// writes, so it must not take keyMu for writing or wait on other writes.
+func (d *Database) withTx(fn func(tx *sql.Tx) error) error {
+	return d.conn().writer.submit(writeJob{fn: fn})
+}
+
+// exec runs a single write statement on the writer goroutine
+func (d *Database) exec(query string, args ...interface{}) (sql.Result, error) {
+	var result sql.Result
+	err := d.withTx(func(tx *sql.Tx) error {
+		var err error
+		result, err = tx.Exec(query, args...)
+		return err
+	})
+	return result, err
+}
+
+// execFunc adapts a function such as Database.exec to execer
+type execFunc func(query string, args ...interface{}) (sql.Result, error)
+
+// Exec implements execer
+func (f execFunc) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return f(query, args...)
+}
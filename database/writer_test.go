@@ -0,0 +1,271 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newTestWriter starts a writer on a fresh database with a single table
+func newTestWriter(t *testing.T) (*writer, *sql.DB) {
+	t.Helper()
+
+	h, err := openHandles(filepath.Join(t.TempDir(), "writer.db"))
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	t.Cleanup(func() { h.close() })
+
+	if _, err := h.writeDB.Exec(`CREATE TABLE items (name TEXT NOT NULL UNIQUE)`); err != nil {
+		t.Fatalf("failed to create table: %v", err)
+	}
+	return h.writer, h.db
+}
+
+// blockWriter occupies the writer goroutine until the returned function is
+// called, so jobs queued meanwhile are committed together in one batch
+func blockWriter(t *testing.T, w *writer) func() {
+	t.Helper()
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	done := make(chan error, 1)
+	go func() {
+		done <- w.submit(writeJob{
+			fn: func(tx *sql.Tx) error {
+				close(started)
+				<-release
+				return nil
+			},
+			// Ends its batch, so later jobs are collected afresh
+			onCommit: func() {},
+		})
+	}()
+	<-started
+
+	return func() {
+		close(release)
+		if err := <-done; err != nil {
+			t.Errorf("blocking job failed: %v", err)
+		}
+	}
+}
+
+// waitQueued waits until n jobs are queued behind the running one
+func waitQueued(t *testing.T, w *writer, n int) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(w.jobs) < n {
+		if time.Now().After(deadline) {
+			t.Fatalf("only %d of %d jobs queued", len(w.jobs), n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func insertItem(name string) func(tx *sql.Tx) error {
+	return func(tx *sql.Tx) error {
+		_, err := tx.Exec(`INSERT INTO items (name) VALUES (?)`, name)
+		return err
+	}
+}
+
+func TestWriterSavepointIsolation(t *testing.T) {
+	failing := errors.New("job failed")
+
+	tests := []struct {
+		name    string
+		jobs    []func(tx *sql.Tx) error
+		wantErr []bool
+		want    []string
+	}{
+		{
+			name:    "all succeed",
+			jobs:    []func(tx *sql.Tx) error{insertItem("a"), insertItem("b"), insertItem("c")},
+			wantErr: []bool{false, false, false},
+			want:    []string{"a", "b", "c"},
+		},
+		{
+			name: "failure after writing is rolled back alone",
+			jobs: []func(tx *sql.Tx) error{
+				insertItem("a"),
+				func(tx *sql.Tx) error {
+					if err := insertItem("b")(tx); err != nil {
+						return err
+					}
+					return failing
+				},
+				insertItem("c"),
+			},
+			wantErr: []bool{false, true, false},
+			want:    []string{"a", "c"},
+		},
+		{
+			name:    "constraint violation doesn't fail the batch",
+			jobs:    []func(tx *sql.Tx) error{insertItem("a"), insertItem("a"), insertItem("b")},
+			wantErr: []bool{false, true, false},
+			want:    []string{"a", "b"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w, db := newTestWriter(t)
+			release := blockWriter(t, w)
+
+			// Queue in order, so the batch runs the jobs in this order
+			results := make([]chan error, len(tt.jobs))
+			for i, fn := range tt.jobs {
+				results[i] = make(chan error, 1)
+				go func() { results[i] <- w.submit(writeJob{fn: fn}) }()
+				waitQueued(t, w, i+1)
+			}
+			release()
+
+			for i, result := range results {
+				if err := <-result; (err != nil) != tt.wantErr[i] {
+					t.Errorf("job %d: got error %v, want error %v", i, err, tt.wantErr[i])
+				}
+			}
+
+			rows, err := db.Query(`SELECT name FROM items ORDER BY name`)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer rows.Close()
+			var got []string
+			for rows.Next() {
+				var name string
+				if err := rows.Scan(&name); err != nil {
+					t.Fatal(err)
+				}
+				got = append(got, name)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("got items %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("got items %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestWriterOnCommitOrdering(t *testing.T) {
+	tests := []struct {
+		name      string
+		failFirst bool
+		want      []string
+	}{
+		{"runs before the next write", false, []string{"first", "commit", "second"}},
+		{"skipped when the job fails", true, []string{"first", "second"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w, _ := newTestWriter(t)
+			release := blockWriter(t, w)
+
+			var mu sync.Mutex
+			var events []string
+			record := func(event string) {
+				mu.Lock()
+				events = append(events, event)
+				mu.Unlock()
+			}
+
+			first := make(chan error, 1)
+			go func() {
+				first <- w.submit(writeJob{
+					fn: func(tx *sql.Tx) error {
+						record("first")
+						if tt.failFirst {
+							return errors.New("job failed")
+						}
+						return nil
+					},
+					onCommit: func() { record("commit") },
+				})
+			}()
+			waitQueued(t, w, 1)
+
+			second := make(chan error, 1)
+			go func() {
+				second <- w.submit(writeJob{fn: func(tx *sql.Tx) error {
+					record("second")
+					return nil
+				}})
+			}()
+			waitQueued(t, w, 2)
+			release()
+
+			if err := <-first; (err != nil) != tt.failFirst {
+				t.Fatalf("first job: %v", err)
+			}
+			if err := <-second; err != nil {
+				t.Fatalf("second job: %v", err)
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			if len(events) != len(tt.want) {
+				t.Fatalf("got %v, want %v", events, tt.want)
+			}
+			for i := range events {
+				if events[i] != tt.want[i] {
+					t.Fatalf("got %v, want %v", events, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestWriterQueueFull(t *testing.T) {
+	if testing.Short() {
+		t.Skip("waits for the write queue timeout")
+	}
+
+	w, _ := newTestWriter(t)
+	release := blockWriter(t, w)
+
+	var queued sync.WaitGroup
+	for i := 0; i < writeQueueSize; i++ {
+		queued.Add(1)
+		go func() {
+			defer queued.Done()
+			w.submit(writeJob{fn: func(tx *sql.Tx) error { return nil }})
+		}()
+	}
+	waitQueued(t, w, writeQueueSize)
+
+	start := time.Now()
+	err := w.submit(writeJob{fn: func(tx *sql.Tx) error { return nil }})
+	if !errors.Is(err, ErrWriteQueueFull) {
+		t.Fatalf("got %v, want %v", err, ErrWriteQueueFull)
+	}
+	if waited := time.Since(start); waited < writeQueueTimeout {
+		t.Fatalf("gave up after %v, before the %v timeout", waited, writeQueueTimeout)
+	}
+
+	release()
+	queued.Wait()
+}
+
+func TestWriterClosed(t *testing.T) {
+	w, _ := newTestWriter(t)
+	w.close()
+
+	err := w.submit(writeJob{fn: func(tx *sql.Tx) error { return nil }})
+	if !errors.Is(err, ErrClosed) {
+		t.Fatalf("got %v, want %v", err, ErrClosed)
+	}
+
+	// Closing again must not panic
+	w.close()
+}
@@ -0,0 +1,38 @@
+package main
+
+import "lanvochat/database"
+
+// SaveDraft keeps the unsent message typed into a conversation, so it
+// survives switching conversations and restarts. A blank draft clears it.
+func (a *App) SaveDraft(conversationID, content string) error {
+	if err := a.requireUnlocked(); err != nil {
+		return err
+	}
+	return a.db.SaveDraft(conversationID, content)
+}
+
+// GetDraft returns the draft for a conversation, with empty content if
+// there is none
+func (a *App) GetDraft(conversationID string) (database.Draft, error) {
+	if err := a.requireUnlocked(); err != nil {
+		return database.Draft{}, err
+	}
+	return a.db.GetDraft(conversationID)
+}
+
+// GetDrafts returns every saved draft, for marking conversations that have one
+func (a *App) GetDrafts() ([]database.Draft, error) {
+	if err := a.requireUnlocked(); err != nil {
+		return nil, err
+	}
+	return a.db.GetDrafts()
+}
+
+// ClearDraft discards the draft for a conversation, typically once it
+// has been sent
+func (a *App) ClearDraft(conversationID string) error {
+	if err := a.requireUnlocked(); err != nil {
+		return err
+	}
+	return a.db.ClearDraft(conversationID)
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"lanvochat/database"
+	"os"
+	"time"
+
+	"github.com/wailsapp/wails/v2/pkg/runtime"
+)
+
+// exportExtensions maps export formats to file extensions
+var exportExtensions = map[string]string{
+	database.ExportJSON: "json",
+	database.ExportHTML: "html",
+	database.ExportText: "txt",
+}
+
+// ExportHistory exports a conversation, or the whole history when
+// conversationID is empty, to a file the user picks. Format is "json",
+// "html" or "text". Returns the saved path, or "" if the user cancelled.
+func (a *App) ExportHistory(conversationID, format string) (string, error) {
+	if err := a.requireUnlocked(); err != nil {
+		return "", err
+	}
+	ext, ok := exportExtensions[format]
+	if !ok {
+		return "", fmt.Errorf("unknown export format %q", format)
+	}
+
+	path, err := runtime.SaveFileDialog(a.ctx, runtime.SaveDialogOptions{
+		Title:           "Export chat history",
+		DefaultFilename: fmt.Sprintf("lanvochat-%s.%s", time.Now().Format("20060102"), ext),
+		Filters:         []runtime.FileFilter{{DisplayName: "*." + ext, Pattern: "*." + ext}},
+	})
+	if err != nil || path == "" {
+		return "", err
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to create export file: %w", err)
+	}
+
+	err = a.db.ExportHistory(file, database.ExportOptions{
+		Format:         format,
+		ConversationID: conversationID,
+		Names:          map[string]string{a.localPeerID: a.currentName()},
+	})
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path)
+		return "", fmt.Errorf("failed to export history: %w", err)
+	}
+
+	return path, nil
+}
+
+// ImportHistory merges a JSON export picked by the user into the history,
+// skipping messages that are already stored
+func (a *App) ImportHistory() (database.ImportResult, error) {
+	if err := a.requireUnlocked(); err != nil {
+		return database.ImportResult{}, err
+	}
+
+	path, err := runtime.OpenFileDialog(a.ctx, runtime.OpenDialogOptions{
+		Title:   "Import chat history",
+		Filters: []runtime.FileFilter{{DisplayName: "LanvoChat export (*.json)", Pattern: "*.json"}},
+	})
+	if err != nil || path == "" {
+		return database.ImportResult{}, err
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return database.ImportResult{}, fmt.Errorf("failed to open export file: %w", err)
+	}
+	defer file.Close()
+
+	result, err := a.db.ImportHistory(file)
+	if err != nil {
+		return database.ImportResult{}, err
+	}
+
+	if result.Messages > 0 {
+		a.emitUnreadCounts()
+	}
+	return result, nil
+}
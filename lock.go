@@ -0,0 +1,234 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/wailsapp/wails/v2/pkg/runtime"
+)
+
+const (
+	// maxUnlockAttempts is how many wrong PINs are allowed before a cooldown
+	maxUnlockAttempts = 5
+	// unlockCooldown is how long unlocking is refused after too many failures
+	unlockCooldown = 30 * time.Second
+	// idleCheckInterval is how often the idle timer is evaluated
+	idleCheckInterval = 15 * time.Second
+)
+
+// ErrAppLocked is returned by bindings that expose message content while locked
+var ErrAppLocked = errors.New("application is locked")
+
+// appLock tracks the application lock state and user activity
+type appLock struct {
+	mu             sync.Mutex
+	locked         bool
+	idleTimeout    time.Duration
+	lastActivity   time.Time
+	failedAttempts int
+	cooldownUntil  time.Time
+}
+
+// initAppLock loads the lock configuration and starts locked if a PIN is set
+func (a *App) initAppLock(ctx context.Context) {
+	timeout, err := a.db.LockIdleTimeout()
+	if err != nil {
+		log.Printf("Warning: Failed to load idle timeout: %v", err)
+	}
+
+	enabled, err := a.db.HasLockPassphrase()
+	if err != nil {
+		log.Printf("Warning: Failed to load app lock: %v", err)
+	}
+
+	a.lock.mu.Lock()
+	a.lock.idleTimeout = timeout
+	a.lock.lastActivity = time.Now()
+	a.lock.locked = enabled
+	a.lock.mu.Unlock()
+
+	go a.idleLockRoutine(ctx)
+}
+
+// idleLockRoutine locks the app once it has been idle for the configured timeout
+func (a *App) idleLockRoutine(ctx context.Context) {
+	ticker := time.NewTicker(idleCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			a.lock.mu.Lock()
+			idle := !a.lock.locked && a.lock.idleTimeout > 0 &&
+				time.Since(a.lock.lastActivity) > a.lock.idleTimeout
+			a.lock.mu.Unlock()
+
+			if !idle {
+				continue
+			}
+			if enabled, err := a.db.HasLockPassphrase(); err != nil || !enabled {
+				continue
+			}
+
+			log.Println("Locking application after idle timeout")
+			a.LockApp()
+		}
+	}
+}
+
+// requireUnlocked returns ErrAppLocked while the application is locked
+func (a *App) requireUnlocked() error {
+	a.lock.mu.Lock()
+	defer a.lock.mu.Unlock()
+
+	if a.lock.locked {
+		return ErrAppLocked
+	}
+	return nil
+}
+
+// IsAppLocked reports whether the application lock is engaged
+func (a *App) IsAppLocked() bool {
+	return a.requireUnlocked() != nil
+}
+
+// IsAppLockEnabled reports whether a lock PIN/passphrase is configured
+func (a *App) IsAppLockEnabled() (bool, error) {
+	return a.db.HasLockPassphrase()
+}
+
+// LockApp engages the application lock immediately
+func (a *App) LockApp() error {
+	enabled, err := a.db.HasLockPassphrase()
+	if err != nil {
+		return err
+	}
+	if !enabled {
+		return fmt.Errorf("no lock PIN is set")
+	}
+
+	a.lock.mu.Lock()
+	a.lock.locked = true
+	a.lock.mu.Unlock()
+
+	if nm := a.currentNetwork(); nm != nil {
+		nm.SetEventsHeld(true)
+	}
+	if a.ctx != nil {
+		runtime.EventsEmit(a.ctx, "appLocked")
+	}
+	return nil
+}
+
+// UnlockApp releases the application lock and delivers messages received meanwhile
+func (a *App) UnlockApp(passphrase string) error {
+	a.lock.mu.Lock()
+	if !a.lock.locked {
+		a.lock.mu.Unlock()
+		return nil
+	}
+	if wait := time.Until(a.lock.cooldownUntil); wait > 0 {
+		a.lock.mu.Unlock()
+		return fmt.Errorf("too many failed attempts, try again in %d seconds", int(wait.Seconds())+1)
+	}
+	a.lock.mu.Unlock()
+
+	ok, err := a.db.VerifyLockPassphrase(passphrase)
+	if err != nil {
+		return err
+	}
+
+	a.lock.mu.Lock()
+	if !ok {
+		a.lock.failedAttempts++
+		if a.lock.failedAttempts >= maxUnlockAttempts {
+			a.lock.failedAttempts = 0
+			a.lock.cooldownUntil = time.Now().Add(unlockCooldown)
+		}
+		a.lock.mu.Unlock()
+		return fmt.Errorf("incorrect PIN")
+	}
+	a.lock.locked = false
+	a.lock.failedAttempts = 0
+	a.lock.lastActivity = time.Now()
+	a.lock.mu.Unlock()
+
+	if a.ctx != nil {
+		runtime.EventsEmit(a.ctx, "appUnlocked")
+	}
+	if nm := a.currentNetwork(); nm != nil {
+		nm.SetEventsHeld(false)
+	}
+	return nil
+}
+
+// SetAppLockPIN sets or changes the lock PIN/passphrase. The current PIN
+// is required when one is already set.
+func (a *App) SetAppLockPIN(currentPIN, newPIN string) error {
+	if err := a.requireUnlocked(); err != nil {
+		return err
+	}
+	if err := a.verifyCurrentPIN(currentPIN); err != nil {
+		return err
+	}
+	return a.db.SetLockPassphrase(newPIN)
+}
+
+// DisableAppLock removes the lock PIN/passphrase
+func (a *App) DisableAppLock(currentPIN string) error {
+	if err := a.requireUnlocked(); err != nil {
+		return err
+	}
+	if err := a.verifyCurrentPIN(currentPIN); err != nil {
+		return err
+	}
+	return a.db.ClearLockPassphrase()
+}
+
+// SetAutoLockTimeout sets the idle time in minutes before the app locks;
+// zero disables automatic locking
+func (a *App) SetAutoLockTimeout(minutes int) error {
+	if err := a.requireUnlocked(); err != nil {
+		return err
+	}
+
+	timeout := time.Duration(minutes) * time.Minute
+	if err := a.db.SetLockIdleTimeout(timeout); err != nil {
+		return err
+	}
+
+	a.lock.mu.Lock()
+	a.lock.idleTimeout = timeout
+	a.lock.mu.Unlock()
+	return nil
+}
+
+// ReportActivity resets the idle timer; the frontend calls it on user input
+func (a *App) ReportActivity() {
+	a.lock.mu.Lock()
+	a.lock.lastActivity = time.Now()
+	a.lock.mu.Unlock()
+}
+
+// verifyCurrentPIN checks the existing PIN, if any
+func (a *App) verifyCurrentPIN(pin string) error {
+	enabled, err := a.db.HasLockPassphrase()
+	if err != nil || !enabled {
+		return err
+	}
+
+	ok, err := a.db.VerifyLockPassphrase(pin)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("incorrect PIN")
+	}
+	return nil
+}
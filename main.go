@@ -6,6 +6,7 @@ package main
 import (
 	"embed"
 	"log"
+	"os"
 
 	"github.com/wailsapp/wails/v2"
 	"github.com/wailsapp/wails/v2/pkg/options"
@@ -19,11 +20,17 @@ import (
 var assets embed.FS
 
 func main() {
+	// Separate profiles keep separate identities and histories
+	profileName, err := parseProfileFlag(os.Args[1:])
+	if err != nil {
+		log.Fatal("Error:", err.Error())
+	}
+
 	// Create an instance of the app structure
-	app := NewApp()
+	app := NewApp(profileName)
 
 	// Create application with options
-	err := wails.Run(&options.App{
+	err = wails.Run(&options.App{
 		Title:  "LanvoChat",
 		Width:  1024,
 		Height: 768,
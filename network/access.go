@@ -0,0 +1,149 @@
+package network
+
+import (
+	"log"
+	"net"
+	"sync"
+
+	"github.com/wailsapp/wails/v2/pkg/runtime"
+)
+
+// AccessRule blocks or allows a peer identity or an IP/subnet
+type AccessRule struct {
+	Allow  bool
+	PeerID string
+	CIDR   string
+}
+
+// AccessPolicy decides which peers may be discovered and message us.
+// Block rules always win; in allow-list-only mode a peer must also match
+// an allow rule by identity or address.
+type AccessPolicy struct {
+	mu            sync.RWMutex
+	allowListOnly bool
+	blockedPeers  map[string]bool
+	allowedPeers  map[string]bool
+	blockedNets   []*net.IPNet
+	allowedNets   []*net.IPNet
+}
+
+// NewAccessPolicy creates a policy that accepts everyone
+func NewAccessPolicy() *AccessPolicy {
+	return &AccessPolicy{
+		blockedPeers: make(map[string]bool),
+		allowedPeers: make(map[string]bool),
+	}
+}
+
+// Update replaces the policy's rules
+func (p *AccessPolicy) Update(rules []AccessRule, allowListOnly bool) {
+	blockedPeers := make(map[string]bool)
+	allowedPeers := make(map[string]bool)
+	var blockedNets, allowedNets []*net.IPNet
+
+	for _, rule := range rules {
+		if rule.PeerID != "" {
+			if rule.Allow {
+				allowedPeers[rule.PeerID] = true
+			} else {
+				blockedPeers[rule.PeerID] = true
+			}
+			continue
+		}
+
+		_, ipNet, err := net.ParseCIDR(rule.CIDR)
+		if err != nil {
+			log.Printf("Ignoring invalid access rule subnet %q: %v", rule.CIDR, err)
+			continue
+		}
+		if rule.Allow {
+			allowedNets = append(allowedNets, ipNet)
+		} else {
+			blockedNets = append(blockedNets, ipNet)
+		}
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.allowListOnly = allowListOnly
+	p.blockedPeers = blockedPeers
+	p.allowedPeers = allowedPeers
+	p.blockedNets = blockedNets
+	p.allowedNets = allowedNets
+}
+
+// Allows reports whether a peer at the given address is accepted
+func (p *AccessPolicy) Allows(peerID string, ip net.IP) bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if p.blockedPeers[peerID] || containsIP(p.blockedNets, ip) {
+		return false
+	}
+	if !p.allowListOnly {
+		return true
+	}
+	return p.allowedPeers[peerID] || containsIP(p.allowedNets, ip)
+}
+
+// AllowsAddr checks an address before the sender's identity is known.
+// Only address rules can reject here; identity rules are applied later.
+func (p *AccessPolicy) AllowsAddr(ip net.IP) bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if containsIP(p.blockedNets, ip) {
+		return false
+	}
+	if !p.allowListOnly || len(p.allowedPeers) > 0 {
+		return true
+	}
+	return containsIP(p.allowedNets, ip)
+}
+
+// containsIP reports whether any of the subnets contains ip
+func containsIP(nets []*net.IPNet, ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	for _, ipNet := range nets {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// SetAccessPolicy sets the policy enforced on discovery and incoming messages
+func (nm *NetworkManager) SetAccessPolicy(policy *AccessPolicy) {
+	nm.access = policy
+	nm.EnforceAccessPolicy()
+}
+
+// EnforceAccessPolicy drops active peers the current policy rejects.
+// Call it after updating the policy's rules.
+func (nm *NetworkManager) EnforceAccessPolicy() {
+	nm.peersMutex.Lock()
+	var removed []string
+	for peerID, peer := range nm.activePeers {
+		if nm.access.Allows(peerID, net.ParseIP(peer.IP)) {
+			continue
+		}
+
+		delete(nm.activePeers, peerID)
+		removed = append(removed, peerID)
+		log.Printf("Peer %s (%s) removed by access policy", peer.Name, peerID)
+	}
+	nm.peersMutex.Unlock()
+
+	for _, peerID := range removed {
+		if nm.peerStore != nil {
+			nm.peerStore.PeerLost(peerID)
+		}
+
+		if nm.ctx != nil {
+			runtime.EventsEmit(nm.ctx, "peerOffline", peerID)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package network
+
+import (
+	"net"
+	"testing"
+)
+
+func TestAccessPolicy(t *testing.T) {
+	rules := []AccessRule{
+		{PeerID: "blocked"},
+		{CIDR: "192.0.2.0/24"},
+		{Allow: true, PeerID: "friend"},
+		{Allow: true, CIDR: "198.51.100.0/24"},
+		{Allow: true, PeerID: "blocked"},
+		{CIDR: "not a subnet"},
+	}
+
+	tests := []struct {
+		name          string
+		allowListOnly bool
+		peerID        string
+		ip            string
+		want          bool
+	}{
+		{"stranger", false, "stranger", "203.0.113.5", true},
+		{"blocked peer", false, "blocked", "203.0.113.5", false},
+		{"blocked subnet", false, "stranger", "192.0.2.7", false},
+		{"block wins over an allowed subnet", false, "blocked", "198.51.100.7", false},
+		{"stranger in allow-list mode", true, "stranger", "203.0.113.5", false},
+		{"allowed peer", true, "friend", "203.0.113.5", true},
+		{"allowed subnet", true, "stranger", "198.51.100.7", true},
+		{"allowed peer in a blocked subnet", true, "friend", "192.0.2.7", false},
+		{"block wins over an allow for the same peer", true, "blocked", "198.51.100.7", false},
+		{"unknown address", true, "stranger", "", false},
+	}
+
+	policy := NewAccessPolicy()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy.Update(rules, tt.allowListOnly)
+			if got := policy.Allows(tt.peerID, net.ParseIP(tt.ip)); got != tt.want {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAccessPolicyAllowsAddr(t *testing.T) {
+	tests := []struct {
+		name          string
+		rules         []AccessRule
+		allowListOnly bool
+		ip            string
+		want          bool
+	}{
+		{"no rules", nil, false, "203.0.113.5", true},
+		{"blocked subnet", []AccessRule{{CIDR: "203.0.113.0/24"}}, false, "203.0.113.5", false},
+		// Identity rules wait until the sender is known
+		{"blocked peer", []AccessRule{{PeerID: "blocked"}}, false, "203.0.113.5", true},
+		{"allow-list mode without rules", nil, true, "203.0.113.5", false},
+		{"allow-list mode with an allowed peer", []AccessRule{{Allow: true, PeerID: "friend"}}, true, "203.0.113.5", true},
+		{"allow-list mode with an allowed subnet", []AccessRule{{Allow: true, CIDR: "203.0.113.0/24"}}, true, "203.0.113.5", true},
+		{"allow-list mode outside the allowed subnet", []AccessRule{{Allow: true, CIDR: "198.51.100.0/24"}}, true, "203.0.113.5", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy := NewAccessPolicy()
+			policy.Update(tt.rules, tt.allowListOnly)
+			if got := policy.AllowsAddr(net.ParseIP(tt.ip)); got != tt.want {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
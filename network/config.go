@@ -0,0 +1,67 @@
+package network
+
+import "time"
+
+// Config holds the tunable network settings
+type Config struct {
+	// TCPPort is where messages are received and what discovery advertises
+	TCPPort int
+	// AnnounceInterval is how often our presence is multicast
+	AnnounceInterval time.Duration
+	// PeerTimeout is how long a peer may go unseen before it is dropped
+	PeerTimeout time.Duration
+	// ConnectTimeout bounds connecting to a peer to deliver a message
+	ConnectTimeout time.Duration
+}
+
+// DefaultConfig returns the settings used unless configured otherwise
+func DefaultConfig() Config {
+	return Config{
+		TCPPort:          8080,
+		AnnounceInterval: 30 * time.Second,
+		PeerTimeout:      5 * time.Minute,
+		ConnectTimeout:   5 * time.Second,
+	}
+}
+
+// SetConfig replaces the network settings. Timing changes apply to a
+// running manager immediately; a new TCP port takes effect the next time
+// the manager is started.
+func (nm *NetworkManager) SetConfig(cfg Config) {
+	nm.configMutex.Lock()
+	nm.config = cfg
+	nm.configMutex.Unlock()
+
+	// Wake the announce loop so a new interval applies now
+	select {
+	case nm.configChanged <- struct{}{}:
+	default:
+	}
+}
+
+// SetLocalName changes the name announced to peers. A running manager
+// announces the new name straight away.
+func (nm *NetworkManager) SetLocalName(name string) {
+	nm.configMutex.Lock()
+	nm.localName = name
+	nm.configMutex.Unlock()
+
+	select {
+	case nm.configChanged <- struct{}{}:
+	default:
+	}
+}
+
+// currentName returns the name announced to peers
+func (nm *NetworkManager) currentName() string {
+	nm.configMutex.RLock()
+	defer nm.configMutex.RUnlock()
+	return nm.localName
+}
+
+// currentConfig returns the settings in effect
+func (nm *NetworkManager) currentConfig() Config {
+	nm.configMutex.RLock()
+	defer nm.configMutex.RUnlock()
+	return nm.config
+}
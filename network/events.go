@@ -0,0 +1,63 @@
+package network
+
+import (
+	"log"
+
+	"github.com/wailsapp/wails/v2/pkg/runtime"
+)
+
+// maxHeldEvents bounds the content events queued while events are held
+const maxHeldEvents = 1000
+
+// heldEvent is a content event waiting to be released to the frontend
+type heldEvent struct {
+	name string
+	data interface{}
+}
+
+// SetEventsHeld pauses or resumes delivery of events carrying message
+// content. Messages are still received while held; their events are
+// queued and released in order when delivery resumes.
+func (nm *NetworkManager) SetEventsHeld(held bool) {
+	nm.eventsMutex.Lock()
+	nm.eventsHeld = held
+	var pending []heldEvent
+	if !held {
+		pending = nm.heldEvents
+		nm.heldEvents = nil
+	}
+	nm.eventsMutex.Unlock()
+
+	if nm.ctx == nil {
+		return
+	}
+	for _, event := range pending {
+		runtime.EventsEmit(nm.ctx, event.name, event.data)
+	}
+}
+
+// emitContent emits an event carrying message content, queueing it
+// instead while events are held
+func (nm *NetworkManager) emitContent(name string, data interface{}) {
+	if nm.ctx == nil {
+		return
+	}
+
+	nm.eventsMutex.Lock()
+	if !nm.eventsHeld {
+		nm.eventsMutex.Unlock()
+		runtime.EventsEmit(nm.ctx, name, data)
+		return
+	}
+
+	if len(nm.heldEvents) >= maxHeldEvents {
+		log.Printf("Held event queue full, dropping oldest %s event", nm.heldEvents[0].name)
+		nm.heldEvents = nm.heldEvents[1:]
+	}
+	nm.heldEvents = append(nm.heldEvents, heldEvent{name: name, data: data})
+	pending := len(nm.heldEvents)
+	nm.eventsMutex.Unlock()
+
+	// Let a lock screen show a badge without revealing any content
+	runtime.EventsEmit(nm.ctx, "messagesPending", pending)
+}
@@ -6,16 +6,61 @@ import (
 	"log"
 	"net"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
-// Message represents a chat message structure
+// defaultMulticastAddr is the discovery group used outside of any workspace
+const defaultMulticastAddr = "239.255.255.250:1900"
+
+// broadcastConversationID marks messages sent to everyone
+const broadcastConversationID = "broadcast"
+
+// peerSaveInterval is how often an unchanged peer's announcements are
+// passed on to the peer store, to keep its last seen time current
+const peerSaveInterval = time.Minute
+
+const (
+	// MessageTypeChat is an ordinary chat message
+	MessageTypeChat = "message"
+	// MessageTypeEdit replaces the content of the message named by TargetID
+	MessageTypeEdit = "edit"
+	// MessageTypeDelete deletes the message named by TargetID for everyone
+	MessageTypeDelete = "delete"
+	// MessageTypeReaction adds, or with Removed withdraws, the emoji in
+	// Content as the sender's reaction to the message named by TargetID
+	MessageTypeReaction = "reaction"
+	// MessageTypePin pins, or with Removed unpins, the message named by
+	// TargetID for everyone in the conversation
+	MessageTypePin = "pin"
+	// MessageTypeMembers replaces a group chat's member list with Members
+	// as of MembersAt, so removed members are dropped everywhere
+	MessageTypeMembers = "members"
+)
+
+// Message represents a chat message structure. PeerID is the recipient
+// of this particular frame. ConversationID is empty for direct messages,
+// "broadcast" for broadcasts and the shared group ID for group chats,
+// whose title and members are carried along with MembersAt, when the
+// member list last changed. Edits, deletions, reactions
+// and pins carry the original message's ID in TargetID, and replies
+// carry the ID of the message they answer in ReplyTo.
 type Message struct {
-	Type      string    `json:"type"`
-	PeerID    string    `json:"peer_id"`
-	SenderID  string    `json:"sender_id"`
-	Content   string    `json:"content"`
-	Timestamp time.Time `json:"timestamp"`
+	Type           string    `json:"type"`
+	MessageID      string    `json:"message_id"`
+	ConversationID string    `json:"conversation_id,omitempty"`
+	Title          string    `json:"title,omitempty"`
+	Members        []string  `json:"members,omitempty"`
+	MembersAt      time.Time `json:"members_at,omitzero"`
+	TargetID       string    `json:"target_id,omitempty"`
+	ReplyTo        string    `json:"reply_to,omitempty"`
+	Removed        bool      `json:"removed,omitempty"`
+	PeerID         string    `json:"peer_id"`
+	SenderID       string    `json:"sender_id"`
+	Content        string    `json:"content"`
+	Timestamp      time.Time `json:"timestamp"`
+	Session        string    `json:"session"`
+	Seq            uint64    `json:"seq"`
 }
 
 // DiscoveryMessage represents a peer discovery message
@@ -31,24 +76,41 @@ type DiscoveryMessage struct {
 // NetworkManager handles all network operations
 type NetworkManager struct {
 	ctx           context.Context
-	db            interface{}
+	store         MessageStore
+	peerStore     PeerStore
 	localPeerID   string
 	localName     string
 	localIP       string
+	workspace     *Workspace
+	access        *AccessPolicy
 	multicastAddr string
-	tcpPort       int
-	udpPort       int
+
+	// Tunable settings and localName; configChanged wakes loops that
+	// depend on them
+	configMutex   sync.RWMutex
+	config        Config
+	configChanged chan struct{}
 
 	multicastConn *net.UDPConn
-	udpConn       *net.UDPConn
 	tcpListener   *net.TCPListener
 	tcpAddr       *net.TCPAddr
 
 	stopChan chan bool
 	wg       sync.WaitGroup
 
+	// Replay protection: our outgoing session and sequence counter, and
+	// the windows of sequence numbers seen from each sender
+	session string
+	seq     atomic.Uint64
+	replay  *ReplayGuard
+
 	activePeers map[string]*PeerInfo
 	peersMutex  sync.RWMutex
+
+	// Content events queued while the app is locked
+	eventsMutex sync.Mutex
+	eventsHeld  bool
+	heldEvents  []heldEvent
 }
 
 // PeerInfo holds information about discovered peers
@@ -59,6 +121,9 @@ type PeerInfo struct {
 	Port     int
 	LastSeen time.Time
 	IsOnline bool
+
+	// savedAt is when the peer was last passed to the peer store
+	savedAt time.Time
 }
 
 // NewNetworkManager creates a new network manager
@@ -67,10 +132,13 @@ func NewNetworkManager(peerID, name, localIP string) *NetworkManager {
 		localPeerID:   peerID,
 		localName:     name,
 		localIP:       localIP,
-		multicastAddr: "239.255.255.250:1900",
-		tcpPort:       8080,
-		udpPort:       8081,
+		access:        NewAccessPolicy(),
+		multicastAddr: defaultMulticastAddr,
+		config:        DefaultConfig(),
+		configChanged: make(chan struct{}, 1),
 		stopChan:      make(chan bool),
+		session:       newRandomID(),
+		replay:        NewReplayGuard(),
 		activePeers:   make(map[string]*PeerInfo),
 	}
 }
@@ -80,9 +148,25 @@ func (nm *NetworkManager) SetContext(ctx context.Context) {
 	nm.ctx = ctx
 }
 
-// SetDatabase sets the database interface
-func (nm *NetworkManager) SetDatabase(db interface{}) {
-	nm.db = db
+// SetStore sets where incoming and outgoing messages are persisted
+func (nm *NetworkManager) SetStore(store MessageStore) {
+	nm.store = store
+}
+
+// SetPeerStore sets where discovered peers and their status are recorded
+func (nm *NetworkManager) SetPeerStore(store PeerStore) {
+	nm.peerStore = store
+}
+
+// SetWorkspace restricts discovery and messaging to members of a workspace.
+// It must be called before Start; nil restores the open default group.
+func (nm *NetworkManager) SetWorkspace(ws *Workspace) {
+	nm.workspace = ws
+	if ws != nil {
+		nm.multicastAddr = ws.MulticastAddr()
+	} else {
+		nm.multicastAddr = defaultMulticastAddr
+	}
 }
 
 // Start begins all network operations
@@ -115,8 +199,12 @@ func (nm *NetworkManager) Stop() {
 	if nm.tcpListener != nil {
 		nm.tcpListener.Close()
 	}
-	if nm.udpConn != nil {
-		nm.udpConn.Close()
+
+	// Peers are out of sight until a new manager hears from them again
+	if nm.peerStore != nil {
+		for peerID := range nm.GetActivePeers() {
+			nm.peerStore.PeerLost(peerID)
+		}
 	}
 
 	log.Println("Network manager stopped")
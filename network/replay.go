@@ -0,0 +1,111 @@
+package network
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	// maxClockSkew is how far a frame's timestamp may drift from our clock
+	maxClockSkew = 2 * time.Minute
+	// replayWindowSize is how many sequence numbers behind the highest seen
+	// are still accepted out of order
+	replayWindowSize = 64
+)
+
+var (
+	// ErrStaleFrame is returned for frames outside the clock skew tolerance
+	ErrStaleFrame = errors.New("frame timestamp outside skew tolerance")
+	// ErrReplayedFrame is returned for frames whose sequence number was already seen
+	ErrReplayedFrame = errors.New("frame replayed")
+)
+
+// replayWindow tracks which recent sequence numbers of one sender session
+// have been seen, IPsec style: a bitmap anchored at the highest sequence
+type replayWindow struct {
+	highest  uint64
+	bitmap   uint64
+	lastSeen time.Time
+}
+
+// ReplayGuard rejects stale and replayed frames per sender session
+type ReplayGuard struct {
+	mu      sync.Mutex
+	windows map[string]*replayWindow
+}
+
+// NewReplayGuard creates an empty replay guard
+func NewReplayGuard() *ReplayGuard {
+	return &ReplayGuard{windows: make(map[string]*replayWindow)}
+}
+
+// Check validates a frame's timestamp and sequence number and records it
+func (g *ReplayGuard) Check(senderID, session string, seq uint64, timestamp time.Time) error {
+	now := time.Now()
+	if timestamp.Before(now.Add(-maxClockSkew)) || timestamp.After(now.Add(maxClockSkew)) {
+		return ErrStaleFrame
+	}
+	if session == "" || seq == 0 {
+		return fmt.Errorf("frame is missing its session sequence number")
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	key := senderID + "/" + session
+	window, exists := g.windows[key]
+	if !exists {
+		window = &replayWindow{}
+		g.windows[key] = window
+	}
+	window.lastSeen = now
+
+	switch {
+	case seq > window.highest:
+		shift := seq - window.highest
+		if shift >= replayWindowSize {
+			window.bitmap = 0
+		} else {
+			window.bitmap <<= shift
+		}
+		window.bitmap |= 1
+		window.highest = seq
+	case window.highest-seq >= replayWindowSize:
+		return ErrReplayedFrame
+	default:
+		bit := uint64(1) << (window.highest - seq)
+		if window.bitmap&bit != 0 {
+			return ErrReplayedFrame
+		}
+		window.bitmap |= bit
+	}
+
+	return nil
+}
+
+// Prune forgets sessions idle long enough that any replay of their frames
+// would already fail the timestamp check
+func (g *ReplayGuard) Prune() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	cutoff := time.Now().Add(-2 * maxClockSkew)
+	for key, window := range g.windows {
+		if window.lastSeen.Before(cutoff) {
+			delete(g.windows, key)
+		}
+	}
+}
+
+// newRandomID returns a random hex identifier
+func newRandomID() string {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		panic(fmt.Sprintf("failed to read random bytes: %v", err))
+	}
+	return hex.EncodeToString(buf)
+}
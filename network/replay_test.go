@@ -0,0 +1,115 @@
+package network
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestReplayGuardWindow(t *testing.T) {
+	tests := []struct {
+		name string
+		seqs []uint64
+		want []error
+	}{
+		{
+			name: "in order",
+			seqs: []uint64{1, 2, 3},
+			want: []error{nil, nil, nil},
+		},
+		{
+			name: "replay of the highest",
+			seqs: []uint64{1, 2, 2},
+			want: []error{nil, nil, ErrReplayedFrame},
+		},
+		{
+			name: "out of order inside the window",
+			seqs: []uint64{5, 3, 4, 3},
+			want: []error{nil, nil, nil, ErrReplayedFrame},
+		},
+		{
+			name: "oldest sequence still inside the window",
+			seqs: []uint64{replayWindowSize, 1},
+			want: []error{nil, nil},
+		},
+		{
+			name: "just behind the window",
+			seqs: []uint64{replayWindowSize + 1, 1},
+			want: []error{nil, ErrReplayedFrame},
+		},
+		{
+			name: "jump past the window forgets older bits",
+			seqs: []uint64{1, 2 + replayWindowSize, 3 + replayWindowSize, 2 + replayWindowSize},
+			want: []error{nil, nil, nil, ErrReplayedFrame},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			guard := NewReplayGuard()
+			for i, seq := range tt.seqs {
+				if err := guard.Check("peer", "session", seq, time.Now()); !errors.Is(err, tt.want[i]) {
+					t.Fatalf("frame %d (seq %d): got %v, want %v", i, seq, err, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestReplayGuardRequiresSequence(t *testing.T) {
+	guard := NewReplayGuard()
+	if err := guard.Check("peer", "", 1, time.Now()); err == nil {
+		t.Fatal("frame without a session accepted")
+	}
+	if err := guard.Check("peer", "session", 0, time.Now()); err == nil {
+		t.Fatal("frame without a sequence number accepted")
+	}
+}
+
+func TestReplayGuardSessionsAreSeparate(t *testing.T) {
+	guard := NewReplayGuard()
+	for _, key := range []struct{ sender, session string }{
+		{"peer-a", "one"},
+		{"peer-a", "two"},
+		{"peer-b", "one"},
+	} {
+		if err := guard.Check(key.sender, key.session, 1, time.Now()); err != nil {
+			t.Fatalf("%s/%s: %v", key.sender, key.session, err)
+		}
+	}
+}
+
+func TestReplayGuardClockSkew(t *testing.T) {
+	tests := []struct {
+		name   string
+		offset time.Duration
+		want   error
+	}{
+		{"now", 0, nil},
+		{"slightly behind", -maxClockSkew + 5*time.Second, nil},
+		{"slightly ahead", maxClockSkew - 5*time.Second, nil},
+		{"too old", -maxClockSkew - 5*time.Second, ErrStaleFrame},
+		{"too far ahead", maxClockSkew + 5*time.Second, ErrStaleFrame},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			guard := NewReplayGuard()
+			err := guard.Check("peer", "session", 1, time.Now().Add(tt.offset))
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("got %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestReplayGuardStaleFrameDoesNotUseSequence(t *testing.T) {
+	guard := NewReplayGuard()
+	if err := guard.Check("peer", "session", 1, time.Now().Add(-time.Hour)); !errors.Is(err, ErrStaleFrame) {
+		t.Fatalf("got %v, want %v", err, ErrStaleFrame)
+	}
+	// The rejected frame must not have consumed its sequence number
+	if err := guard.Check("peer", "session", 1, time.Now()); err != nil {
+		t.Fatalf("fresh frame with the same sequence rejected: %v", err)
+	}
+}
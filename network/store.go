@@ -0,0 +1,33 @@
+package network
+
+import "errors"
+
+var (
+	// ErrDuplicateMessage is returned by a MessageStore for messages it already holds
+	ErrDuplicateMessage = errors.New("message already stored")
+	// ErrStoreUnavailable is wrapped by a MessageStore error when the
+	// message couldn't be saved right now, for example because the store
+	// is overloaded, so the sender should deliver it again
+	ErrStoreUnavailable = errors.New("message store unavailable")
+)
+
+// MessageStore persists messages passing through the network manager.
+// Incoming messages are stored before the frontend is notified, so a
+// message shown in the UI is always in history too.
+type MessageStore interface {
+	// StoreMessage saves msg; incoming is false for messages we sent.
+	// Retransmissions return ErrDuplicateMessage, and messages that
+	// should be sent again an error wrapping ErrStoreUnavailable.
+	StoreMessage(msg Message, incoming bool) error
+}
+
+// PeerStore records peers as discovery sees them come and go, so known
+// peers can be listed while they are offline
+type PeerStore interface {
+	// PeerSeen is called when a peer appears or its name, address or
+	// status changes, and at least every peerSaveInterval while it
+	// keeps announcing
+	PeerSeen(peer PeerInfo)
+	// PeerLost is called when a peer times out or discovery stops
+	PeerLost(peerID string)
+}
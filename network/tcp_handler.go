@@ -1,18 +1,43 @@
 package network
 
 import (
-	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net"
+	"strconv"
+	"strings"
 	"time"
+)
+
+const (
+	// maxFrameSize bounds how much we read from a single TCP connection
+	maxFrameSize = 64 * 1024
+	// ackTimeout is how long a sender waits for a frame to be acknowledged,
+	// which covers the receiver waiting out a full write queue
+	ackTimeout = 15 * time.Second
+	// maxSendAttempts bounds deliveries of a frame the receiver couldn't save
+	maxSendAttempts = 3
+	// retryDelay is the pause before redelivering such a frame
+	retryDelay = 2 * time.Second
+)
 
-	"github.com/wailsapp/wails/v2/pkg/runtime"
+// Acknowledgements written back once a frame has been handled. Receivers
+// that predate them close the connection without one.
+const (
+	ackOK    = "ok"
+	ackRetry = "retry"
 )
 
+// ErrPeerBusy is returned when a peer couldn't save a frame in time, even
+// after it was redelivered
+var ErrPeerBusy = errors.New("peer could not save the message, try again later")
+
 // startTCPListener starts the TCP listener for incoming messages
 func (nm *NetworkManager) startTCPListener() error {
-	addr, err := net.ResolveTCPAddr("tcp", fmt.Sprintf(":%d", nm.tcpPort))
+	port := nm.currentConfig().TCPPort
+	addr, err := net.ResolveTCPAddr("tcp", fmt.Sprintf(":%d", port))
 	if err != nil {
 		return fmt.Errorf("failed to resolve TCP address: %w", err)
 	}
@@ -29,7 +54,7 @@ func (nm *NetworkManager) startTCPListener() error {
 	nm.wg.Add(1)
 	go nm.tcpAcceptRoutine()
 
-	log.Printf("TCP listener started on port %d", nm.tcpPort)
+	log.Printf("TCP listener started on port %d", port)
 	return nil
 }
 
@@ -62,75 +87,318 @@ func (nm *NetworkManager) tcpAcceptRoutine() {
 func (nm *NetworkManager) handleTCPConnection(conn *net.TCPConn) {
 	defer conn.Close()
 
+	// Reject blocked addresses before reading anything
+	remoteIP := conn.RemoteAddr().(*net.TCPAddr).IP
+	if !nm.access.AllowsAddr(remoteIP) {
+		log.Printf("Rejected TCP connection from %s by access policy", remoteIP)
+		return
+	}
+
 	// Set timeouts
 	conn.SetReadDeadline(time.Now().Add(30 * time.Second))
 	conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
 
-	buffer := make([]byte, 4096)
-	n, err := conn.Read(buffer)
+	// Senders half-close after writing, so read the whole frame
+	data, err := io.ReadAll(io.LimitReader(conn, maxFrameSize))
 	if err != nil {
 		log.Printf("Error reading from TCP connection: %v", err)
 		return
 	}
 
-	// Parse message
+	// Parse message, rejecting frames from outside our workspace
 	var msg Message
-	if err := json.Unmarshal(buffer[:n], &msg); err != nil {
-		log.Printf("Error parsing TCP message: %v", err)
+	if err := nm.decodeFrame(data, &msg); err != nil {
+		log.Printf("Error parsing TCP message from %s: %v", conn.RemoteAddr(), err)
 		return
 	}
 
-	// Process message
-	nm.processIncomingMessage(msg)
-}
+	// Enforce block/allow rules on the sender's identity
+	if !nm.access.Allows(msg.SenderID, remoteIP) {
+		log.Printf("Rejected message from %s (%s) by access policy", msg.SenderID, remoteIP)
+		return
+	}
 
-// processIncomingMessage processes an incoming message
-func (nm *NetworkManager) processIncomingMessage(msg Message) {
-	log.Printf("Received message from %s: %s", msg.SenderID, msg.Content)
+	// Drop stale or replayed frames
+	if err := nm.replay.Check(msg.SenderID, msg.Session, msg.Seq, msg.Timestamp); err != nil {
+		log.Printf("Rejected message %s from %s: %v", msg.MessageID, msg.SenderID, err)
+		return
+	}
 
-	// Save to database if available
-	if nm.db != nil {
-		// This would call the database SaveMessage function
-		// For now, we'll just log it
-		log.Printf("Would save message to database: %+v", msg)
+	// Only acknowledge once the frame is saved, so the sender redelivers
+	// what we couldn't save
+	ack := ackOK
+	if err := nm.processIncomingMessage(msg); err != nil {
+		ack = ackRetry
+	}
+	conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	if _, err := conn.Write([]byte(ack + "\n")); err != nil {
+		log.Printf("Error acknowledging message %s from %s: %v", msg.MessageID, msg.SenderID, err)
+	}
+}
+
+// processIncomingMessage saves an incoming message and notifies the
+// frontend. It returns an error when the message couldn't be saved and
+// should be sent again; invalid and duplicate messages are dropped.
+func (nm *NetworkManager) processIncomingMessage(msg Message) error {
+	var event string
+	switch msg.Type {
+	case MessageTypeChat:
+		event = "messageReceived"
+		log.Printf("Received message %s from %s", msg.MessageID, msg.SenderID)
+	case MessageTypeEdit:
+		event = "messageEdited"
+		log.Printf("Received edit of message %s from %s", msg.TargetID, msg.SenderID)
+	case MessageTypeDelete:
+		event = "messageDeleted"
+		log.Printf("Received deletion of message %s from %s", msg.TargetID, msg.SenderID)
+	case MessageTypeReaction:
+		event = "reactionChanged"
+	case MessageTypePin:
+		event = "pinChanged"
+		log.Printf("Received pin change of message %s from %s", msg.TargetID, msg.SenderID)
+	case MessageTypeMembers:
+		event = "groupMembersChanged"
+		log.Printf("Received member list of %s from %s", msg.ConversationID, msg.SenderID)
+	default:
+		log.Printf("Ignoring message %s of unknown type %q from %s", msg.MessageID, msg.Type, msg.SenderID)
+		return nil
 	}
 
-	// Emit event to frontend
-	if nm.ctx != nil {
-		runtime.EventsEmit(nm.ctx, "messageReceived", msg)
+	// Persist before notifying the frontend; retransmissions stop here
+	if nm.store != nil {
+		err := nm.store.StoreMessage(msg, true)
+		if errors.Is(err, ErrDuplicateMessage) {
+			log.Printf("Ignoring duplicate message %s from %s", msg.MessageID, msg.SenderID)
+			return nil
+		}
+		if errors.Is(err, ErrStoreUnavailable) {
+			log.Printf("Deferring message %s from %s: %v", msg.MessageID, msg.SenderID, err)
+			return err
+		}
+		if err != nil {
+			// Nothing unsaved reaches the frontend
+			log.Printf("Error saving message %s from %s: %v", msg.MessageID, msg.SenderID, err)
+			return nil
+		}
 	}
+
+	// Emit event to frontend, held back while the app is locked
+	nm.emitContent(event, msg)
+	return nil
 }
 
-// SendMessageToPeer sends a message to a specific peer via TCP
+// SendMessageToPeer sends a direct message to a specific peer via TCP
 func (nm *NetworkManager) SendMessageToPeer(peerID, content string) error {
+	return nm.sendDirect(peerID, nm.newMessage(content))
+}
+
+// sendDirect sends a chat message to a single peer and records it
+func (nm *NetworkManager) sendDirect(peerID string, msg Message) error {
+	if err := nm.sendFrame(peerID, msg); err != nil {
+		return err
+	}
+
+	// Record what we sent in history
+	msg.PeerID = peerID
+	return nm.storeOutgoing(msg)
+}
+
+// SendGroupMessage sends a message to every active member of a group
+// chat. The title and member list, versioned by membersAt, travel with
+// each message so members learn about the group from its first message.
+func (nm *NetworkManager) SendGroupMessage(conversationID, title string, members []string, membersAt time.Time, content string) error {
+	return nm.sendGroup(conversationID, title, members, membersAt, nm.newMessage(content))
+}
+
+// sendGroup sends a chat message to a group chat and records it
+func (nm *NetworkManager) sendGroup(conversationID, title string, members []string, membersAt time.Time, msg Message) error {
+	msg.ConversationID = conversationID
+	msg.Title = title
+	msg.Members = members
+	msg.MembersAt = membersAt
+
+	var lastErr error
+	for _, peerID := range members {
+		if peerID == nm.localPeerID {
+			continue
+		}
+		if err := nm.sendFrame(peerID, msg); err != nil {
+			log.Printf("Failed to send group message to peer %s: %v", peerID, err)
+			lastErr = err
+		}
+	}
+
+	if err := nm.storeOutgoing(msg); err != nil {
+		return err
+	}
+	return lastErr
+}
+
+// SendReply sends a chat message answering the message with ID replyTo.
+// ConversationID follows the same convention as for chat messages; a
+// direct reply goes to the single member, and group replies carry the
+// title and members like any group message.
+func (nm *NetworkManager) SendReply(conversationID, title string, members []string, membersAt time.Time, replyTo, content string) error {
+	msg := nm.newMessage(content)
+	msg.ReplyTo = replyTo
+
+	switch conversationID {
+	case broadcastConversationID:
+		return nm.broadcast(msg)
+	case "":
+		if len(members) != 1 {
+			return fmt.Errorf("a direct reply needs exactly one recipient")
+		}
+		return nm.sendDirect(members[0], msg)
+	default:
+		return nm.sendGroup(conversationID, title, members, membersAt, msg)
+	}
+}
+
+// SendGroupMembers sends a group chat's changed member list, versioned by
+// membersAt, to the recipients: its members and anyone just removed. The
+// change has already been saved locally.
+func (nm *NetworkManager) SendGroupMembers(conversationID, title string, members []string, membersAt time.Time, recipients []string) error {
+	msg := nm.newMessage("")
+	msg.Type = MessageTypeMembers
+	msg.ConversationID = conversationID
+	msg.Title = title
+	msg.Members = members
+	msg.MembersAt = membersAt
+	return nm.sendEach(msg, recipients)
+}
+
+// SendEdit replaces the content of one of our messages, locally and for
+// the peers that received it. ConversationID follows the same convention
+// as for chat messages.
+func (nm *NetworkManager) SendEdit(conversationID string, recipients []string, targetID, content string) error {
+	msg := nm.newMessage(content)
+	msg.Type = MessageTypeEdit
+	msg.ConversationID = conversationID
+	msg.TargetID = targetID
+	return nm.sendChange(msg, recipients)
+}
+
+// SendDelete deletes one of our messages, locally and for the peers that
+// received it
+func (nm *NetworkManager) SendDelete(conversationID string, recipients []string, targetID string) error {
+	msg := nm.newMessage("")
+	msg.Type = MessageTypeDelete
+	msg.ConversationID = conversationID
+	msg.TargetID = targetID
+	return nm.sendChange(msg, recipients)
+}
+
+// SendReaction adds or, with removed set, withdraws our emoji reaction to
+// a message, locally and for the peers in its conversation
+func (nm *NetworkManager) SendReaction(conversationID string, recipients []string, targetID, emoji string, removed bool) error {
+	msg := nm.newMessage(emoji)
+	msg.Type = MessageTypeReaction
+	msg.ConversationID = conversationID
+	msg.TargetID = targetID
+	msg.Removed = removed
+	return nm.sendChange(msg, recipients)
+}
+
+// SendPin pins or, with unpinned set, unpins a message, locally and for
+// the peers in its conversation
+func (nm *NetworkManager) SendPin(conversationID string, recipients []string, targetID string, unpinned bool) error {
+	msg := nm.newMessage("")
+	msg.Type = MessageTypePin
+	msg.ConversationID = conversationID
+	msg.TargetID = targetID
+	msg.Removed = unpinned
+	return nm.sendChange(msg, recipients)
+}
+
+// sendChange applies an edit, deletion, reaction or pin locally, then
+// sends it to each recipient. Applying first validates it and keeps it
+// even when peers are offline.
+func (nm *NetworkManager) sendChange(msg Message, recipients []string) error {
+	if nm.store != nil {
+		if err := nm.store.StoreMessage(msg, false); err != nil {
+			return err
+		}
+	}
+	return nm.sendEach(msg, recipients)
+}
+
+// sendEach sends a frame to each recipient but us, returning the last
+// failure
+func (nm *NetworkManager) sendEach(msg Message, recipients []string) error {
+	var lastErr error
+	for _, peerID := range recipients {
+		if peerID == nm.localPeerID {
+			continue
+		}
+		if err := nm.sendFrame(peerID, msg); err != nil {
+			log.Printf("Failed to send %s of message %s to peer %s: %v", msg.Type, msg.TargetID, peerID, err)
+			lastErr = err
+		}
+	}
+	return lastErr
+}
+
+// newMessage creates an outgoing chat message from us
+func (nm *NetworkManager) newMessage(content string) Message {
+	return Message{
+		Type:      MessageTypeChat,
+		MessageID: newRandomID(),
+		SenderID:  nm.localPeerID,
+		Content:   content,
+		Timestamp: time.Now(),
+		Session:   nm.session,
+	}
+}
+
+// sendFrame delivers a single frame to an active peer over TCP. Each
+// delivery gets its own sequence number for replay protection. A frame
+// the peer couldn't save is delivered again after retryDelay.
+func (nm *NetworkManager) sendFrame(peerID string, msg Message) error {
 	nm.peersMutex.RLock()
 	peer, exists := nm.activePeers[peerID]
+	var target PeerInfo
+	if exists {
+		target = *peer
+	}
 	nm.peersMutex.RUnlock()
 
 	if !exists {
 		return fmt.Errorf("peer %s not found", peerID)
 	}
 
-	// Create message
-	msg := Message{
-		Type:      "message",
-		PeerID:    peerID,
-		SenderID:  nm.localPeerID,
-		Content:   content,
-		Timestamp: time.Now(),
+	msg.PeerID = peerID
+	for attempt := 1; ; attempt++ {
+		err := nm.deliverFrame(target, msg)
+		if !errors.Is(err, ErrPeerBusy) || attempt == maxSendAttempts {
+			return err
+		}
+		log.Printf("Peer %s could not save message %s, retrying", peerID, msg.MessageID)
+
+		select {
+		case <-time.After(retryDelay):
+		case <-nm.stopChan:
+			return err
+		}
 	}
+}
 
-	// Marshal to JSON
-	data, err := json.Marshal(msg)
+// deliverFrame makes one delivery of a frame and waits for the peer to
+// acknowledge it
+func (nm *NetworkManager) deliverFrame(peer PeerInfo, msg Message) error {
+	msg.Seq = nm.seq.Add(1)
+
+	// Marshal to JSON, signed for our workspace if we're in one
+	data, err := nm.encodeFrame(msg)
 	if err != nil {
 		return fmt.Errorf("failed to marshal message: %w", err)
 	}
 
 	// Connect to peer
-	addr := fmt.Sprintf("%s:%d", peer.IP, peer.Port)
-	conn, err := net.DialTimeout("tcp", addr, 5*time.Second)
+	addr := net.JoinHostPort(peer.IP, strconv.Itoa(peer.Port))
+	conn, err := net.DialTimeout("tcp", addr, nm.currentConfig().ConnectTimeout)
 	if err != nil {
-		return fmt.Errorf("failed to connect to peer %s: %w", peerID, err)
+		return fmt.Errorf("failed to connect to peer %s: %w", peer.PeerID, err)
 	}
 	defer conn.Close()
 
@@ -140,7 +408,7 @@ func (nm *NetworkManager) SendMessageToPeer(peerID, content string) error {
 	// Send message
 	_, err = conn.Write(data)
 	if err != nil {
-		return fmt.Errorf("failed to send message to peer %s: %w", peerID, err)
+		return fmt.Errorf("failed to send message to peer %s: %w", peer.PeerID, err)
 	}
 
 	// Ensure data is sent
@@ -148,7 +416,28 @@ func (nm *NetworkManager) SendMessageToPeer(peerID, content string) error {
 		tcpConn.CloseWrite()
 	}
 
-	log.Printf("Message sent to peer %s (%s)", peer.Name, peerID)
+	// Peers without acknowledgements, or rejecting the frame, just close
+	conn.SetReadDeadline(time.Now().Add(ackTimeout))
+	reply, err := io.ReadAll(io.LimitReader(conn, 64))
+	if err != nil {
+		return fmt.Errorf("no acknowledgement from peer %s: %w", peer.PeerID, err)
+	}
+	if strings.TrimSpace(string(reply)) == ackRetry {
+		return fmt.Errorf("%w (peer %s)", ErrPeerBusy, peer.PeerID)
+	}
+
+	log.Printf("Message sent to peer %s (%s)", peer.Name, peer.PeerID)
+	return nil
+}
+
+// storeOutgoing records a message we sent in history
+func (nm *NetworkManager) storeOutgoing(msg Message) error {
+	if nm.store == nil {
+		return nil
+	}
+	if err := nm.store.StoreMessage(msg, false); err != nil {
+		return fmt.Errorf("message sent but not saved: %w", err)
+	}
 	return nil
 }
 
@@ -166,23 +455,38 @@ func (nm *NetworkManager) GetActivePeers() map[string]*PeerInfo {
 	return peers
 }
 
-// BroadcastMessage sends a message to all active peers
+// BroadcastMessage sends one message to all active peers, stored once
+// in the broadcast conversation
 func (nm *NetworkManager) BroadcastMessage(content string) error {
+	return nm.broadcast(nm.newMessage(content))
+}
+
+// broadcast sends a chat message to all active peers and records it
+func (nm *NetworkManager) broadcast(msg Message) error {
 	nm.peersMutex.RLock()
-	peers := make([]*PeerInfo, 0, len(nm.activePeers))
-	for _, peer := range nm.activePeers {
-		peerCopy := *peer
-		peers = append(peers, &peerCopy)
+	peerIDs := make([]string, 0, len(nm.activePeers))
+	for peerID := range nm.activePeers {
+		peerIDs = append(peerIDs, peerID)
 	}
 	nm.peersMutex.RUnlock()
 
+	msg.ConversationID = broadcastConversationID
+
 	var lastErr error
-	for _, peer := range peers {
-		if err := nm.SendMessageToPeer(peer.PeerID, content); err != nil {
-			log.Printf("Failed to send to peer %s: %v", peer.PeerID, err)
+	delivered := 0
+	for _, peerID := range peerIDs {
+		if err := nm.sendFrame(peerID, msg); err != nil {
+			log.Printf("Failed to send to peer %s: %v", peerID, err)
 			lastErr = err
+			continue
 		}
+		delivered++
 	}
 
+	if delivered > 0 || len(peerIDs) == 0 {
+		if err := nm.storeOutgoing(msg); err != nil {
+			return err
+		}
+	}
 	return lastErr
 }
@@ -1,7 +1,6 @@
 package network
 
 import (
-	"encoding/json"
 	"fmt"
 	"log"
 	"net"
@@ -73,9 +72,9 @@ func (nm *NetworkManager) multicastListenRoutine() {
 				continue
 			}
 
-			// Parse message
+			// Parse message, dropping anything outside our workspace
 			var msg DiscoveryMessage
-			if err := json.Unmarshal(buffer[:n], &msg); err != nil {
+			if err := nm.decodeFrame(buffer[:n], &msg); err != nil {
 				continue // Ignore invalid messages
 			}
 
@@ -84,41 +83,61 @@ func (nm *NetworkManager) multicastListenRoutine() {
 				continue
 			}
 
+			// Skip blocked or non-allow-listed peers
+			if !nm.access.Allows(msg.PeerID, srcAddr.IP) {
+				continue
+			}
+
 			// Update peer information
 			nm.updatePeerInfo(msg, srcAddr.IP.String())
 		}
 	}
 }
 
-// multicastBroadcastRoutine broadcasts our presence periodically
+// multicastBroadcastRoutine broadcasts our presence periodically. The
+// port is the one the listener was started on; a new one in the config
+// only applies once the manager is restarted.
 func (nm *NetworkManager) multicastBroadcastRoutine() {
 	defer nm.wg.Done()
 
-	ticker := time.NewTicker(30 * time.Second) // Broadcast every 30 seconds
+	port := nm.currentConfig().TCPPort
+	interval := nm.currentConfig().AnnounceInterval
+	name := nm.currentName()
+	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
 
 	for {
 		select {
 		case <-nm.stopChan:
 			return
+		case <-nm.configChanged:
+			if next := nm.currentConfig().AnnounceInterval; next != interval {
+				interval = next
+				ticker.Reset(interval)
+			}
+			// Peers learn a new name from the next announcement
+			if next := nm.currentName(); next != name {
+				name = next
+				nm.broadcastPresence(port)
+			}
 		case <-ticker.C:
-			nm.broadcastPresence()
+			nm.broadcastPresence(port)
 		}
 	}
 }
 
-// broadcastPresence sends a discovery message
-func (nm *NetworkManager) broadcastPresence() {
+// broadcastPresence sends a discovery message advertising port
+func (nm *NetworkManager) broadcastPresence(port int) {
 	msg := DiscoveryMessage{
 		Type:   "discovery",
 		PeerID: nm.localPeerID,
-		Name:   nm.localName,
+		Name:   nm.currentName(),
 		IP:     nm.localIP,
-		Port:   nm.tcpPort,
+		Port:   port,
 		Status: "online",
 	}
 
-	data, err := json.Marshal(msg)
+	data, err := nm.encodeFrame(msg)
 	if err != nil {
 		log.Printf("Error marshaling discovery message: %v", err)
 		return
@@ -138,9 +157,6 @@ func (nm *NetworkManager) broadcastPresence() {
 
 // updatePeerInfo updates peer information from discovery message
 func (nm *NetworkManager) updatePeerInfo(msg DiscoveryMessage, srcIP string) {
-	nm.peersMutex.Lock()
-	defer nm.peersMutex.Unlock()
-
 	peer := &PeerInfo{
 		PeerID:   msg.PeerID,
 		Name:     msg.Name,
@@ -150,14 +166,32 @@ func (nm *NetworkManager) updatePeerInfo(msg DiscoveryMessage, srcIP string) {
 		IsOnline: msg.Status == "online",
 	}
 
+	// Announcements that change nothing are only saved now and then,
+	// so the read loop doesn't wait on a write for every one
+	nm.peersMutex.Lock()
+	prev, known := nm.activePeers[msg.PeerID]
+	changed := !known || prev.Name != peer.Name || prev.IP != peer.IP || prev.Port != peer.Port || prev.IsOnline != peer.IsOnline
+	save := changed || peer.LastSeen.Sub(prev.savedAt) >= peerSaveInterval
+	if save {
+		peer.savedAt = peer.LastSeen
+	} else {
+		peer.savedAt = prev.savedAt
+	}
 	nm.activePeers[msg.PeerID] = peer
+	nm.peersMutex.Unlock()
+
+	if save && nm.peerStore != nil {
+		nm.peerStore.PeerSeen(*peer)
+	}
 
 	// Emit event to frontend
 	if nm.ctx != nil {
 		runtime.EventsEmit(nm.ctx, "peerDiscovered", peer)
 	}
 
-	log.Printf("Peer discovered: %s (%s) at %s:%d", msg.Name, msg.PeerID, srcIP, msg.Port)
+	if changed {
+		log.Printf("Peer discovered: %s (%s) at %s:%d", msg.Name, msg.PeerID, srcIP, msg.Port)
+	}
 }
 
 // peerCleanupRoutine removes inactive peers
@@ -171,27 +205,36 @@ func (nm *NetworkManager) peerCleanupRoutine() {
 			return
 		case <-ticker.C:
 			nm.cleanupInactivePeers()
+			nm.replay.Prune()
 		}
 	}
 }
 
-// cleanupInactivePeers removes peers that haven't been seen for 5 minutes
+// cleanupInactivePeers removes peers that haven't been seen within the
+// configured peer timeout
 func (nm *NetworkManager) cleanupInactivePeers() {
-	nm.peersMutex.Lock()
-	defer nm.peersMutex.Unlock()
+	timeout := nm.currentConfig().PeerTimeout
 
+	nm.peersMutex.Lock()
 	now := time.Now()
-	timeout := 5 * time.Minute
-
+	var lost []string
 	for peerID, peer := range nm.activePeers {
 		if now.Sub(peer.LastSeen) > timeout {
 			delete(nm.activePeers, peerID)
+			lost = append(lost, peerID)
 			log.Printf("Peer %s (%s) removed due to inactivity", peer.Name, peerID)
+		}
+	}
+	nm.peersMutex.Unlock()
 
-			// Emit offline event
-			if nm.ctx != nil {
-				runtime.EventsEmit(nm.ctx, "peerOffline", peerID)
-			}
+	for _, peerID := range lost {
+		if nm.peerStore != nil {
+			nm.peerStore.PeerLost(peerID)
+		}
+
+		// Emit offline event
+		if nm.ctx != nil {
+			runtime.EventsEmit(nm.ctx, "peerOffline", peerID)
 		}
 	}
 }
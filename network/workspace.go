@@ -0,0 +1,170 @@
+package network
+
+import (
+	"crypto/hmac"
+	"crypto/pbkdf2"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const (
+	// workspaceKDFIterations is the PBKDF2 work factor for workspace keys
+	workspaceKDFIterations = 200000
+	// minPassphraseLength is the shortest workspace passphrase we accept
+	minPassphraseLength = 8
+)
+
+// ErrWorkspaceMismatch is returned for frames that don't belong to our workspace
+var ErrWorkspaceMismatch = errors.New("frame does not belong to this workspace")
+
+// Workspace partitions discovery and messaging between teams sharing a LAN.
+// Members derive the same multicast group and signing key from the
+// workspace name and shared passphrase; everyone else is ignored.
+type Workspace struct {
+	Name          string
+	id            string
+	key           []byte
+	multicastAddr string
+}
+
+// frame is the signed envelope wrapping every payload sent inside a workspace
+type frame struct {
+	Workspace string          `json:"workspace"`
+	Payload   json.RawMessage `json:"payload"`
+	MAC       string          `json:"mac"`
+}
+
+// NewWorkspace derives the workspace identity from its name and passphrase
+func NewWorkspace(name, passphrase string) (*Workspace, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil, fmt.Errorf("workspace name is required")
+	}
+	if len(passphrase) < minPassphraseLength {
+		return nil, fmt.Errorf("workspace passphrase must be at least %d characters", minPassphraseLength)
+	}
+
+	key, err := pbkdf2.Key(sha256.New, passphrase, []byte("lanvochat/workspace/"+name), workspaceKDFIterations, 32)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive workspace key: %w", err)
+	}
+	return newWorkspace(name, key), nil
+}
+
+// RestoreWorkspace rebuilds a workspace from its name and the key returned
+// by Key, so it can be rejoined without the passphrase
+func RestoreWorkspace(name string, key []byte) (*Workspace, error) {
+	if strings.TrimSpace(name) != name || name == "" {
+		return nil, fmt.Errorf("invalid workspace name %q", name)
+	}
+	if len(key) != 32 {
+		return nil, fmt.Errorf("invalid workspace key")
+	}
+	return newWorkspace(name, append([]byte(nil), key...)), nil
+}
+
+// newWorkspace derives the workspace ID and multicast group from its key
+func newWorkspace(name string, key []byte) *Workspace {
+	ws := &Workspace{Name: name, key: key}
+	ws.id = hex.EncodeToString(ws.sum([]byte("id")))[:16]
+
+	// Pick a group inside 239.255.0.0/16 so the workspace stays site-local,
+	// steering clear of the default discovery group.
+	group := ws.sum([]byte("multicast-group"))
+	if group[0] == 255 && group[1] == 250 {
+		group[1] = 249
+	}
+	ws.multicastAddr = fmt.Sprintf("239.255.%d.%d:1900", group[0], group[1])
+
+	return ws
+}
+
+// Key returns the derived workspace key. It stands in for the passphrase,
+// so keep it as private as the passphrase itself.
+func (w *Workspace) Key() []byte {
+	return append([]byte(nil), w.key...)
+}
+
+// ID returns the public workspace identifier carried in every frame
+func (w *Workspace) ID() string {
+	return w.id
+}
+
+// MulticastAddr returns the discovery group derived for this workspace
+func (w *Workspace) MulticastAddr() string {
+	return w.multicastAddr
+}
+
+// sum computes the workspace HMAC over data
+func (w *Workspace) sum(data []byte) []byte {
+	mac := hmac.New(sha256.New, w.key)
+	mac.Write(data)
+	return mac.Sum(nil)
+}
+
+// seal wraps a payload in a signed workspace frame
+func (w *Workspace) seal(payload []byte) ([]byte, error) {
+	f := frame{
+		Workspace: w.id,
+		Payload:   payload,
+		MAC:       hex.EncodeToString(w.sum(append([]byte(w.id), payload...))),
+	}
+	return json.Marshal(f)
+}
+
+// open verifies a workspace frame and returns its payload
+func (w *Workspace) open(f frame) ([]byte, error) {
+	if f.Workspace != w.id {
+		return nil, ErrWorkspaceMismatch
+	}
+
+	mac, err := hex.DecodeString(f.MAC)
+	if err != nil {
+		return nil, fmt.Errorf("invalid frame signature: %w", err)
+	}
+	if !hmac.Equal(mac, w.sum(append([]byte(w.id), f.Payload...))) {
+		return nil, fmt.Errorf("frame signature mismatch")
+	}
+
+	return f.Payload, nil
+}
+
+// encodeFrame marshals v, signing it when a workspace is configured
+func (nm *NetworkManager) encodeFrame(v interface{}) ([]byte, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	if nm.workspace == nil {
+		return data, nil
+	}
+	return nm.workspace.seal(data)
+}
+
+// decodeFrame verifies and unmarshals a frame into v. Outside a workspace
+// only unsigned frames are accepted; inside one only frames signed with
+// the workspace key are.
+func (nm *NetworkManager) decodeFrame(data []byte, v interface{}) error {
+	var f frame
+	if err := json.Unmarshal(data, &f); err != nil {
+		return err
+	}
+
+	if nm.workspace == nil {
+		if f.Workspace != "" {
+			return ErrWorkspaceMismatch
+		}
+		return json.Unmarshal(data, v)
+	}
+
+	payload, err := nm.workspace.open(f)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(payload, v)
+}
@@ -0,0 +1,123 @@
+package network
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+// testWorkspace restores a workspace from a key filled with b, skipping
+// the passphrase KDF
+func testWorkspace(t *testing.T, name string, b byte) *Workspace {
+	t.Helper()
+
+	ws, err := RestoreWorkspace(name, bytes.Repeat([]byte{b}, 32))
+	if err != nil {
+		t.Fatalf("failed to restore workspace: %v", err)
+	}
+	return ws
+}
+
+func TestNewWorkspace(t *testing.T) {
+	a, err := NewWorkspace("team", "correct horse")
+	if err != nil {
+		t.Fatalf("failed to create workspace: %v", err)
+	}
+	b, err := NewWorkspace(" team ", "correct horse")
+	if err != nil {
+		t.Fatalf("failed to create workspace: %v", err)
+	}
+	if a.ID() != b.ID() || a.MulticastAddr() != b.MulticastAddr() {
+		t.Fatal("the same name and passphrase derived different workspaces")
+	}
+
+	restored, err := RestoreWorkspace(a.Name, a.Key())
+	if err != nil {
+		t.Fatalf("failed to restore workspace: %v", err)
+	}
+	if restored.ID() != a.ID() {
+		t.Fatal("restored workspace has a different ID")
+	}
+
+	for _, tt := range []struct{ name, passphrase string }{
+		{"", "correct horse"},
+		{"team", "short"},
+	} {
+		if _, err := NewWorkspace(tt.name, tt.passphrase); err == nil {
+			t.Errorf("created workspace %q with passphrase %q", tt.name, tt.passphrase)
+		}
+	}
+}
+
+func TestWorkspaceFrames(t *testing.T) {
+	ours := testWorkspace(t, "team", 1)
+	sender := &NetworkManager{workspace: ours}
+	frame, err := sender.encodeFrame(Message{MessageID: "m1", Content: "hello"})
+	if err != nil {
+		t.Fatalf("failed to encode frame: %v", err)
+	}
+
+	unsigned, err := (&NetworkManager{}).encodeFrame(Message{MessageID: "m1", Content: "hello"})
+	if err != nil {
+		t.Fatalf("failed to encode frame: %v", err)
+	}
+
+	var f map[string]json.RawMessage
+	if err := json.Unmarshal(frame, &f); err != nil {
+		t.Fatalf("failed to decode frame: %v", err)
+	}
+	f["payload"] = json.RawMessage(`{"message_id":"m1","content":"tampered"}`)
+	tampered, err := json.Marshal(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Another workspace with the same name but a different key, claiming
+	// our workspace ID
+	other := testWorkspace(t, "team", 2)
+	forged, err := other.seal([]byte(`{"message_id":"m1","content":"hello"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	forged = bytes.Replace(forged, []byte(other.ID()), []byte(ours.ID()), 1)
+
+	tests := []struct {
+		name     string
+		receiver *Workspace
+		data     []byte
+		wantErr  bool
+		want     error
+	}{
+		{"same workspace", ours, frame, false, nil},
+		{"unsigned frame outside a workspace", nil, unsigned, false, nil},
+		{"another workspace", other, frame, true, ErrWorkspaceMismatch},
+		{"signed frame outside a workspace", nil, frame, true, ErrWorkspaceMismatch},
+		{"unsigned frame inside a workspace", ours, unsigned, true, ErrWorkspaceMismatch},
+		{"tampered payload", ours, tampered, true, nil},
+		{"signed with another key", ours, forged, true, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			receiver := &NetworkManager{workspace: tt.receiver}
+			var msg Message
+			err := receiver.decodeFrame(tt.data, &msg)
+			if !tt.wantErr {
+				if err != nil {
+					t.Fatalf("failed to decode frame: %v", err)
+				}
+				if msg.MessageID != "m1" || msg.Content != "hello" {
+					t.Fatalf("got %+v, want the message as sent", msg)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatal("decoded a frame from outside the workspace")
+			}
+			if tt.want != nil && !errors.Is(err, tt.want) {
+				t.Fatalf("got %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
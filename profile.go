@@ -0,0 +1,300 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"lanvochat/network"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	goruntime "runtime"
+)
+
+const (
+	// appDirName is the directory created under the data and config dirs
+	appDirName = "lanvochat"
+	// defaultProfile is used when no --profile is given
+	defaultProfile = "default"
+	// databaseFile is the database file name inside a profile's data dir
+	databaseFile = "lanvochat.db"
+	// identityFile holds the profile's peer ID and display name
+	identityFile = "identity.json"
+	// workspaceFile holds the joined workspace's name and derived key
+	workspaceFile = "workspace.json"
+	// defaultLocalName is shown to peers until the user picks a name
+	defaultLocalName = "LanvoChat User"
+)
+
+// profileNamePattern keeps profile names usable as directory names
+var profileNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// profile locates the files of one local identity. Each profile has its
+// own database and identity, so several people or test identities can
+// share a machine.
+type profile struct {
+	name      string
+	dataDir   string
+	configDir string
+}
+
+// identity is how a profile appears to peers. It is kept across restarts
+// so peers and stored history keep referring to the same peer ID.
+type identity struct {
+	PeerID string `json:"peer_id"`
+	Name   string `json:"name"`
+}
+
+// savedWorkspace is the joined workspace, kept so it is rejoined at
+// startup. The derived key is stored instead of the passphrase.
+type savedWorkspace struct {
+	Name string `json:"name"`
+	Key  string `json:"key"`
+}
+
+// parseProfileFlag reads --profile from the command line
+func parseProfileFlag(args []string) (string, error) {
+	flags := flag.NewFlagSet("lanvochat", flag.ContinueOnError)
+	name := flags.String("profile", defaultProfile, "profile holding a separate identity and history")
+	if err := flags.Parse(args); err != nil {
+		return "", err
+	}
+	return *name, nil
+}
+
+// openProfile resolves a profile's directories, creating them if needed
+func openProfile(name string) (*profile, error) {
+	if !profileNamePattern.MatchString(name) {
+		return nil, fmt.Errorf("invalid profile name %q: use letters, digits, '-' and '_'", name)
+	}
+
+	dataRoot, err := userDataDir()
+	if err != nil {
+		return nil, fmt.Errorf("failed to locate data directory: %w", err)
+	}
+	configRoot, err := os.UserConfigDir()
+	if err != nil {
+		return nil, fmt.Errorf("failed to locate config directory: %w", err)
+	}
+
+	p := &profile{
+		name:      name,
+		dataDir:   filepath.Join(dataRoot, appDirName, name),
+		configDir: filepath.Join(configRoot, appDirName, name),
+	}
+	for _, dir := range []string{p.dataDir, p.configDir} {
+		if err := os.MkdirAll(dir, 0o700); err != nil {
+			return nil, fmt.Errorf("failed to create profile directory: %w", err)
+		}
+	}
+	return p, nil
+}
+
+// userDataDir returns the per-user directory for application data:
+// $XDG_DATA_HOME or ~/.local/share on Linux, and the platform's
+// equivalent elsewhere
+func userDataDir() (string, error) {
+	switch goruntime.GOOS {
+	case "windows":
+		if dir := os.Getenv("LocalAppData"); dir != "" {
+			return dir, nil
+		}
+		return os.UserConfigDir()
+	case "darwin", "ios":
+		return os.UserConfigDir()
+	}
+
+	if dir := os.Getenv("XDG_DATA_HOME"); filepath.IsAbs(dir) {
+		return dir, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".local", "share"), nil
+}
+
+// databasePath returns where the profile's database lives
+func (p *profile) databasePath() string {
+	return filepath.Join(p.dataDir, databaseFile)
+}
+
+// loadIdentity reads the profile's identity, creating one with a new
+// peer ID on first run
+func (p *profile) loadIdentity() (identity, error) {
+	data, err := os.ReadFile(filepath.Join(p.configDir, identityFile))
+	if errors.Is(err, os.ErrNotExist) {
+		id := identity{PeerID: newPeerID(), Name: defaultLocalName}
+		return id, p.saveIdentity(id)
+	}
+	if err != nil {
+		return identity{}, fmt.Errorf("failed to read identity: %w", err)
+	}
+
+	var id identity
+	if err := json.Unmarshal(data, &id); err != nil {
+		return identity{}, fmt.Errorf("failed to parse identity: %w", err)
+	}
+	if id.PeerID == "" {
+		return identity{}, fmt.Errorf("identity in %s has no peer ID", p.configDir)
+	}
+	if id.Name == "" {
+		id.Name = defaultLocalName
+	}
+	return id, nil
+}
+
+// saveIdentity writes the profile's identity, replacing it atomically
+func (p *profile) saveIdentity(id identity) error {
+	data, err := json.MarshalIndent(id, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode identity: %w", err)
+	}
+	if err := p.writeConfig(identityFile, data); err != nil {
+		return fmt.Errorf("failed to save identity: %w", err)
+	}
+	return nil
+}
+
+// loadWorkspace returns the workspace joined in an earlier run, or nil
+func (p *profile) loadWorkspace() (*network.Workspace, error) {
+	data, err := os.ReadFile(filepath.Join(p.configDir, workspaceFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read workspace: %w", err)
+	}
+
+	var saved savedWorkspace
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return nil, fmt.Errorf("failed to parse workspace: %w", err)
+	}
+	key, err := hex.DecodeString(saved.Key)
+	if err != nil {
+		return nil, fmt.Errorf("invalid workspace key: %w", err)
+	}
+	return network.RestoreWorkspace(saved.Name, key)
+}
+
+// saveWorkspace keeps the joined workspace for future runs; nil forgets it
+func (p *profile) saveWorkspace(ws *network.Workspace) error {
+	path := filepath.Join(p.configDir, workspaceFile)
+	if ws == nil {
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to forget workspace: %w", err)
+		}
+		return nil
+	}
+
+	data, err := json.MarshalIndent(savedWorkspace{Name: ws.Name, Key: hex.EncodeToString(ws.Key())}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode workspace: %w", err)
+	}
+	if err := p.writeConfig(workspaceFile, data); err != nil {
+		return fmt.Errorf("failed to save workspace: %w", err)
+	}
+	return nil
+}
+
+// writeConfig replaces a file in the config dir atomically. Temporary
+// files are created private, so the result is readable only by the user.
+func (p *profile) writeConfig(name string, data []byte) error {
+	tmp, err := os.CreateTemp(p.configDir, name+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filepath.Join(p.configDir, name))
+}
+
+// newPeerID returns a random peer ID for a new identity
+func newPeerID() string {
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		panic(fmt.Sprintf("failed to read random bytes: %v", err))
+	}
+	return "peer_" + hex.EncodeToString(buf)
+}
+
+// migrateStrayDatabase moves a lanvochat.db left in the working directory
+// by older versions into the default profile, unless that profile already
+// has a database of its own
+func (p *profile) migrateStrayDatabase() error {
+	if p.name != defaultProfile {
+		return nil
+	}
+
+	stray, err := filepath.Abs(databaseFile)
+	if err != nil {
+		return err
+	}
+	target := p.databasePath()
+	if stray == target {
+		return nil
+	}
+	if _, err := os.Stat(stray); err != nil {
+		return nil
+	}
+	if _, err := os.Stat(target); err == nil {
+		log.Printf("Ignoring %s: profile %s already has a database", stray, p.name)
+		return nil
+	}
+
+	// Side files carry committed data that isn't in the main file yet
+	for _, suffix := range []string{"", "-wal", "-shm", "-journal"} {
+		if _, err := os.Stat(stray + suffix); err != nil {
+			continue
+		}
+		if err := moveFile(stray+suffix, target+suffix); err != nil {
+			return fmt.Errorf("failed to move %s: %w", stray+suffix, err)
+		}
+	}
+
+	log.Printf("Moved database from %s to %s", stray, target)
+	return nil
+}
+
+// moveFile renames a file, copying it when src and dst are on different
+// filesystems
+func moveFile(src, dst string) error {
+	if err := os.Rename(src, dst); err == nil {
+		return nil
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(dst)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(dst)
+		return err
+	}
+
+	in.Close()
+	return os.Remove(src)
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"lanvochat/database"
+	"log"
+	"time"
+
+	"github.com/wailsapp/wails/v2/pkg/runtime"
+)
+
+// pruneInterval is how often retention policies are applied
+const pruneInterval = time.Hour
+
+// startPruner applies retention policies shortly after startup and then
+// periodically until ctx is done
+func (a *App) startPruner(ctx context.Context) {
+	go func() {
+		// Let startup finish before touching a possibly large history
+		timer := time.NewTimer(time.Minute)
+		defer timer.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-timer.C:
+				if _, err := a.pruneHistory(); err != nil {
+					log.Printf("Error pruning history: %v", err)
+				}
+				timer.Reset(pruneInterval)
+			}
+		}
+	}()
+}
+
+// pruneHistory applies retention policies and notifies the frontend
+func (a *App) pruneHistory() (int64, error) {
+	pruned, err := a.db.PruneHistory(time.Now())
+	if pruned > 0 {
+		log.Printf("Pruned %d messages past their retention policy", pruned)
+		runtime.EventsEmit(a.ctx, "historyPruned", pruned)
+		a.emitUnreadCounts()
+	}
+	return pruned, err
+}
+
+// SetRetentionPolicy sets how many days or messages of a conversation's
+// history are kept; zero for both keeps everything
+func (a *App) SetRetentionPolicy(conversationID string, policy database.RetentionPolicy) error {
+	if err := a.requireUnlocked(); err != nil {
+		return err
+	}
+	return a.db.SetRetentionPolicy(conversationID, policy)
+}
+
+// SetLegalHold exempts a conversation from retention pruning
+func (a *App) SetLegalHold(conversationID string, hold bool) error {
+	if err := a.requireUnlocked(); err != nil {
+		return err
+	}
+	return a.db.SetLegalHold(conversationID, hold)
+}
+
+// PruneHistoryNow applies retention policies immediately and returns how
+// many messages were deleted
+func (a *App) PruneHistoryNow() (int64, error) {
+	if err := a.requireUnlocked(); err != nil {
+		return 0, err
+	}
+	return a.pruneHistory()
+}
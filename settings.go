@@ -0,0 +1,91 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"lanvochat/database"
+	"lanvochat/network"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/wailsapp/wails/v2/pkg/runtime"
+)
+
+// networkConfig converts saved settings into network manager settings
+func networkConfig(s database.Settings) network.Config {
+	return network.Config{
+		TCPPort:          s.TCPPort,
+		AnnounceInterval: time.Duration(s.AnnounceIntervalSeconds) * time.Second,
+		PeerTimeout:      time.Duration(s.PeerTimeoutSeconds) * time.Second,
+		ConnectTimeout:   time.Duration(s.ConnectTimeoutSeconds) * time.Second,
+	}
+}
+
+// loadNetworkConfig returns the saved network settings, falling back to
+// the defaults if they can't be read
+func (a *App) loadNetworkConfig() network.Config {
+	settings, err := a.db.GetSettings()
+	if err != nil {
+		log.Printf("Warning: Failed to load settings, using defaults: %v", err)
+		settings = database.DefaultSettings()
+	}
+	return networkConfig(settings)
+}
+
+// GetSettings returns the current settings
+func (a *App) GetSettings() (database.Settings, error) {
+	if err := a.requireUnlocked(); err != nil {
+		return database.Settings{}, err
+	}
+	return a.db.GetSettings()
+}
+
+// GetDefaultSettings returns the settings a fresh install starts with
+func (a *App) GetDefaultSettings() database.Settings {
+	return database.DefaultSettings()
+}
+
+// UpdateSettings validates and saves the settings and applies them to the
+// running network. Timing changes and the display name apply
+// immediately; a new TCP port restarts networking, and if that fails the
+// previous settings are put back.
+func (a *App) UpdateSettings(settings database.Settings) error {
+	if err := a.requireUnlocked(); err != nil {
+		return err
+	}
+
+	settings.DisplayName = strings.TrimSpace(settings.DisplayName)
+	previous, err := a.db.GetSettings()
+	if err != nil {
+		return err
+	}
+	if err := a.db.SaveSettings(settings); err != nil {
+		return err
+	}
+
+	if nm := a.currentNetwork(); nm != nil && settings.TCPPort != previous.TCPPort {
+		if err := a.restartNetwork(); err != nil {
+			return a.rollBackSettings(previous, err)
+		}
+	} else if nm != nil {
+		nm.SetConfig(networkConfig(settings))
+	}
+	a.applyDisplayName(settings.DisplayName)
+
+	runtime.EventsEmit(a.ctx, "settingsChanged", settings)
+	return nil
+}
+
+// rollBackSettings restores the previous settings and networking after a
+// restart for new settings failed with cause
+func (a *App) rollBackSettings(previous database.Settings, cause error) error {
+	err := fmt.Errorf("networking failed to restart, settings were not changed: %w", cause)
+	if saveErr := a.db.SaveSettings(previous); saveErr != nil {
+		return errors.Join(err, fmt.Errorf("failed to restore previous settings: %w", saveErr))
+	}
+	if restartErr := a.restartNetwork(); restartErr != nil {
+		return errors.Join(err, fmt.Errorf("failed to restart networking with previous settings: %w", restartErr))
+	}
+	return err
+}
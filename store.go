@@ -0,0 +1,185 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"lanvochat/database"
+	"lanvochat/network"
+	"log"
+	"strings"
+	"time"
+)
+
+// messageStore persists network traffic to the database, filing each
+// message under its conversation: the direct chat with the remote peer,
+// the shared group chat, or the broadcast channel.
+type messageStore struct {
+	db          *database.Database
+	localPeerID string
+
+	// onIncoming is called after an incoming message has been stored
+	onIncoming func()
+}
+
+// newMessageStore creates a message store backed by db
+func newMessageStore(db *database.Database, localPeerID string) *messageStore {
+	return &messageStore{db: db, localPeerID: localPeerID}
+}
+
+// StoreMessage implements network.MessageStore. A write the database had
+// no room for, made while it was being swapped or while the encrypted
+// database is locked, is reported as unavailable so the sender delivers
+// it again; nothing is acknowledged before it is saved.
+func (s *messageStore) StoreMessage(msg network.Message, incoming bool) error {
+	err := s.storeMessage(msg, incoming)
+	if errors.Is(err, database.ErrWriteQueueFull) || errors.Is(err, database.ErrClosed) || errors.Is(err, database.ErrLocked) {
+		return fmt.Errorf("%w: %w", network.ErrStoreUnavailable, err)
+	}
+	return err
+}
+
+// storeMessage files a message under its conversation
+func (s *messageStore) storeMessage(msg network.Message, incoming bool) error {
+	switch msg.Type {
+	case network.MessageTypeEdit:
+		return s.write(func() error { return s.applyEdit(msg, incoming) })
+	case network.MessageTypeDelete:
+		// Deleting an unread message changes the unread counts
+		err := s.write(func() error {
+			return s.db.DeleteMessage(msg.TargetID, msg.SenderID, msg.Timestamp)
+		})
+		if err == nil && incoming && s.onIncoming != nil {
+			s.onIncoming()
+		}
+		return err
+	case network.MessageTypeReaction:
+		return s.write(func() error {
+			return s.db.ApplyReaction(msg.TargetID, msg.SenderID, msg.Content, msg.Removed, msg.Timestamp, incoming)
+		})
+	case network.MessageTypePin:
+		return s.write(func() error {
+			return s.db.ApplyPin(msg.TargetID, msg.SenderID, msg.Removed, msg.Timestamp, incoming)
+		})
+	case network.MessageTypeMembers:
+		// Our own member list changes are saved before they are sent
+		if !incoming {
+			return nil
+		}
+		return s.write(func() error {
+			applied, err := s.applyGroupMembers(msg)
+			if err == nil && !applied {
+				return database.ErrDuplicateMessage
+			}
+			return err
+		})
+	}
+
+	record := database.Message{
+		MessageID: msg.MessageID,
+		SenderID:  msg.SenderID,
+		Content:   msg.Content,
+		Timestamp: msg.Timestamp,
+		IsRead:    !incoming,
+		ParentID:  msg.ReplyTo,
+	}
+
+	insert := s.db.InsertMessage
+	switch {
+	case msg.ConversationID == database.BroadcastConversationID:
+		record.ConversationID = database.BroadcastConversationID
+	case strings.HasPrefix(msg.ConversationID, database.ConversationGroup+":"):
+		record.ConversationID = msg.ConversationID
+		if incoming {
+			if err := s.saveIncomingGroup(msg); err != nil {
+				return err
+			}
+			// Only members of the group, as we know it, may post to it
+			insert = s.db.InsertMemberMessage
+		}
+	default:
+		// Direct chats are filed under the other side, whichever way they went
+		record.PeerID = msg.PeerID
+		if incoming {
+			record.PeerID = msg.SenderID
+		}
+		record.ConversationID = database.DirectConversationID(record.PeerID)
+	}
+
+	err := s.write(func() error {
+		_, err := insert(record)
+		return err
+	})
+	if err == nil && incoming && s.onIncoming != nil {
+		s.onIncoming()
+	}
+	return err
+}
+
+// applyEdit stores an edit. Peers' edits are only accepted within the
+// configured edit window; our own are always applied.
+func (s *messageStore) applyEdit(msg network.Message, incoming bool) error {
+	var window time.Duration
+	if incoming {
+		var err error
+		if window, err = s.db.EditWindow(); err != nil {
+			return err
+		}
+	}
+	return s.db.EditMessage(msg.TargetID, msg.SenderID, msg.Content, msg.Timestamp, window)
+}
+
+// write runs a database write. Nothing can be saved while the encrypted
+// database is locked, so writes are refused rather than kept in memory.
+func (s *messageStore) write(apply func() error) error {
+	if s.db.IsLocked() {
+		return database.ErrLocked
+	}
+
+	err := apply()
+	if errors.Is(err, database.ErrDuplicateMessage) {
+		return network.ErrDuplicateMessage
+	}
+	return err
+}
+
+// saveIncomingGroup records a group chat a peer's message belongs to.
+// Peers that don't version the member list can only introduce new groups.
+func (s *messageStore) saveIncomingGroup(msg network.Message) error {
+	if msg.MembersAt.IsZero() {
+		return s.db.SaveGroupConversation(msg.ConversationID, msg.Title, s.groupMembers(msg))
+	}
+	_, err := s.applyGroupMembers(msg)
+	return err
+}
+
+// applyGroupMembers takes a peer's member list for a group chat if it is
+// newer than ours, reporting whether it was
+func (s *messageStore) applyGroupMembers(msg network.Message) (bool, error) {
+	return s.db.ApplyGroupMembers(msg.ConversationID, msg.Title, msg.SenderID, s.groupMembers(msg), msg.MembersAt)
+}
+
+// groupMembers converts a peer's member list to ours. The sender's list
+// excludes the sender and includes us; our list is everyone but us.
+func (s *messageStore) groupMembers(msg network.Message) []string {
+	members := []string{msg.SenderID}
+	for _, peerID := range msg.Members {
+		if peerID != s.localPeerID && peerID != msg.SenderID {
+			members = append(members, peerID)
+		}
+	}
+	return members
+}
+
+// PeerSeen implements network.PeerStore
+func (s *messageStore) PeerSeen(peer network.PeerInfo) {
+	if err := s.db.SavePeer(peer.PeerID, peer.Name, peer.IP); err != nil {
+		log.Printf("Error saving peer %s: %v", peer.PeerID, err)
+	}
+}
+
+// PeerLost implements network.PeerStore
+func (s *messageStore) PeerLost(peerID string) {
+	if err := s.db.UpdatePeerStatus(peerID, false); err != nil {
+		log.Printf("Error saving status of peer %s: %v", peerID, err)
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"lanvochat/database"
+	"lanvochat/network"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	log.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+// newTestStore opens a message store for local peer "me" on a fresh database
+func newTestStore(t *testing.T) (*messageStore, *database.Database) {
+	t.Helper()
+
+	db, err := database.NewDatabase(filepath.Join(t.TempDir(), "store.db"))
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return newMessageStore(db, "me"), db
+}
+
+func chatMessage(id, sender, content string) network.Message {
+	return network.Message{
+		Type:      network.MessageTypeChat,
+		MessageID: id,
+		PeerID:    "me",
+		SenderID:  sender,
+		Content:   content,
+		Timestamp: time.Now(),
+	}
+}
+
+func TestStoreMessageWhileLocked(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "locked.db")
+	db, err := database.NewDatabase(path)
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	if err := db.EnableEncryption("store passphrase"); err != nil {
+		t.Fatalf("failed to enable encryption: %v", err)
+	}
+	db.Close()
+
+	if db, err = database.NewDatabase(path); err != nil {
+		t.Fatalf("failed to reopen database: %v", err)
+	}
+	defer db.Close()
+	store := newMessageStore(db, "me")
+
+	// Nothing is kept in memory, so the sender must be told to retry
+	msg := chatMessage("m1", "peer", "hello")
+	for attempt := 1; attempt <= 2; attempt++ {
+		if err := store.StoreMessage(msg, true); !errors.Is(err, network.ErrStoreUnavailable) {
+			t.Fatalf("attempt %d while locked: got %v, want %v", attempt, err, network.ErrStoreUnavailable)
+		}
+	}
+
+	if err := db.Unlock("store passphrase"); err != nil {
+		t.Fatalf("failed to unlock: %v", err)
+	}
+	if err := store.StoreMessage(msg, true); err != nil {
+		t.Fatalf("redelivery after unlock: %v", err)
+	}
+	if err := store.StoreMessage(msg, true); !errors.Is(err, network.ErrDuplicateMessage) {
+		t.Fatalf("second redelivery: got %v, want %v", err, network.ErrDuplicateMessage)
+	}
+
+	history, err := db.GetMessageHistory("peer", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 1 || history[0].Content != "hello" {
+		t.Fatalf("got %+v, want the message stored once", history)
+	}
+}
+
+func TestStoreGroupMessages(t *testing.T) {
+	store, db := newTestStore(t)
+	group, err := db.CreateGroupConversation("team", []string{"a", "b"})
+	if err != nil {
+		t.Fatalf("failed to create group: %v", err)
+	}
+	if _, err := db.RemoveConversationMember(group.ID, "b"); err != nil {
+		t.Fatalf("failed to remove member: %v", err)
+	}
+
+	groupMessage := func(id, sender string, members []string, membersAt time.Time) network.Message {
+		msg := chatMessage(id, sender, "to the team")
+		msg.ConversationID = group.ID
+		msg.Title = "team"
+		msg.Members = members
+		msg.MembersAt = membersAt
+		return msg
+	}
+
+	tests := []struct {
+		name   string
+		msg    network.Message
+		stored bool
+	}{
+		{"member", groupMessage("m1", "a", []string{"me"}, time.Time{}), true},
+		{"removed member", groupMessage("m2", "b", []string{"a", "me"}, time.Time{}), false},
+		{"outsider with an unversioned list", groupMessage("m3", "x", []string{"a", "me"}, time.Time{}), false},
+		{"outsider with a newer list", groupMessage("m4", "x", []string{"a", "me"}, time.Now().Add(time.Hour)), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := store.StoreMessage(tt.msg, true)
+			if (err == nil) != tt.stored {
+				t.Fatalf("got %v, want stored %v", err, tt.stored)
+			}
+		})
+	}
+
+	c, err := db.GetConversation(group.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(c.Members) != 1 || c.Members[0] != "a" {
+		t.Fatalf("got members %v, want only a", c.Members)
+	}
+	page, err := db.GetMessagesPage(group.ID, database.HistoryCursor{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Messages) != 1 || page.Messages[0].SenderID != "a" {
+		t.Fatalf("got %+v, want only the member's message", page.Messages)
+	}
+}